@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// A failing query recorded in a -baseline file.
+type baselineEntry struct {
+	File  string `yaml:"file"`  // The target the query came from, as it's logged, e.g. `monitors.tf:datadog_monitor.cpu`
+	Query string `yaml:"query"` // The query, exactly; fixing or otherwise changing it takes it out of the baseline
+}
+
+// The failing queries in a -baseline file, which are reported, but don't fail the run. This lets the linter be adopted
+// on a repo with existing broken queries, while still failing on new ones.
+type baseline map[baselineEntry]bool
+
+func loadBaseline(path string) (baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Failed to read baseline: %s", path))
+	}
+
+	var entries []baselineEntry
+
+	err = yaml.Unmarshal(data, &entries)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Failed to unmarshal baseline: %s", path))
+	}
+
+	b := baseline{}
+
+	for _, entry := range entries {
+		b[entry] = true
+	}
+
+	return b, nil
+}
+
+// Write the entries to a -baseline file, sorted so regenerating it gives a readable diff.
+func writeBaseline(path string, entries []baselineEntry) error {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].File != entries[j].File {
+			return entries[i].File < entries[j].File
+		}
+
+		return entries[i].Query < entries[j].Query
+	})
+
+	data, err := yaml.Marshal(entries)
+	if err != nil {
+		return errors.Wrap(err, "Failed to marshal baseline")
+	}
+
+	header := "# Queries that were already failing, generated by datadog-query-linter -write-baseline.\n"
+
+	err = os.WriteFile(path, append([]byte(header), data...), 0o600)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Failed to write baseline: %s", path))
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBaseline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.yaml")
+
+	entries := []baselineEntry{
+		{File: "b.yaml", Query: "avg:bar{*}"},
+		{File: "a.yaml", Query: "default_zero(avg:foo{env:prod})"},
+	}
+
+	if err := writeBaseline(path, entries); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	loaded, err := loadBaseline(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(loaded) != 2 || !loaded[entries[0]] || !loaded[entries[1]] {
+		t.Errorf("Expected both entries in the baseline, got %v", loaded)
+	}
+
+	if loaded[baselineEntry{File: "a.yaml", Query: "avg:bar{*}"}] {
+		t.Errorf("Expected a query to only be baselined for its own file")
+	}
+
+	if _, err := loadBaseline(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Errorf("Expected an error for a missing baseline")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/persona-id/datadog-query-linter/querylint"
+	"github.com/pkg/errors"
+)
+
+// The -benign-error flag, a regexp for the messages of API errors that aren't a problem with the query, which can be
+// repeated.
+type regexpList []*regexp.Regexp
+
+func (r *regexpList) String() string {
+	patterns := make([]string, len(*r))
+
+	for i, pattern := range *r {
+		patterns[i] = pattern.String()
+	}
+
+	return strings.Join(patterns, ",")
+}
+
+func (r *regexpList) Set(value string) error {
+	pattern, err := regexp.Compile(value)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	*r = append(*r, pattern)
+
+	return nil
+}
+
+// Whether the API rejected the query with an error that matched -benign-error. That's a known quirk, like a window too
+// wide for the maximum number of points, rather than a problem with the query's structure, so it's only a warning.
+func isBenign(err error) bool {
+	var mqe *querylint.MetricQueryError
+
+	return errors.As(err, &mqe) && mqe.Kind == querylint.KindBenign
+}
@@ -0,0 +1,107 @@
+//go:build k8s
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// How many resources to ask for at once.
+	clusterPageSize = 500
+
+	// What the targets are logged under when there's no kubeconfig, and the linter is running in the cluster.
+	inClusterName = "in-cluster"
+)
+
+// The DatadogMetric custom resource. It's listed with the dynamic client, so the Datadog operator's types, and
+// everything they pull in, aren't needed.
+//
+//nolint:gochecknoglobals
+var datadogMetricsResource = schema.GroupVersionResource{
+	Group:    "datadoghq.com",
+	Version:  "v1alpha1",
+	Resource: "datadogmetrics",
+}
+
+// List the DatadogMetric custom resources in every namespace of the cluster, for -from-cluster. The cluster is the
+// current context of the kubeconfig (from $KUBECONFIG, or ~/.kube/config), or the one the linter is running in if there
+// isn't a kubeconfig. Each target is logged as `<context>:<namespace>/<name>`.
+func listClusterTargets(ctx context.Context) ([]target, error) {
+	config, name, err := clusterConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Failed to create the Kubernetes client: %s", name))
+	}
+
+	resources := client.Resource(datadogMetricsResource).Namespace(metav1.NamespaceAll)
+
+	var targets []target
+
+	options := metav1.ListOptions{Limit: clusterPageSize}
+
+	for {
+		list, err := resources.List(ctx, options)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Failed to list the DatadogMetrics in %s", name))
+		}
+
+		for _, item := range list.Items {
+			resource := fmt.Sprintf("%s/%s", item.GetNamespace(), item.GetName())
+
+			query, _, err := unstructured.NestedString(item.Object, "spec", "query")
+			if err != nil || query == "" {
+				slog.Warn("DatadogMetric didn't contain a metric query, skipping it", slog.String("file", name+":"+resource))
+				continue
+			}
+
+			targets = append(targets, target{file: name, name: resource, query: query})
+		}
+
+		options.Continue = list.GetContinue()
+		if options.Continue == "" {
+			return targets, nil
+		}
+	}
+}
+
+// Load the client config the way kubectl does: the $KUBECONFIG files merged, or ~/.kube/config, falling back to the
+// service account of the pod the linter is running in. It returns the name the targets are logged under too, which is
+// the current context.
+func clusterConfig() (*rest.Config, string, error) {
+	kubeconfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{},
+	)
+
+	raw, err := kubeconfig.RawConfig()
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Failed to load the kubeconfig")
+	}
+
+	name := raw.CurrentContext
+	if name == "" {
+		name = inClusterName
+	}
+
+	config, err := kubeconfig.ClientConfig()
+	if err != nil {
+		return nil, "", errors.Wrap(err, fmt.Sprintf("Failed to configure the Kubernetes client: %s", name))
+	}
+
+	return config, name, nil
+}
@@ -0,0 +1,181 @@
+//go:build k8s
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Serve two pages of DatadogMetrics to a client with the token `secret`, one of them without a query. Each request is
+// passed to seen first, if it isn't nil.
+func newClusterServer(t *testing.T, seen func(r *http.Request)) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if seen != nil {
+			seen(r)
+		}
+
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		if r.URL.Path != "/apis/datadoghq.com/v1alpha1/datadogmetrics" {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Query().Get("continue") == "" {
+			fmt.Fprint(w, `{"apiVersion":"datadoghq.com/v1alpha1","kind":"DatadogMetricList",`+
+				`"metadata":{"continue":"page2"},"items":[`+
+				`{"apiVersion":"datadoghq.com/v1alpha1","kind":"DatadogMetric",`+
+				`"metadata":{"namespace":"web","name":"requests"},"spec":{"query":"avg:requests{*}"}},`+
+				`{"apiVersion":"datadoghq.com/v1alpha1","kind":"DatadogMetric",`+
+				`"metadata":{"namespace":"web","name":"empty"},"spec":{}}]}`)
+
+			return
+		}
+
+		fmt.Fprint(w, `{"apiVersion":"datadoghq.com/v1alpha1","kind":"DatadogMetricList","metadata":{},"items":[`+
+			`{"apiVersion":"datadoghq.com/v1alpha1","kind":"DatadogMetric",`+
+			`"metadata":{"namespace":"jobs","name":"queue"},"spec":{"query":"avg:queue.depth{*}"}}]}`)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// A kubeconfig whose current context is the cluster served by server, with the given user.
+func clusterKubeconfig(server *httptest.Server, user string) string {
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	return fmt.Sprintf(`
+current-context: test
+contexts:
+- name: test
+  context: {cluster: test, user: test}
+clusters:
+- name: test
+  cluster: {server: %q, certificate-authority-data: %s}
+users:
+- name: test
+  user: %s
+`, server.URL, base64.StdEncoding.EncodeToString(ca), user)
+}
+
+func TestListClusterTargets(t *testing.T) {
+	expected := []target{
+		{file: "test", name: "web/requests", query: "avg:requests{*}"},
+		{file: "test", name: "jobs/queue", query: "avg:queue.depth{*}"},
+	}
+
+	t.Run("every page of the current context's cluster is listed", func(t *testing.T) {
+		server := newClusterServer(t, nil)
+
+		t.Setenv("KUBECONFIG", writeKubeconfig(t, t.TempDir(), "config", clusterKubeconfig(server, "{token: secret}")))
+
+		targets, err := listClusterTargets(context.Background())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if !slices.Equal(targets, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, targets)
+		}
+	})
+
+	t.Run("the $KUBECONFIG files are merged, with the first of each winning", func(t *testing.T) {
+		server := newClusterServer(t, nil)
+		dir := t.TempDir()
+
+		// The cluster is only in the second file, and the first file's user wins.
+		first := writeKubeconfig(t, dir, "first", `
+current-context: test
+users:
+- name: test
+  user: {token: secret}
+`)
+		second := writeKubeconfig(t, dir, "second", clusterKubeconfig(server, "{token: wrong}"))
+
+		t.Setenv("KUBECONFIG", strings.Join([]string{filepath.Join(dir, "missing"), first, second},
+			string(filepath.ListSeparator)))
+
+		targets, err := listClusterTargets(context.Background())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if !slices.Equal(targets, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, targets)
+		}
+	})
+
+	t.Run("impersonation in the kubeconfig is passed on", func(t *testing.T) {
+		var impersonated string
+
+		server := newClusterServer(t, func(r *http.Request) {
+			impersonated = r.Header.Get("Impersonate-User")
+		})
+
+		t.Setenv("KUBECONFIG", writeKubeconfig(t, t.TempDir(), "config",
+			clusterKubeconfig(server, "{token: secret, as: auditor}")))
+
+		_, err := listClusterTargets(context.Background())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if impersonated != "auditor" {
+			t.Errorf("Expected the request to impersonate %q, got %q", "auditor", impersonated)
+		}
+	})
+
+	t.Run("an unauthorized user is an error", func(t *testing.T) {
+		server := newClusterServer(t, nil)
+
+		t.Setenv("KUBECONFIG", writeKubeconfig(t, t.TempDir(), "config", clusterKubeconfig(server, "{token: wrong}")))
+
+		_, err := listClusterTargets(context.Background())
+		if err == nil {
+			t.Errorf("Expected an error for an unauthorized user")
+		}
+	})
+
+	t.Run("no kubeconfig outside a cluster is an error", func(t *testing.T) {
+		t.Setenv("KUBECONFIG", filepath.Join(t.TempDir(), "missing"))
+		t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+		_, err := listClusterTargets(context.Background())
+		if err == nil {
+			t.Errorf("Expected an error without a kubeconfig")
+		}
+	})
+}
+
+func writeKubeconfig(t *testing.T, dir string, name string, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+
+	err := os.WriteFile(path, []byte(contents), 0o600)
+	if err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+
+	return path
+}
@@ -0,0 +1,15 @@
+//go:build !k8s
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// errNoK8sSupport is returned when -from-cluster is set, but the binary was built without the `k8s` tag.
+var errNoK8sSupport = errors.New("built without Kubernetes support, rebuild with `-tags k8s`")
+
+func listClusterTargets(_ context.Context) ([]target, error) {
+	return nil, errNoK8sSupport
+}
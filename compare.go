@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/persona-id/datadog-query-linter/querylint"
+)
+
+// What a query, or one of its metrics, looks like in an account, for -compare-accounts.
+const (
+	presencePresent = "present" // It has data, or at least a series
+	presenceMissing = "missing" // It has no data at all
+	presenceError   = "error"   // The API rejected it
+)
+
+// The second Datadog account, e.g. staging next to prod, that each query is also validated against with
+// -compare-accounts.
+type compareAccount struct {
+	validator *querylint.Validator
+	apiKey    string
+	appKey    string
+	site      string // The account's site, e.g. datadoghq.eu, or empty for the same one as the first account
+}
+
+// The context for calling the API as the account, with its keys and site in place of the first account's.
+func (a *compareAccount) context(ctx context.Context) context.Context {
+	ctx = context.WithValue(ctx, datadog.ContextAPIKeys, map[string]datadog.APIKey{
+		"apiKeyAuth": {Key: a.apiKey},
+		"appKeyAuth": {Key: a.appKey},
+	})
+
+	if a.site != "" {
+		ctx = context.WithValue(ctx, datadog.ContextServerVariables, map[string]string{"site": a.site})
+	}
+
+	return ctx
+}
+
+// A difference between the accounts, in the whole query when Metric is empty, or in one of its metrics.
+type accountDrift struct {
+	Metric  string
+	First   string // The presence in the first account
+	Compare string // The presence in the -compare-accounts account
+}
+
+// Where the two accounts disagree about a query: whether the API accepted it, or whether each of its metrics has data.
+// The metrics are only compared when both accounts accepted the query, since there aren't any results for them
+// otherwise. A query that's accepted by both but has no data in both isn't drift, it's the same problem twice.
+func accountDrifts(first querylint.Result, firstErr error, other querylint.Result, otherErr error) []accountDrift {
+	if (firstErr != nil) != (otherErr != nil) {
+		return []accountDrift{{First: errorPresence(firstErr), Compare: errorPresence(otherErr)}}
+	}
+
+	if firstErr != nil {
+		return nil
+	}
+
+	var drifts []accountDrift
+
+	for i, metric := range first.Metrics {
+		if i >= len(other.Metrics) {
+			break
+		}
+
+		firstPresence, otherPresence := metricPresence(metric.Status), metricPresence(other.Metrics[i].Status)
+		if firstPresence != otherPresence {
+			drifts = append(drifts, accountDrift{
+				Metric:  metric.Metric.CleanMetric,
+				First:   firstPresence,
+				Compare: otherPresence,
+			})
+		}
+	}
+
+	return drifts
+}
+
+func errorPresence(err error) string {
+	if err != nil {
+		return presenceError
+	}
+
+	return presencePresent
+}
+
+func metricPresence(status querylint.Status) string {
+	switch status {
+	case querylint.StatusOK, querylint.StatusSparse:
+		return presencePresent
+	case querylint.StatusError:
+		return presenceError
+	default:
+		return presenceMissing
+	}
+}
+
+// Validate the query against the -compare-accounts account too, and warn about every difference from the first
+// account's result.
+func reportComparison(ctx context.Context, account *compareAccount, file string, line int, query string,
+	result querylint.Result, err error, counts *tally,
+) {
+	other, otherErr := account.validator.Validate(account.context(ctx), query)
+
+	// A call cut short says nothing about the query in either account.
+	if ctx.Err() != nil || isInterrupted(otherErr) {
+		return
+	}
+
+	attrs := []any{
+		slog.String("file", file),
+		lineAttr(line),
+		queryAttr(query),
+		slog.Duration("api_latency", other.APILatency),
+	}
+
+	switch {
+	case otherErr != nil:
+		attrs = append(attrs, slog.Any("err", otherErr))
+	case other.Value != nil:
+		attrs = append(attrs, slog.Float64("value", *other.Value))
+	}
+
+	slog.Info("Query result in the -compare-accounts account", attrs...)
+
+	for _, drift := range accountDrifts(result, err, other, otherErr) {
+		slog.Warn("Query differs between the accounts",
+			slog.String("file", file),
+			lineAttr(line),
+			queryAttr(query),
+			slog.String("metric", drift.Metric),
+			slog.String("first_account", drift.First),
+			slog.String("compare_account", drift.Compare),
+		)
+
+		counts.warn(reasonAccountDrift, file)
+	}
+}
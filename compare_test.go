@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/persona-id/datadog-query-linter/querylint"
+)
+
+func TestAccountDrifts(t *testing.T) {
+	query := "avg:foo{*} + avg:bar{*} + avg:baz{*}"
+	analysis := querylint.ParseQuery(query)
+
+	result := func(statuses ...querylint.Status) querylint.Result {
+		result := querylint.Result{Query: query, Analysis: analysis}
+
+		for i, status := range statuses {
+			result.Metrics = append(result.Metrics, querylint.MetricResult{Metric: analysis.Metrics[i], Status: status})
+		}
+
+		return result
+	}
+
+	errBadQuery := errors.New("error parsing query")
+
+	tests := []struct {
+		name     string
+		first    querylint.Result
+		firstErr error
+		other    querylint.Result
+		otherErr error
+		expected []accountDrift
+	}{
+		{
+			name:  "the same in both accounts",
+			first: result(querylint.StatusOK, querylint.StatusSparse, querylint.StatusNoData),
+			other: result(querylint.StatusSparse, querylint.StatusOK, querylint.StatusMasked),
+		},
+		{
+			name:  "metrics missing from one account",
+			first: result(querylint.StatusOK, querylint.StatusOK, querylint.StatusNoData),
+			other: result(querylint.StatusOK, querylint.StatusNoData, querylint.StatusOK),
+			expected: []accountDrift{
+				{Metric: "avg:bar{*}", First: presencePresent, Compare: presenceMissing},
+				{Metric: "avg:baz{*}", First: presenceMissing, Compare: presencePresent},
+			},
+		},
+		{
+			name:     "the query is rejected by one account",
+			first:    result(querylint.StatusOK, querylint.StatusOK, querylint.StatusOK),
+			otherErr: errBadQuery,
+			expected: []accountDrift{{First: presencePresent, Compare: presenceError}},
+		},
+		{
+			name:     "the query is rejected by both accounts",
+			firstErr: errBadQuery,
+			otherErr: errBadQuery,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			drifts := accountDrifts(test.first, test.firstErr, test.other, test.otherErr)
+			if !slices.Equal(drifts, test.expected) {
+				t.Errorf("Expected %+v, got %+v", test.expected, drifts)
+			}
+		})
+	}
+}
+
+func TestCompareAccountContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), datadog.ContextAPIKeys, map[string]datadog.APIKey{
+		"apiKeyAuth": {Key: "prod-api"},
+		"appKeyAuth": {Key: "prod-app"},
+	})
+
+	account := compareAccount{apiKey: "staging-api", appKey: "staging-app", site: "datadoghq.eu"}
+	ctx = account.context(ctx)
+
+	keys, _ := ctx.Value(datadog.ContextAPIKeys).(map[string]datadog.APIKey)
+	if keys["apiKeyAuth"].Key != "staging-api" || keys["appKeyAuth"].Key != "staging-app" {
+		t.Errorf("Expected the compare account's keys, got %+v", keys)
+	}
+
+	variables, _ := ctx.Value(datadog.ContextServerVariables).(map[string]string)
+	if variables["site"] != "datadoghq.eu" {
+		t.Errorf("Expected the site datadoghq.eu, got %q", variables["site"])
+	}
+}
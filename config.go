@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/persona-id/datadog-query-linter/querylint"
+	"github.com/pkg/errors"
+)
+
+// The settings a run actually uses, once the flags, their defaults and the environment are all resolved, for
+// -print-config. The keys themselves are never printed, only whether they're set.
+type effectiveConfig struct {
+	Flags           map[string]string `json:"flags"`
+	Server          string            `json:"server"`
+	APIKeySet       bool              `json:"api_key_set"`
+	AppKeySet       bool              `json:"app_key_set"`
+	Rules           map[string]string `json:"rules"`
+	Windows         []string          `json:"windows"`
+	ParallelMetrics int               `json:"parallel_metrics"`
+	Files           int               `json:"files"`
+}
+
+// Build the effectiveConfig from every flag in flags, set or not, and what they were resolved into. A flag with a
+// secret in its value, like the -slack-webhook URL, has it redacted.
+func newEffectiveConfig(flags *flag.FlagSet, rules querylint.Rules, validator *querylint.Validator, server string,
+	apiKey string, appKey string, files int,
+) effectiveConfig {
+	config := effectiveConfig{
+		Flags:           map[string]string{},
+		Server:          server,
+		APIKeySet:       apiKey != "",
+		AppKeySet:       appKey != "",
+		Rules:           map[string]string{},
+		ParallelMetrics: validator.MetricConcurrency,
+		Files:           files,
+	}
+
+	flags.VisitAll(func(f *flag.Flag) {
+		config.Flags[f.Name] = redactFlag(f.Name, f.Value.String())
+	})
+
+	for rule, severity := range rules {
+		config.Rules[rule] = severity.String()
+	}
+
+	for _, window := range validator.EffectiveWindows() {
+		config.Windows = append(config.Windows, window.String())
+	}
+
+	return config
+}
+
+// The value of a flag that's safe to print. The -slack-webhook URL is the secret, and a -proxy can have a password.
+func redactFlag(name string, value string) string {
+	switch {
+	case value == "":
+		return value
+	case name == "slack-webhook":
+		return "REDACTED"
+	case name == "proxy":
+		parsed, err := url.Parse(value)
+		if err != nil {
+			return "REDACTED"
+		}
+
+		return parsed.Redacted()
+	default:
+		return value
+	}
+}
+
+// Print the effectiveConfig as indented JSON.
+func writeConfig(w io.Writer, config effectiveConfig) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "Failed to marshal the configuration")
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", data)
+
+	return err //nolint:wrapcheck
+}
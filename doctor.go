@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/persona-id/datadog-query-linter/querylint"
+	"github.com/pkg/errors"
+)
+
+// The query -doctor sends. Every agent reports it, so it has data in any account with an agent, and even when it
+// doesn't, the API accepting it is enough to show the keys and the connection work.
+const doctorQuery = "avg:datadog.agent.running{*}"
+
+// One of the things -doctor checks, and what it found.
+type doctorCheck struct {
+	name   string
+	ok     bool
+	detail string
+}
+
+// Diagnose whether the linter can talk to the API at server, from the env vars (or secrets) that weren't set, and the
+// result of validating doctorQuery. The checks stop at the first one that fails, since the rest depend on it; with a
+// key missing, the query is never sent.
+func doctorChecks(server string, missingKeys []string, result querylint.Result, err error) []doctorCheck {
+	if len(missingKeys) > 0 {
+		return []doctorCheck{{
+			name:   "keys",
+			detail: fmt.Sprintf("%s not set", strings.Join(missingKeys, " and ")),
+		}}
+	}
+
+	checks := []doctorCheck{{name: "keys", ok: true, detail: "API and application keys are set"}}
+
+	var mqe *querylint.MetricQueryError
+	if err != nil && !errors.As(err, &mqe) {
+		return append(checks, doctorCheck{name: "network", detail: err.Error()})
+	}
+
+	if mqe != nil {
+		switch mqe.Kind {
+		case querylint.KindNetwork, querylint.KindTimeout, querylint.KindCanceled:
+			return append(checks, doctorCheck{
+				name:   "network",
+				detail: fmt.Sprintf("couldn't reach %s, check the proxy and firewall: %v", server, mqe.NestedError),
+			})
+		case querylint.KindAuth:
+			return append(checks,
+				doctorCheck{name: "network", ok: true, detail: "reached " + server},
+				doctorCheck{
+					name: "auth",
+					detail: fmt.Sprintf("the keys were rejected, check they're valid, and for an account on %s: %v",
+						server, mqe.NestedError),
+				})
+		default:
+			return append(checks,
+				doctorCheck{name: "network", ok: true, detail: "reached " + server},
+				doctorCheck{name: "auth", ok: true, detail: "the keys were accepted"},
+				doctorCheck{
+					name:   "query",
+					detail: fmt.Sprintf("%s failed (%s): %v", doctorQuery, mqe.Kind, mqe.NestedError),
+				})
+		}
+	}
+
+	queried := fmt.Sprintf("%s returned no data, but was accepted", doctorQuery)
+	if result.Value != nil {
+		queried = fmt.Sprintf("%s returned %v", doctorQuery, *result.Value)
+	}
+
+	return append(checks,
+		doctorCheck{name: "network", ok: true, detail: "reached " + server},
+		doctorCheck{name: "auth", ok: true, detail: "the keys were accepted"},
+		doctorCheck{name: "query", ok: true, detail: queried},
+	)
+}
+
+// Write a line for each check, e.g. `ok    network  reached https://api.datadoghq.com`, and return whether they all
+// passed.
+func writeDoctorChecks(w io.Writer, checks []doctorCheck) (bool, error) {
+	passed := true
+
+	for _, check := range checks {
+		status := "ok"
+		if !check.ok {
+			status = "FAIL"
+			passed = false
+		}
+
+		_, err := fmt.Fprintf(w, "%-5s %-8s %s\n", status, check.name, check.detail)
+		if err != nil {
+			return false, err //nolint:wrapcheck
+		}
+	}
+
+	return passed, nil
+}
+
+// Run the -doctor checks against the API the validator uses, printing them to stdout, and return the exit code: 0 if
+// they all passed, 1 otherwise.
+func runDoctor(ctx context.Context, validator *querylint.Validator, cfg *datadog.Configuration, apiKey string,
+	appKey string,
+) int {
+	server, err := cfg.ServerURLWithContext(ctx, "v1.MetricsApi.QueryMetrics")
+	if err != nil {
+		server = "the Datadog API"
+	}
+
+	var missing []string
+
+	if apiKey == "" {
+		missing = append(missing, "DD_CLIENT_API_KEY")
+	}
+
+	if appKey == "" {
+		missing = append(missing, "DD_CLIENT_APP_KEY")
+	}
+
+	var result querylint.Result
+
+	if len(missing) == 0 {
+		result, err = validator.Validate(ctx, doctorQuery)
+	}
+
+	passed, err := writeDoctorChecks(os.Stdout, doctorChecks(server, missing, result, err))
+	if err != nil {
+		slog.Error("Failed to write the -doctor checks", slog.Any("err", err))
+
+		return 1
+	}
+
+	if !passed {
+		return 1
+	}
+
+	return 0
+}
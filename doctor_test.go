@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/persona-id/datadog-query-linter/querylint"
+)
+
+func TestDoctorChecks(t *testing.T) {
+	server := "https://api.datadoghq.com"
+	value := 1.0
+
+	tests := []struct {
+		name     string
+		missing  []string
+		result   querylint.Result
+		err      error
+		failed   string
+		expected int
+	}{
+		{name: "missing keys", missing: []string{"DD_CLIENT_APP_KEY"}, failed: "keys", expected: 1},
+		{
+			name:     "unreachable",
+			err:      &querylint.MetricQueryError{Kind: querylint.KindNetwork, NestedError: errors.New("no such host")},
+			failed:   "network",
+			expected: 2,
+		},
+		{
+			name:     "bad keys",
+			err:      &querylint.MetricQueryError{Kind: querylint.KindAuth, NestedError: errors.New("403 Forbidden")},
+			failed:   "auth",
+			expected: 3,
+		},
+		{
+			name:     "rate limited",
+			err:      &querylint.MetricQueryError{Kind: querylint.KindRateLimited, NestedError: errors.New("429")},
+			failed:   "query",
+			expected: 4,
+		},
+		{name: "no data", expected: 4},
+		{name: "working", result: querylint.Result{Value: &value}, expected: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checks := doctorChecks(server, tt.missing, tt.result, tt.err)
+			if len(checks) != tt.expected {
+				t.Fatalf("Expected %d checks, got %d: %v", tt.expected, len(checks), checks)
+			}
+
+			for _, check := range checks {
+				if failed := check.name == tt.failed; failed == check.ok {
+					t.Errorf("Expected only the %q check to fail, got %v", tt.failed, checks)
+				}
+			}
+
+			var buf bytes.Buffer
+
+			passed, err := writeDoctorChecks(&buf, checks)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if passed != (tt.failed == "") {
+				t.Errorf("Expected passed to be %v, got %v", tt.failed == "", passed)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// envVarPattern matches a `${VAR}` or `$VAR` reference to an environment variable.
+//
+//nolint:gochecknoglobals
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// Substitute the `${VAR}` and `$VAR` references in the query, for -expand-env. An error listing every variable that
+// couldn't be resolved is returned if there were any, since validating the query with a hole in it would be misleading.
+func expandEnv(query string, lookup func(string) (string, bool)) (string, error) {
+	unresolved := map[string]bool{}
+
+	expanded := envVarPattern.ReplaceAllStringFunc(query, func(ref string) string {
+		match := envVarPattern.FindStringSubmatch(ref)
+
+		name := match[1]
+		if name == "" {
+			name = match[2]
+		}
+
+		value, ok := lookup(name)
+		if !ok {
+			unresolved[name] = true
+
+			return ref
+		}
+
+		return value
+	})
+
+	if len(unresolved) > 0 {
+		names := make([]string, 0, len(unresolved))
+		for name := range unresolved {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		return "", fmt.Errorf("unresolved environment variable(s): %s", strings.Join(names, ", "))
+	}
+
+	return expanded, nil
+}
+
+// Build the lookup for expandEnv: the variables in the -env-file, if there is one, falling back to the process'
+// environment.
+func envLookup(envFile string) (func(string) (string, bool), error) {
+	if envFile == "" {
+		return os.LookupEnv, nil
+	}
+
+	vars, err := loadEnvFile(envFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(name string) (string, bool) {
+		if value, ok := vars[name]; ok {
+			return value, true
+		}
+
+		return os.LookupEnv(name)
+	}, nil
+}
+
+// Read a dotenv style file of `KEY=value` lines. Blank lines and `#` comments are ignored, a leading `export` is
+// allowed, and the value can be wrapped in single or double quotes.
+func loadEnvFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Failed to read env file: %s", path))
+	}
+
+	vars := map[string]string{}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d isn't KEY=value: %s", i+1, path)
+		}
+
+		value = strings.TrimSpace(value)
+
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		vars[strings.TrimSpace(key)] = value
+	}
+
+	return vars, nil
+}
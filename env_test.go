@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	vars := map[string]string{"ENV": "prod", "SERVICE": "web"}
+	lookup := func(name string) (string, bool) {
+		value, ok := vars[name]
+
+		return value, ok
+	}
+
+	t.Run("both forms are substituted", func(t *testing.T) {
+		expanded, err := expandEnv("avg:foo{env:${ENV},service:$SERVICE}", lookup)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if expanded != "avg:foo{env:prod,service:web}" {
+			t.Errorf("Expected avg:foo{env:prod,service:web}, got %s", expanded)
+		}
+	})
+
+	t.Run("unresolved variables are an error", func(t *testing.T) {
+		_, err := expandEnv("avg:foo{env:$ENV,region:${REGION},zone:$ZONE}", lookup)
+		if err == nil {
+			t.Fatalf("Expected an error but didn't receive one.")
+		}
+
+		if err.Error() != "unresolved environment variable(s): REGION, ZONE" {
+			t.Errorf("Expected the unresolved variables to be listed, got %v", err)
+		}
+	})
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+
+	contents := "# Deploy time settings\n\nENV=prod\nexport SERVICE=\"web\"\nREGION='us-east-1'\n"
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	vars, err := loadEnvFile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := map[string]string{"ENV": "prod", "SERVICE": "web", "REGION": "us-east-1"}
+
+	if len(vars) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, vars)
+	}
+
+	for key, value := range expected {
+		if vars[key] != value {
+			t.Errorf("Expected %s=%s, got %q", key, value, vars[key])
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("not a variable\n"), 0o600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := loadEnvFile(path); err == nil {
+		t.Errorf("Expected an error for a line without a value")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// errNotAtRevision is returned by queryAtRevision when the file, or the query within it, didn't exist at the revision,
+// so the query is new.
+var errNotAtRevision = errors.New("not at the revision")
+
+// Check that the revision is a commit in the repo. Without this, a mistyped -base-ref, or one that wasn't fetched in a
+// shallow clone, would make every query look new, quietly turning -only-changed-metrics off.
+func verifyRevision(revision string) error {
+	err := exec.Command("git", "rev-parse", "--verify", "--quiet", revision+"^{commit}").Run()
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Failed to find the revision %s in the git repo", revision))
+	}
+
+	return nil
+}
+
+// Extract the target's query from the version of its file at the given git revision, which has been checked with
+// verifyRevision. The file is resolved relative to the current directory, so this works with the same paths that were
+// passed on the command line. If the file (or the query within it) didn't exist at that revision, errNotAtRevision is
+// returned, which callers should treat as the query having changed. Any other error means the revision couldn't be
+// read at all.
+func queryAtRevision(revision string, t target, kind string, queryPath string) (string, error) {
+	path := t.file
+
+	if filepath.IsAbs(path) {
+		dir, err := filepath.Abs(".")
+		if err != nil {
+			return "", errors.Wrap(err, "Failed to resolve the current directory")
+		}
+
+		path, err = filepath.Rel(dir, t.file)
+		if err != nil {
+			return "", errors.Wrap(err, fmt.Sprintf("Failed to resolve file relative to the current directory: %s", t.file))
+		}
+	}
+
+	object := fmt.Sprintf("%s:./%s", revision, filepath.ToSlash(path))
+
+	// The revision exists, so this only fails if the file doesn't exist at it.
+	err := exec.Command("git", "cat-file", "-e", object).Run()
+	if err != nil {
+		return "", errors.Wrap(errNotAtRevision, fmt.Sprintf("File doesn't exist at revision %s: %s", revision, t.file))
+	}
+
+	data, err := exec.Command("git", "show", object).Output()
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("Failed to read file at revision %s: %s", revision, t.file))
+	}
+
+	targets, err := extractTargets(kind, fmt.Sprintf("%s:%s", revision, t.file), data, queryPath)
+	if err != nil {
+		return "", err
+	}
+
+	for _, base := range targets {
+		if base.name == t.name {
+			return base.query, nil
+		}
+	}
+
+	return "", errors.Wrap(errNotAtRevision, fmt.Sprintf("Query not found at revision %s: %s", revision, t))
+}
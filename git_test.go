@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// Create a git repo with a commit of the files, and change into it for the rest of the test, since git is run in the
+// current directory. The tests using it can't run in parallel.
+func newTestRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get the current directory: %v", err)
+	}
+
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatalf("Failed to change into the repo: %v", err)
+	}
+
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	for name, contents := range files {
+		writeTestFile(t, name, contents)
+	}
+
+	git(t, "init", "--quiet")
+	git(t, "add", "--all")
+	git(t, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "--message", "base")
+
+	return dir
+}
+
+func git(t *testing.T, args ...string) {
+	t.Helper()
+
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Failed to run git %v: %v: %s", args, err, out)
+	}
+}
+
+func writeTestFile(t *testing.T, name string, contents string) {
+	t.Helper()
+
+	err := os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		t.Fatalf("Failed to create the directory for %s: %v", name, err)
+	}
+
+	err = os.WriteFile(name, []byte(contents), 0o600)
+	if err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+}
+
+func TestVerifyRevision(t *testing.T) {
+	newTestRepo(t, map[string]string{"metric.yaml": "spec:\n  query: avg:foo{*}\n"})
+
+	if err := verifyRevision("HEAD"); err != nil {
+		t.Errorf("Expected HEAD to be found, got %v", err)
+	}
+
+	if err := verifyRevision("origin/mian"); err == nil {
+		t.Errorf("Expected an error for a revision that doesn't exist")
+	}
+}
+
+func TestQueryAtRevision(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{
+		"metrics/web.yaml":  "spec:\n  query: avg:web{*}\n",
+		"metrics/jobs.yaml": "metadata:\n  name: queue\nspec:\n  query: avg:queue{*}\n---\nspec:\n  query: avg:jobs{*}\n",
+	})
+
+	writeTestFile(t, "metrics/new.yaml", "spec:\n  query: avg:new{*}\n")
+
+	t.Run("the query at the revision", func(t *testing.T) {
+		for _, file := range []string{"metrics/web.yaml", filepath.Join(dir, "metrics", "web.yaml")} {
+			query, err := queryAtRevision("HEAD", target{file: file}, kindDatadogMetric, "spec.query")
+			if err != nil || query != "avg:web{*}" {
+				t.Errorf("Expected avg:web{*} for %s, got %q and %v", file, query, err)
+			}
+		}
+
+		query, err := queryAtRevision("HEAD", target{file: "metrics/jobs.yaml", name: "queue"}, kindDatadogMetric, "spec.query")
+		if err != nil || query != "avg:queue{*}" {
+			t.Errorf("Expected avg:queue{*}, got %q and %v", query, err)
+		}
+	})
+
+	t.Run("a new file isn't at the revision", func(t *testing.T) {
+		_, err := queryAtRevision("HEAD", target{file: "metrics/new.yaml"}, kindDatadogMetric, "spec.query")
+		if !errors.Is(err, errNotAtRevision) {
+			t.Errorf("Expected errNotAtRevision, got %v", err)
+		}
+	})
+
+	t.Run("a new query isn't at the revision", func(t *testing.T) {
+		_, err := queryAtRevision("HEAD", target{file: "metrics/jobs.yaml", name: "latency"}, kindDatadogMetric,
+			"spec.query")
+		if !errors.Is(err, errNotAtRevision) {
+			t.Errorf("Expected errNotAtRevision, got %v", err)
+		}
+	})
+
+	t.Run("a file that can't be parsed at the revision is an error", func(t *testing.T) {
+		git(t, "rm", "--quiet", "--cached", "metrics/web.yaml")
+		writeTestFile(t, "metrics/web.yaml", "\x00")
+		git(t, "add", "metrics/web.yaml")
+		git(t, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "--message", "binary")
+
+		_, err := queryAtRevision("HEAD", target{file: "metrics/web.yaml"}, kindDatadogMetric, "spec.query")
+		if err == nil || errors.Is(err, errNotAtRevision) {
+			t.Errorf("Expected an error other than errNotAtRevision, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// How long to wait for GitHub, which mustn't hold up the end of the run for long.
+	githubTimeout = 10 * time.Second
+
+	githubDefaultAPI = "https://api.github.com"
+)
+
+// errNoPullRequest is returned when -github-review can't work out which pull request the run is for.
+var errNoPullRequest = errors.New("no pull request number, set GITHUB_PR_NUMBER, or run on a pull_request event")
+
+// pullRequestRefPattern matches the ref GitHub Actions checks out for a pull request, e.g. `refs/pull/123/merge`.
+//
+//nolint:gochecknoglobals
+var pullRequestRefPattern = regexp.MustCompile(`^refs/pull/(\d+)/`)
+
+// The pull request to post the -github-review to.
+type githubPR struct {
+	api    string // The API's base URL, which is different for GitHub Enterprise Server
+	token  string
+	repo   string // owner/name
+	number int
+}
+
+// Work out the pull request from the environment GitHub Actions sets, i.e. GITHUB_TOKEN, GITHUB_REPOSITORY, GITHUB_REF
+// and GITHUB_API_URL. GITHUB_PR_NUMBER wins over GITHUB_REF, for other CI systems, and for events that aren't for a
+// pull request.
+func githubPRFromEnv(lookup func(string) (string, bool)) (githubPR, error) {
+	env := func(name string) string {
+		value, _ := lookup(name)
+
+		return strings.TrimSpace(value)
+	}
+
+	pr := githubPR{api: env("GITHUB_API_URL"), token: env("GITHUB_TOKEN"), repo: env("GITHUB_REPOSITORY")}
+
+	if pr.api == "" {
+		pr.api = githubDefaultAPI
+	}
+
+	if pr.token == "" {
+		return pr, errors.New("GITHUB_TOKEN isn't set")
+	}
+
+	if !strings.Contains(pr.repo, "/") {
+		return pr, fmt.Errorf("GITHUB_REPOSITORY should be owner/name, got %q", pr.repo)
+	}
+
+	number := env("GITHUB_PR_NUMBER")
+	if match := pullRequestRefPattern.FindStringSubmatch(env("GITHUB_REF")); number == "" && match != nil {
+		number = match[1]
+	}
+
+	if number == "" {
+		return pr, errNoPullRequest
+	}
+
+	var err error
+
+	pr.number, err = strconv.Atoi(number)
+	if err != nil || pr.number <= 0 {
+		return pr, fmt.Errorf("invalid pull request number: %s", number)
+	}
+
+	return pr, nil
+}
+
+// A failing query, for -github-review, and the reasons it failed.
+type githubFailure struct {
+	target  target
+	reasons []string
+}
+
+// An inline comment in a pull request review, on a line of the new version of a file.
+type reviewComment struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Side string `json:"side"`
+	Body string `json:"body"`
+}
+
+type review struct {
+	Event    string          `json:"event"`
+	Body     string          `json:"body"`
+	Comments []reviewComment `json:"comments,omitempty"`
+}
+
+// Build the review for the failures: a comment on the line of each failing query, with any that aren't on a known line
+// of a file in the repo listed in the review's body instead. The root is the top of the repo, which the comments'
+// paths are relative to.
+func buildReview(failures []githubFailure, root string) review {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "datadog-query-linter found %d failing queries; see the CI logs for the details.", len(failures))
+
+	var comments []reviewComment
+
+	for _, failure := range failures {
+		path, ok := repoPath(failure.target.file, root)
+		if !ok || failure.target.line == 0 {
+			fmt.Fprintf(&b, "\n\n%s", failureComment(failure, true))
+
+			continue
+		}
+
+		comments = append(comments, reviewComment{
+			Path: path,
+			Line: failure.target.line,
+			Side: "RIGHT",
+			Body: failureComment(failure, false),
+		})
+	}
+
+	return review{Event: "COMMENT", Body: b.String(), Comments: comments}
+}
+
+// The comment for a failing query, naming the file when it's in the review's body rather than on its line.
+func failureComment(failure githubFailure, withFile bool) string {
+	var b strings.Builder
+
+	if withFile {
+		fmt.Fprintf(&b, "`%s`: ", failure.target)
+	}
+
+	reasons := make([]string, len(failure.reasons))
+	for i, reason := range failure.reasons {
+		reasons[i] = "`" + reason + "`"
+	}
+
+	fmt.Fprintf(&b, "**datadog-query-linter**: this query failed (%s).\n\n```\n%s\n```", strings.Join(reasons, ", "),
+		failure.target.query)
+
+	return b.String()
+}
+
+// The file's path relative to the root of the repo, with forward slashes, as GitHub wants it. It's not ok if the file
+// isn't in the repo, e.g. it came from -from-cluster.
+func repoPath(file string, root string) (string, bool) {
+	if root == "" {
+		return "", false
+	}
+
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", false
+	}
+
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+
+	return filepath.ToSlash(rel), true
+}
+
+// The top of the git repo the current directory is in, or empty if it isn't in one.
+func repoRoot() string {
+	out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+// Post the review to the pull request. GitHub rejects the whole review if any comment is on a line that isn't in the
+// pull request's diff, e.g. a query that was already broken in a file the pull request only touched elsewhere, so in
+// that case it's posted again with every comment in the body.
+func postReview(ctx context.Context, pr githubPR, r review) error {
+	status, err := sendReview(ctx, pr, r)
+	if err != nil || status != http.StatusUnprocessableEntity || len(r.Comments) == 0 {
+		return err
+	}
+
+	var b strings.Builder
+
+	b.WriteString(r.Body)
+
+	for _, comment := range r.Comments {
+		fmt.Fprintf(&b, "\n\n`%s:%d`: %s", comment.Path, comment.Line, comment.Body)
+	}
+
+	status, err = sendReview(ctx, pr, review{Event: r.Event, Body: b.String()})
+	if err == nil && status == http.StatusUnprocessableEntity {
+		return errors.New("github rejected the review as invalid")
+	}
+
+	return err
+}
+
+// Send the review, returning the status code if GitHub rejected it as invalid, so the caller can try again without
+// the inline comments.
+func sendReview(ctx context.Context, pr githubPR, r review) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, githubTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(r)
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to marshal GitHub review")
+	}
+
+	url := fmt.Sprintf("%s/repos/%s/pulls/%d/reviews", strings.TrimSuffix(pr.api, "/"), pr.repo, pr.number)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to build GitHub request")
+	}
+
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+pr.token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to post to GitHub")
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnprocessableEntity:
+		return resp.StatusCode, nil
+	case resp.StatusCode != http.StatusOK:
+		return resp.StatusCode, fmt.Errorf("github returned %s", resp.Status)
+	default:
+		return resp.StatusCode, nil
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitHubPRFromEnv(t *testing.T) {
+	lookup := func(env map[string]string) func(string) (string, bool) {
+		return func(name string) (string, bool) {
+			value, ok := env[name]
+
+			return value, ok
+		}
+	}
+
+	t.Run("the pull request comes from the ref", func(t *testing.T) {
+		pr, err := githubPRFromEnv(lookup(map[string]string{
+			"GITHUB_TOKEN":      "token",
+			"GITHUB_REPOSITORY": "persona-id/k8s",
+			"GITHUB_REF":        "refs/pull/123/merge",
+		}))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		expected := githubPR{api: githubDefaultAPI, token: "token", repo: "persona-id/k8s", number: 123}
+		if pr != expected {
+			t.Errorf("Expected %+v, got %+v", expected, pr)
+		}
+	})
+
+	t.Run("GITHUB_PR_NUMBER wins over the ref", func(t *testing.T) {
+		pr, err := githubPRFromEnv(lookup(map[string]string{
+			"GITHUB_TOKEN":      "token",
+			"GITHUB_REPOSITORY": "persona-id/k8s",
+			"GITHUB_REF":        "refs/pull/123/merge",
+			"GITHUB_PR_NUMBER":  "456",
+		}))
+		if err != nil || pr.number != 456 {
+			t.Errorf("Expected pull request 456, got %d and %v", pr.number, err)
+		}
+	})
+
+	t.Run("a push isn't a pull request", func(t *testing.T) {
+		_, err := githubPRFromEnv(lookup(map[string]string{
+			"GITHUB_TOKEN":      "token",
+			"GITHUB_REPOSITORY": "persona-id/k8s",
+			"GITHUB_REF":        "refs/heads/main",
+		}))
+		if !errors.Is(err, errNoPullRequest) {
+			t.Errorf("Expected errNoPullRequest, got %v", err)
+		}
+	})
+
+	t.Run("the token is required", func(t *testing.T) {
+		_, err := githubPRFromEnv(lookup(map[string]string{"GITHUB_REPOSITORY": "persona-id/k8s", "GITHUB_PR_NUMBER": "1"}))
+		if err == nil || errors.Is(err, errNoPullRequest) {
+			t.Errorf("Expected an error about the token, got %v", err)
+		}
+	})
+}
+
+func TestBuildReview(t *testing.T) {
+	root := t.TempDir()
+
+	failures := []githubFailure{
+		{target: target{file: filepath.Join(root, "web", "metric.yaml"), query: "avg:foo{*}", line: 7}, reasons: []string{"no-data"}},
+		{target: target{file: "deployed/web", query: "avg:bar{*}"}, reasons: []string{"bad_query", "required-tags"}},
+	}
+
+	r := buildReview(failures, root)
+
+	if len(r.Comments) != 1 {
+		t.Fatalf("Expected 1 inline comment, got %+v", r.Comments)
+	}
+
+	comment := r.Comments[0]
+	if comment.Path != "web/metric.yaml" || comment.Line != 7 || !strings.Contains(comment.Body, "`no-data`") {
+		t.Errorf("Expected a comment on web/metric.yaml:7 about no-data, got %+v", comment)
+	}
+
+	if !strings.Contains(r.Body, "`deployed/web`: ") || !strings.Contains(r.Body, "(`bad_query`, `required-tags`)") {
+		t.Errorf("Expected the failure without a line in the body, got %q", r.Body)
+	}
+}
+
+func TestPostReview(t *testing.T) {
+	t.Run("the review is posted to the pull request", func(t *testing.T) {
+		var received review
+
+		var path, auth string
+
+		server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			path, auth = r.URL.Path, r.Header.Get("Authorization")
+			_ = json.NewDecoder(r.Body).Decode(&received)
+		}))
+		defer server.Close()
+
+		pr := githubPR{api: server.URL, token: "token", repo: "persona-id/k8s", number: 12}
+		r := review{Event: "COMMENT", Body: "body", Comments: []reviewComment{{Path: "a.yaml", Line: 3, Side: "RIGHT"}}}
+
+		if err := postReview(context.Background(), pr, r); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if path != "/repos/persona-id/k8s/pulls/12/reviews" || auth != "Bearer token" {
+			t.Errorf("Expected the review to be posted to pull request 12 with the token, got %s and %q", path, auth)
+		}
+
+		if len(received.Comments) != 1 || received.Comments[0].Line != 3 {
+			t.Errorf("Expected the inline comment, got %+v", received)
+		}
+	})
+
+	t.Run("comments on lines outside the diff are moved to the body", func(t *testing.T) {
+		var received []review
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var posted review
+			_ = json.NewDecoder(r.Body).Decode(&posted)
+			received = append(received, posted)
+
+			if len(posted.Comments) > 0 {
+				http.Error(w, "Line could not be resolved", http.StatusUnprocessableEntity)
+			}
+		}))
+		defer server.Close()
+
+		pr := githubPR{api: server.URL, token: "token", repo: "persona-id/k8s", number: 12}
+		r := review{Event: "COMMENT", Body: "body", Comments: []reviewComment{{Path: "a.yaml", Line: 3, Body: "broken"}}}
+
+		if err := postReview(context.Background(), pr, r); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if len(received) != 2 || received[1].Body != "body\n\n`a.yaml:3`: broken" {
+			t.Errorf("Expected the review to be posted again with the comment in the body, got %+v", received)
+		}
+	})
+
+	t.Run("a rejected review is an error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, "Bad credentials", http.StatusUnauthorized)
+		}))
+		defer server.Close()
+
+		pr := githubPR{api: server.URL, token: "token", repo: "persona-id/k8s", number: 12}
+
+		if err := postReview(context.Background(), pr, review{Event: "COMMENT", Body: "body"}); err == nil {
+			t.Errorf("Expected an error but didn't receive one.")
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const groupByReason = "reason" // -group-by reason
+
+// The problems with the same reason, for -group-by reason.
+type reasonGroup struct {
+	reason   string
+	failures int
+	warnings int
+	files    []string // In the order they were found, without repeats
+	repeats  map[string]int
+}
+
+// Group the problems by their reason, with the reasons that happened the most first. When dozens of files fail for the
+// same reason, it's one systemic problem, not dozens of separate ones.
+func groupByReasons(problems []problem) []*reasonGroup {
+	groups := map[string]*reasonGroup{}
+
+	var ordered []*reasonGroup
+
+	for _, p := range problems {
+		group, ok := groups[p.reason]
+		if !ok {
+			group = &reasonGroup{reason: p.reason, repeats: map[string]int{}}
+			groups[p.reason] = group
+			ordered = append(ordered, group)
+		}
+
+		if p.failure {
+			group.failures++
+		} else {
+			group.warnings++
+		}
+
+		if group.repeats[p.file] == 0 {
+			group.files = append(group.files, p.file)
+		}
+
+		group.repeats[p.file]++
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].failures+ordered[i].warnings > ordered[j].failures+ordered[j].warnings
+	})
+
+	return ordered
+}
+
+// Write a section for each reason, e.g.
+//
+//	auth: 2 failures
+//	  a.yaml
+//	  b.yaml
+//	no-data: 3 warnings
+//	  c.yaml (x2)
+//	  d.yaml
+func writeReasonGroups(w io.Writer, groups []*reasonGroup) error {
+	for _, group := range groups {
+		var counts []string
+
+		if group.failures > 0 {
+			counts = append(counts, pluralize(group.failures, "failure"))
+		}
+
+		if group.warnings > 0 {
+			counts = append(counts, pluralize(group.warnings, "warning"))
+		}
+
+		_, err := fmt.Fprintf(w, "%s: %s\n", group.reason, strings.Join(counts, ", "))
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		for _, file := range group.files {
+			line := "  " + file
+			if repeats := group.repeats[file]; repeats > 1 {
+				line += fmt.Sprintf(" (x%d)", repeats)
+			}
+
+			_, err = fmt.Fprintln(w, line)
+			if err != nil {
+				return err //nolint:wrapcheck
+			}
+		}
+	}
+
+	return nil
+}
+
+// The count followed by the noun, pluralized if the count isn't 1, e.g. `2 warnings`.
+func pluralize(count int, noun string) string {
+	if count != 1 {
+		noun += "s"
+	}
+
+	return fmt.Sprintf("%d %s", count, noun)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGroupByReasons(t *testing.T) {
+	t.Run("reasons that happened the most come first", func(t *testing.T) {
+		problems := []problem{
+			{reason: "no-data", file: "c.yaml"},
+			{reason: "auth", file: "a.yaml", failure: true},
+			{reason: "no-data", file: "c.yaml"},
+			{reason: "auth", file: "b.yaml", failure: true},
+			{reason: "no-data", file: "d.yaml"},
+		}
+
+		var buf bytes.Buffer
+
+		err := writeReasonGroups(&buf, groupByReasons(problems))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		expected := "no-data: 3 warnings\n  c.yaml (x2)\n  d.yaml\nauth: 2 failures\n  a.yaml\n  b.yaml\n"
+		if actual := buf.String(); actual != expected {
+			t.Errorf("Expected %q, got %q", expected, actual)
+		}
+	})
+
+	t.Run("a reason can have both failures and warnings", func(t *testing.T) {
+		problems := []problem{
+			{reason: "require-fill", file: "a.yaml", failure: true},
+			{reason: "require-fill", file: "b.yaml"},
+		}
+
+		var buf bytes.Buffer
+
+		err := writeReasonGroups(&buf, groupByReasons(problems))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		expected := "require-fill: 1 failure, 1 warning\n  a.yaml\n  b.yaml\n"
+		if actual := buf.String(); actual != expected {
+			t.Errorf("Expected %q, got %q", expected, actual)
+		}
+	})
+}
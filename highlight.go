@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// The same bright red tint uses for errors.
+const (
+	ansiBrightRed = "\u001b[91m"
+	ansiReset     = "\u001b[0m"
+)
+
+// Render the query with the span [start, end) in red, and underlined with carets on the line below, so it's obvious
+// which metric in a complex query the log line above is about, e.g.
+//
+//	avg:foo{*} / default_zero(avg:bar{*})
+//	             ^^^^^^^^^^^^^^^^^^^^^^^^
+//
+// Newlines and tabs are flattened to spaces, so the carets line up with multi-line queries too.
+func highlightSpan(query string, start int, end int, color bool) string {
+	if start < 0 || end > len(query) || start >= end {
+		return query
+	}
+
+	flat := strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\r' || r == '\t' {
+			return ' '
+		}
+
+		return r
+	}, query)
+
+	before, span, after := flat[:start], flat[start:end], flat[end:]
+	underline := strings.Repeat(" ", utf8.RuneCountInString(before)) + strings.Repeat("^", utf8.RuneCountInString(span))
+
+	if color {
+		span = ansiBrightRed + span + ansiReset
+		underline = ansiBrightRed + underline + ansiReset
+	}
+
+	return fmt.Sprintf("%s%s%s\n%s", before, span, after, underline)
+}
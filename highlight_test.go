@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHighlightSpan(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		start, end int
+		expected   string
+	}{
+		{
+			name:     "the span is underlined",
+			query:    "avg:foo{*} / default_zero(avg:bar{*})",
+			start:    13,
+			end:      37,
+			expected: "avg:foo{*} / default_zero(avg:bar{*})\n             ^^^^^^^^^^^^^^^^^^^^^^^^",
+		},
+		{
+			name:     "newlines are flattened",
+			query:    "avg:foo{*}\n+ avg:bar{*}",
+			start:    13,
+			end:      23,
+			expected: "avg:foo{*} + avg:bar{*}\n             ^^^^^^^^^^",
+		},
+		{
+			name:     "non-ASCII characters are one column each",
+			query:    "avg:foo{service:café} + avg:bar{*}",
+			start:    25,
+			end:      35,
+			expected: "avg:foo{service:café} + avg:bar{*}\n                        ^^^^^^^^^^",
+		},
+		{
+			name:     "an invalid span leaves the query alone",
+			query:    "avg:foo{*}",
+			start:    5,
+			end:      50,
+			expected: "avg:foo{*}",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := highlightSpan(test.query, test.start, test.end, false); actual != test.expected {
+				t.Errorf("Expected\n%s\ngot\n%s", test.expected, actual)
+			}
+		})
+	}
+
+	t.Run("the span is red with color", func(t *testing.T) {
+		expected := "avg:a{*} + " + ansiBrightRed + "avg:b{*}" + ansiReset + "\n" +
+			ansiBrightRed + "           ^^^^^^^^" + ansiReset
+
+		if actual := highlightSpan("avg:a{*} + avg:b{*}", 11, 19, true); actual != expected {
+			t.Errorf("Expected %q, got %q", expected, actual)
+		}
+	})
+}
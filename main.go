@@ -1,122 +1,1250 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
-	"net/http"
 	"os"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
 	"time"
+	"unicode/utf8"
 
 	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
 	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
 	"github.com/lmittmann/tint"
+	"github.com/persona-id/datadog-query-linter/querylint"
 	"github.com/pkg/errors"
-	"gopkg.in/yaml.v2"
 )
 
-type DatadogMetricDefinition struct {
-	Spec struct {
-		Query string `yaml:"query"`
+const (
+	// Exit code used when the run found failures. It's fixed, rather than the number of failures, so it can't collide
+	// with the other exit codes, or wrap around to 0.
+	failureExitCode = 1
+
+	// Exit code used when the run found warnings, but no failures. CI can treat this as a non-blocking notice.
+	softFailExitCode = 10
+
+	// Exit code used when -max-duration runs out before every file was validated. Matches timeout(1).
+	timedOutExitCode = 124
+
+	// Exit code used when the run was stopped by SIGINT or SIGTERM before every file was validated. Matches a shell's
+	// 128 + SIGINT.
+	interruptedExitCode = 130
+
+	// How many characters of a query are logged before the rest is cut off.
+	loggedQueryLength = 200
+
+	// The -parallel-metrics that sizes itself from the number of CPUs.
+	parallelMetricsAuto = "auto"
+)
+
+// The number of problems found during the run.
+type tally struct {
+	failures    int
+	warnings    int
+	interrupted int // API calls cut short by a timeout or cancellation, which say nothing about the query
+	skipped     int // Queries that weren't validated, since they're unchanged from -base-ref
+
+	apiErrors map[querylint.ErrorKind]int // The failures from API errors, broken down by kind
+	rules     map[ruleSeverity]int        // The findings from lint rules, broken down by rule and severity
+	problems  []problem                   // Every failure and warning, and why, for -group-by reason
+}
+
+// A single failure or warning, and why it happened: a rule id, an API error kind like `auth`, or one of the reasons
+// below.
+type problem struct {
+	reason  string
+	file    string
+	failure bool
+}
+
+// The reasons for problems that aren't from a rule or an API error.
+const (
+	reasonExtract      = "extract-error"   // The file couldn't be read or parsed
+	reasonEmptyFile    = "empty-file"      // The file had no query, with -skip-empty-query-as-error
+	reasonEnvExpansion = "env-expansion"   // A variable in the query couldn't be expanded
+	reasonDumpAST      = "dump-ast"        // The parsed query couldn't be dumped
+	reasonSyntax       = "syntax-error"    // The query has syntax problems
+	reasonNoData       = "no-data"         // The query or a metric returned no data
+	reasonMasked       = "masked-metric"   // A metric returned no data, but a masking function hides that
+	reasonNotInCatalog = "not-in-catalog"  // A metric isn't in the -catalog
+	reasonDuplicate    = "duplicate-query" // The query is in more than one file, with -detect-duplicates
+	reasonQueryLength  = "query-length"    // The query is longer than -max-query-length
+	reasonThreshold    = "threshold"       // The query's latest value crosses -report-threshold
+	reasonAccountDrift = "account-drift"   // The query differs between the accounts, with -compare-accounts
+)
+
+// Count a failure, and why it happened.
+func (t *tally) fail(reason string, file string) {
+	t.failures++
+	t.problems = append(t.problems, problem{reason: reason, file: file, failure: true})
+}
+
+// Count a warning, and why it happened.
+func (t *tally) warn(reason string, file string) {
+	t.warnings++
+	t.problems = append(t.problems, problem{reason: reason, file: file})
+}
+
+// Count a query or metric that returned no data: a warning, or with -require-data, a failure.
+func (t *tally) noData(reason string, file string, requireData bool) {
+	if requireData {
+		t.fail(reason, file)
+	} else {
+		t.warn(reason, file)
 	}
 }
 
-type MetricQueryError struct {
-	HTTPResponse *http.Response // The HTTP resonse from the DD api
-	NestedError  error          // The error we're returning
+// The level to log a query or metric that returned no data at, to match how it's counted by tally.noData.
+func noDataLevel(requireData bool) slog.Level {
+	if requireData {
+		return slog.LevelError
+	}
+
+	return slog.LevelWarn
 }
 
-func (e *MetricQueryError) Error() string {
-	return fmt.Sprintf("Error: %s", e.NestedError)
+// Forget the failures counted since there were the given number of problems, keeping the warnings, for a query in the
+// -baseline.
+func (t *tally) forgetFailures(since int) {
+	kept := t.problems[:since]
+
+	for _, p := range t.problems[since:] {
+		if p.failure {
+			t.failures--
+
+			continue
+		}
+
+		kept = append(kept, p)
+	}
+
+	t.problems = kept
+}
+
+// The distinct reasons of the failures since the given number of problems, in the order they happened.
+func (t *tally) failureReasons(since int) []string {
+	var reasons []string
+
+	for _, p := range t.problems[since:] {
+		if p.failure && !slices.Contains(reasons, p.reason) {
+			reasons = append(reasons, p.reason)
+		}
+	}
+
+	return reasons
+}
+
+// The reason for an API error: its kind, like `auth` or `bad_query`.
+func apiErrorReason(err error) string {
+	var mqe *querylint.MetricQueryError
+	if errors.As(err, &mqe) {
+		return mqe.Kind.String()
+	}
+
+	return querylint.KindUnknown.String()
+}
+
+type ruleSeverity struct {
+	rule     string
+	severity querylint.Severity
+}
+
+// Count a finding from a lint rule. The failure or warning itself is counted separately.
+func (t *tally) countFinding(finding querylint.Finding) {
+	if t.rules == nil {
+		t.rules = map[ruleSeverity]int{}
+	}
+
+	t.rules[ruleSeverity{rule: finding.Rule, severity: finding.Severity}]++
+}
+
+// The findings from each lint rule, e.g. `redundant-default-zero: 2 warnings`, sorted by rule with errors first.
+func (t tally) ruleBreakdown() []string {
+	keys := make([]ruleSeverity, 0, len(t.rules))
+	for key := range t.rules {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].rule != keys[j].rule {
+			return keys[i].rule < keys[j].rule
+		}
+
+		return keys[i].severity > keys[j].severity
+	})
+
+	lines := make([]string, 0, len(keys))
+
+	for _, key := range keys {
+		noun := "warning"
+		if key.severity == querylint.SeverityError {
+			noun = "error"
+		}
+
+		lines = append(lines, fmt.Sprintf("%s: %s", key.rule, pluralize(t.rules[key], noun)))
+	}
+
+	return lines
+}
+
+// Count an API error by its kind, if it's a *querylint.MetricQueryError. The failure itself is counted separately.
+func (t *tally) countAPIError(err error) {
+	var mqe *querylint.MetricQueryError
+	if !errors.As(err, &mqe) {
+		return
+	}
+
+	if t.apiErrors == nil {
+		t.apiErrors = map[querylint.ErrorKind]int{}
+	}
+
+	t.apiErrors[mqe.Kind]++
+}
+
+// Whether the error is from an API call that was cut short by a timeout or cancellation, rather than one that failed.
+// That's an infra problem, not a problem with the query, so it's counted separately from the failures.
+func isInterrupted(err error) bool {
+	var mqe *querylint.MetricQueryError
+
+	return errors.As(err, &mqe) && mqe.Kind.Interrupted()
+}
+
+// The API errors by kind, e.g. `auth: 2, bad_query: 1`, in the order of the kinds.
+func (t tally) apiErrorBreakdown() string {
+	var parts []string
+
+	for kind := querylint.KindUnknown; kind <= querylint.KindNetwork; kind++ {
+		if count := t.apiErrors[kind]; count > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %d", kind, count))
+		}
+	}
+
+	return strings.Join(parts, ", ")
 }
 
 func main() {
 	// We might want to have a cli option for log level, possibly.
-	setupLogger("DEBUG")
+	setupLogger("DEBUG", nil)
+
+	// Each static rule has a flag named after it, setting its severity.
+	ruleFlags := map[string]*string{
+		querylint.RuleRequireFill: flag.String(querylint.RuleRequireFill, "off",
+			"Severity of the require-fill rule, which flags metrics without an explicit .fill() or .rollup(): off, warn or error"),
+		querylint.RuleRedundantDefaultZero: flag.String(querylint.RuleRedundantDefaultZero, "off",
+			"Severity of the redundant-default-zero rule, which flags metrics wrapped in default_zero() more than once: "+
+				"off, warn or error"),
+		querylint.RuleNoMetricsExtracted: flag.String(querylint.RuleNoMetricsExtracted, "off",
+			"Severity of the no-metrics-extracted rule, which flags queries the parser can't find any metrics in: "+
+				"off, warn or error"),
+		querylint.RuleSuspiciousTagFilter: flag.String(querylint.RuleSuspiciousTagFilter, "off",
+			"Severity of the suspicious-tag-filter rule, which flags empty tag filters and group bys, and placeholder tags: "+
+				"off, warn or error"),
+		querylint.RuleMixedAggregation: flag.String(querylint.RuleMixedAggregation, "off",
+			"Severity of the mixed-aggregation rule, which flags metrics combined with others that use a clashing "+
+				"aggregator, or .as_count() with .as_rate(): off, warn or error"),
+		// Does nothing without -require-tags, so it can default to failing the run.
+		querylint.RuleRequiredTags: flag.String(querylint.RuleRequiredTags, "error",
+			"Severity of the required-tags rule, which flags metrics that don't filter by every tag in -require-tags: "+
+				"off, warn or error"),
+		querylint.RuleSeriesCount: flag.String(querylint.RuleSeriesCount, "off",
+			"Severity of the series-count rule, which flags queries that match no series, or more than -max-series: "+
+				"off, warn or error"),
+		querylint.RuleDeprecatedMetric: flag.String(querylint.RuleDeprecatedMetric, "off",
+			"Severity of the deprecated-metric rule, which flags metrics whose metadata marks them as deprecated: "+
+				"off, warn or error"),
+		querylint.RulePercentileDistribution: flag.String(querylint.RulePercentileDistribution, "off",
+			"Severity of the percentile-distribution rule, which flags metrics queried at a percentile, like p95:, "+
+				"whose metadata says they aren't a distribution: off, warn or error"),
+		// Off, a masked metric without data is reported like any other metric in the query.
+		querylint.RuleMaskedInComplexQuery: flag.String(querylint.RuleMaskedInComplexQuery, "off",
+			"Severity of the masked-in-complex-query rule, which reports masked metrics that returned no data or failed, "+
+				"inside a query that validates, at its own severity rather than as usual: off, warn or error"),
+	}
+	// Rules compiled in with querylint.RegisterRule get a flag too, and are off unless it's set, like most of the built-in
+	// ones.
+	for _, rule := range querylint.RegisteredRules() {
+		if _, ok := ruleFlags[rule]; !ok {
+			ruleFlags[rule] = flag.String(rule, "off", fmt.Sprintf("Severity of the %s rule: off, warn or error", rule))
+		}
+	}
+
+	onlyChanged := flag.Bool("only-changed-metrics", false,
+		"Only validate queries that differ from the version of the file at -base-ref")
+	baseRef := flag.String("base-ref", "origin/main", "The git revision to compare against with -only-changed-metrics")
+	retryEmpty := flag.Bool("retry-empty", false,
+		"When a query returns no data, query it again once with a wider window before warning about it")
+	parallelMetricsFlag := flag.String("parallel-metrics", "1",
+		"How many of the metrics inside a single query to validate at once, or auto for one per CPU, capped by "+
+			"-max-conns-per-host")
+	batchSize := flag.Int("batch-size", 1,
+		"How many of the metrics inside a single query to send to the API in one request. 1 disables batching")
+	queryPath := flag.String("query-path", querylint.DefaultQueryPath,
+		"Dotted path to the query in each file, e.g. spec.groups.0.query")
+	printCanonical := flag.Bool("print-canonical", false,
+		"Print the canonical form of each query, for spotting near-duplicates, rather than validating it")
+	printMetrics := flag.Bool("print-metrics", false,
+		"Print the name of every metric used across all of the queries, once each and sorted, rather than validating them")
+	dumpASTFlag := flag.Bool("dump-ast", false,
+		"Print the parsed form of each query, with every metric's position, nesting and masking functions, as JSON "+
+			"rather than validating it")
+	explain := flag.Bool("explain", false,
+		"Print a plain English explanation of why each query passed or failed, regardless of the log level")
+	proxy := flag.String("proxy", "",
+		"URL of an HTTP proxy to send API requests through. By default HTTP_PROXY/HTTPS_PROXY/NO_PROXY are used")
+	caCert := flag.String("ca-cert", "",
+		"PEM bundle of extra CAs to trust for API requests, e.g. for a TLS intercepting proxy")
+	insecureSkipVerify := flag.Bool("insecure-skip-verify", false,
+		"DANGEROUS: don't verify the Datadog API's TLS certificate at all. Only for local debugging, use -ca-cert instead")
+	maxDuration := flag.Duration("max-duration", 0,
+		"Cap on the total runtime, e.g. 5m. Outstanding API calls are cancelled when it runs out. 0 means no limit")
+	metricsAddr := flag.String("metrics-addr", "",
+		"Serve Prometheus metrics about the run on this address, e.g. :9090, at /metrics")
+	windowList := flag.String("windows", "",
+		"Comma separated windows to look for data in, e.g. -1h,-24h,-7d. A metric only has no data if all of them are empty")
+	rollup := flag.Duration("rollup", 0,
+		"Roll up every metric sent to the API over this interval, e.g. 5m, so metrics that report less often reliably "+
+			"return a point. Windows narrower than it are widened to it")
+	newMetricGrace := flag.Duration("new-metric-grace", 0,
+		"Don't warn about metrics without data that were first committed within this long, e.g. 24h, since a new metric "+
+			"has no data until the code emitting it is deployed. 0 disables it")
+	requireData := flag.Bool("require-data", false,
+		"Fail, rather than warn, when a query or any metric in it returns no data, masked or not. For auditing a "+
+			"production account, where every metric should be live")
+	reportThreshold := flag.String("report-threshold", "",
+		"Warn about queries whose latest value crosses this threshold, e.g. >0 for an error rate, or <1 for a throughput, "+
+			"to check a set of queries like ad-hoc alerts")
+	compareAccounts := flag.Bool("compare-accounts", false,
+		"Also validate each query against a second account, e.g. staging next to prod, with the keys in "+
+			"DD_CLIENT_COMPARE_API_KEY and DD_CLIENT_COMPARE_APP_KEY, and warn about metrics with data in only one of them")
+	compareSite := flag.String("compare-site", "",
+		"The Datadog site of the -compare-accounts account, e.g. datadoghq.eu, if it's not the same as the first one's")
+	doctor := flag.Bool("doctor", false,
+		"Check the API keys, and that the API can be reached, with one known-good query, rather than linting any files")
+	printConfig := flag.Bool("print-config", false,
+		"Print the configuration the run would use, with every flag and what it resolves to, as JSON, rather than "+
+			"linting any files")
+	outputFile := flag.String("output-file", "",
+		"Also write the logs to this file as a plain text report, without colors, e.g. to keep as a CI artifact")
+	summaryOnly := flag.Bool("summary-only", false,
+		"Only log failures, followed by a summary of the run, rather than a line for every query and metric")
+	kind := flag.String("kind", kindDatadogMetric,
+		"The kind of file to extract queries from: datadogmetric (any yaml, see -query-path), formula, slo or terraform")
+	maxFailures := flag.Int("max-failures", 0,
+		"Stop once this many failures have been found, e.g. when the API key is wrong and every query fails. 0 means no limit")
+	failFast := flag.Bool("fail-fast", false, "Stop at the first failure, the same as -max-failures 1")
+	var include, exclude globList
 
-	// `args` here is just a list of files
+	flag.Var(&include, "include",
+		"Only lint files in directories that match this glob, e.g. **/datadogmetric-*.yaml. Can be repeated")
+	flag.Var(&exclude, "exclude",
+		"Skip files in directories that match this glob, e.g. **/examples/**. Can be repeated, and wins over -include")
+	var overrides tagOverrides
+
+	flag.Var(&overrides, "tag-override",
+		"Rewrite this tag in the queries sent to the API, e.g. env=staging turns env:production into env:staging. Can be repeated")
+	detectDuplicates := flag.Bool("detect-duplicates", false,
+		"Warn about queries that are defined in more than one file, after normalizing their whitespace")
+	gcpSecretAPIKey := flag.String("gcp-secret-api-key", "",
+		"GCP Secret Manager secret to read the API key from, e.g. projects/p/secrets/dd-api-key. Needs a build with -tags gcp")
+	gcpSecretAppKey := flag.String("gcp-secret-app-key", "",
+		"GCP Secret Manager secret to read the app key from, e.g. projects/p/secrets/dd-app-key. Needs a build with -tags gcp")
+	baselinePath := flag.String("baseline", "",
+		"YAML file of queries that were already failing; they're logged, but don't fail the run. See -write-baseline")
+	writeBaselineFile := flag.Bool("write-baseline", false,
+		"Write every query that fails to the -baseline file, rather than failing the run")
+	maxIdleConnsPerHost := flag.Int("max-idle-conns-per-host", 0,
+		"Idle API connections to keep open for reuse. 0 matches -parallel-metrics")
+	maxConnsPerHost := flag.Int("max-conns-per-host", 0,
+		"Cap on the open API connections, including ones in use. 0 means no limit")
+	seriesStatsFlag := flag.Bool("series-stats", false,
+		"Log the min, max, mean, median and p95 of each result over the window, and how many datapoints weren't null")
+	emptyQueryAsError := flag.Bool("skip-empty-query-as-error", false,
+		"Fail on files that don't contain a query, rather than skipping them with a warning")
+	quietSkips := flag.Bool("quiet-skips", false,
+		"Don't log the files that are skipped for not containing a query, e.g. when a pre-commit hook passes every yaml file")
+	expandEnvFlag := flag.Bool("expand-env", false,
+		"Substitute ${VAR} and $VAR in queries from the environment before validating them")
+	envFile := flag.String("env-file", "",
+		"KEY=value file of variables for -expand-env, which take precedence over the environment. Implies -expand-env")
+	commentPrefix := flag.String("comment-prefix", "",
+		"Strip comments starting with this, e.g. #, from queries before validating them, for queries annotated with "+
+			"lines like # owner:team-x. Datadog doesn't have query comments, so this is off by default")
+	warnAsError := flag.String("warn-as-error", "",
+		"Comma separated rules whose warnings fail the run, e.g. require-fill,suspicious-tag-filter")
+	fromCluster := flag.Bool("from-cluster", false,
+		"Also lint the DatadogMetrics in the current kubeconfig context's cluster. Needs a build with -tags k8s")
+	compareInnerOuter := flag.Bool("compare-inner-vs-outer", false,
+		"For each masked metric, also query it with its masking functions, and log that value next to the bare metric's")
+	outputTemplate := flag.String("output-template", "",
+		"Go text/template file to render the results with at the end of the run, e.g. for a Slack message or markdown")
+	githubReview := flag.Bool("github-review", false,
+		"Post the failing queries as a review on the pull request, with a comment on the line of each one. Reads "+
+			"GITHUB_TOKEN, GITHUB_REPOSITORY and GITHUB_REF (or GITHUB_PR_NUMBER)")
+	slackWebhook := flag.String("slack-webhook", "",
+		"Slack incoming webhook URL to post a summary to when the run has failures")
+	maxSeries := flag.Int("max-series", querylint.DefaultMaxSeries,
+		"How many series a query can match before the series-count rule fires")
+	maxQueryLength := flag.Int("max-query-length", 0,
+		"Warn about queries longer than this many characters, which are often generated or overly complex. 0 means no limit")
+	deprecatedPattern := flag.String("deprecated-pattern", querylint.DefaultDeprecatedPattern,
+		"Regexp a metric's metadata description or short name matches when it's deprecated, for the deprecated-metric rule")
+	catalogPath := flag.String("catalog", "",
+		"JSON or CSV file of every valid metric name, to check the metrics in each query exist against instead of the API")
+	requireTags := flag.String("require-tags", "",
+		"Comma separated tag keys every metric must filter by, e.g. env,service, for the required-tags rule")
+	var benignErrors regexpList
+
+	flag.Var(&benignErrors, "benign-error",
+		"Regexp for the message of an API error that isn't a problem with the query, e.g. 'maximum number of points', "+
+			"which is then a warning rather than a failure. Can be repeated")
+	maxRetries := flag.Int("max-retries", 0,
+		"How many times to retry an API call that was rate limited, or failed with a server or network error")
+	retryBudget := flag.Int64("retry-budget", 0,
+		"The most retries, for -max-retries and -retry-empty, across the whole run. 0 means no limit")
+	groupBy := flag.String("group-by", "",
+		"Group the failures and warnings at the end of the run: reason, to group them by rule or API error kind")
+	failOnWarning := flag.Bool("fail-on-warning", false,
+		fmt.Sprintf("Treat warnings as failures, rather than exiting with %d when there are only warnings", softFailExitCode))
+
+	// `args` here is a list of files, and directories to scan for them
 	flag.Parse()
-	files := flag.Args()
 
-	if len(files) == 0 {
+	files, err := expandPaths(flag.Args(), include, exclude)
+	if err != nil {
+		slog.Error("Failed to find files to lint", slog.Any("err", err))
+		os.Exit(1)
+	}
+
+	logLevel := "DEBUG"
+
+	// Errors are still logged, so the details of every failure are there, just not the noise from everything that passed.
+	if *summaryOnly {
+		logLevel = "ERROR"
+	}
+
+	var report io.Writer
+
+	if *outputFile != "" {
+		reportFile, err := os.Create(*outputFile)
+		if err != nil {
+			slog.Error("Failed to create -output-file", slog.Any("err", err))
+			os.Exit(1)
+		}
+
+		defer reportFile.Close()
+
+		report = reportFile
+	}
+
+	setupLogger(logLevel, report)
+
+	if len(files) == 0 && !*doctor && !*printConfig && !*fromCluster {
 		slog.Error("Please provide a list of files to process")
 	}
 
+	rules, err := resolveRules(ruleFlags, *warnAsError)
+	if err != nil {
+		slog.Error("Invalid rule severities", slog.Any("err", err))
+		os.Exit(1)
+	}
+
+	if *kind != kindDatadogMetric && *kind != kindTerraform && *kind != kindSLO && *kind != kindFormula {
+		slog.Error("Invalid -kind", slog.String("kind", *kind))
+		os.Exit(1)
+	}
+
+	deprecated, err := regexp.Compile(*deprecatedPattern)
+	if err != nil {
+		slog.Error("Invalid -deprecated-pattern", slog.Any("err", err))
+		os.Exit(1)
+	}
+
+	var catalog *querylint.Catalog
+
+	if *catalogPath != "" {
+		catalog, err = querylint.LoadCatalog(*catalogPath)
+		if err != nil {
+			slog.Error("Failed to load -catalog", slog.Any("err", err))
+			os.Exit(1)
+		}
+	}
+
+	var requiredTags []string
+
+	for _, key := range strings.Split(*requireTags, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			requiredTags = append(requiredTags, key)
+		}
+	}
+
+	if *groupBy != "" && *groupBy != groupByReason {
+		slog.Error("Invalid -group-by, expected reason", slog.String("group_by", *groupBy))
+		os.Exit(1)
+	}
+
+	known := baseline{}
+
+	switch {
+	case *writeBaselineFile && *baselinePath == "":
+		slog.Error("-write-baseline needs a -baseline file to write to")
+		os.Exit(1)
+	case *baselinePath != "" && !*writeBaselineFile:
+		known, err = loadBaseline(*baselinePath)
+		if err != nil {
+			slog.Error("Failed to load -baseline", slog.Any("err", err))
+			os.Exit(1)
+		}
+	}
+
+	var lookupEnv func(string) (string, bool)
+
+	if *expandEnvFlag || *envFile != "" {
+		lookupEnv, err = envLookup(*envFile)
+		if err != nil {
+			slog.Error("Failed to load -env-file", slog.Any("err", err))
+			os.Exit(1)
+		}
+	}
+
+	var tmpl *template.Template
+
+	if *outputTemplate != "" {
+		tmpl, err = loadOutputTemplate(*outputTemplate)
+		if err != nil {
+			slog.Error("Failed to load -output-template", slog.Any("err", err))
+			os.Exit(1)
+		}
+	}
+
+	windows, err := parseWindows(*windowList)
+	if err != nil {
+		slog.Error("Invalid -windows", slog.Any("err", err))
+		os.Exit(1)
+	}
+
+	if *rollup < 0 || *rollup%time.Second != 0 {
+		slog.Error("Invalid -rollup, expected a whole number of seconds", slog.Duration("rollup", *rollup))
+		os.Exit(1)
+	}
+
+	var reportAt *threshold
+
+	if *reportThreshold != "" {
+		parsed, err := parseThreshold(*reportThreshold)
+		if err != nil {
+			slog.Error("Invalid -report-threshold", slog.Any("err", err))
+			os.Exit(1)
+		}
+
+		reportAt = &parsed
+	}
+
+	parallelMetrics, err := parseParallelMetrics(*parallelMetricsFlag, runtime.GOMAXPROCS(0), *maxConnsPerHost)
+	if err != nil {
+		slog.Error("Invalid -parallel-metrics", slog.Any("err", err))
+		os.Exit(1)
+	}
+
+	var pr *githubPR
+
+	if *githubReview {
+		found, err := githubPRFromEnv(os.LookupEnv)
+
+		switch {
+		case errors.Is(err, errNoPullRequest):
+			// The same CI step often runs on pushes too, where there's nothing to review.
+			slog.Warn("Not running for a pull request, so -github-review is skipped", slog.Any("err", err))
+		case err != nil:
+			slog.Error("Invalid -github-review environment", slog.Any("err", err))
+			os.Exit(1)
+		default:
+			pr = &found
+		}
+	}
+
+	if *onlyChanged {
+		err = verifyRevision(*baseRef)
+		if err != nil {
+			slog.Error("Invalid -base-ref for -only-changed-metrics; in a shallow clone, fetch it first",
+				slog.String("base_ref", *baseRef),
+				slog.Any("err", err),
+			)
+			os.Exit(1)
+		}
+	}
+
+	apiKey := os.Getenv("DD_CLIENT_API_KEY")
+	appKey := os.Getenv("DD_CLIENT_APP_KEY")
+
+	// Secrets from GCP Secret Manager win over the env vars, so CI doesn't need any `gcloud secrets` glue.
+	secrets := []struct {
+		name string
+		key  *string
+	}{
+		{*gcpSecretAPIKey, &apiKey},
+		{*gcpSecretAppKey, &appKey},
+	}
+
+	for _, secret := range secrets {
+		if secret.name == "" {
+			continue
+		}
+
+		*secret.key, err = fetchGCPSecret(context.Background(), secret.name)
+		if err != nil {
+			slog.Error("Failed to fetch key from GCP Secret Manager",
+				slog.String("secret", secret.name),
+				slog.Any("err", err),
+			)
+			os.Exit(1)
+		}
+	}
+
 	// configure the context with the required API auth tokens
 	ctx := context.WithValue(
 		context.Background(),
 		datadog.ContextAPIKeys,
 		map[string]datadog.APIKey{
 			"apiKeyAuth": {
-				Key: os.Getenv("DD_CLIENT_API_KEY"),
+				Key: apiKey,
 			},
 			"appKeyAuth": {
-				Key: os.Getenv("DD_CLIENT_APP_KEY"),
+				Key: appKey,
 			},
 		},
 	)
 
-	apiClient := datadog.NewAPIClient(datadog.NewConfiguration())
-	api := datadogV1.NewMetricsApi(apiClient)
+	if *insecureSkipVerify {
+		slog.Warn("TLS certificate verification is disabled, API requests can be intercepted")
+	}
+
+	httpClient, err := newHTTPClient(httpOptions{
+		proxyURL:           *proxy,
+		caCertFile:         *caCert,
+		insecureSkipVerify: *insecureSkipVerify,
+		// Each of the metrics being validated at once needs a connection, plus the next full query.
+		maxIdleConnsPerHost: cmp.Or(*maxIdleConnsPerHost, parallelMetrics+1),
+		maxConnsPerHost:     *maxConnsPerHost,
+	})
+	if err != nil {
+		slog.Error("Failed to configure the HTTP client", slog.Any("err", err))
+		os.Exit(1)
+	}
+
+	cfg := datadog.NewConfiguration()
+	cfg.HTTPClient = httpClient
+
+	apiClient := datadog.NewAPIClient(cfg)
+	newValidator := func() *querylint.Validator {
+		validator := querylint.NewValidator(datadogV1.NewMetricsApi(apiClient))
+		validator.RetryEmpty = *retryEmpty
+		validator.MaxRetries = *maxRetries
+		validator.BenignErrors = benignErrors
+		validator.RetryBudget = *retryBudget
+		validator.CompareMasked = *compareInnerOuter
+		validator.TagOverrides = overrides
+		validator.CheckDeprecated = rules[querylint.RuleDeprecatedMetric] != querylint.SeverityOff
+		validator.DeprecatedPattern = deprecated
+		validator.MetricConcurrency = parallelMetrics
+		validator.BatchSize = *batchSize
+		validator.Windows = windows
+		validator.Rollup = *rollup
+
+		return validator
+	}
+
+	validator := newValidator()
+
+	var account *compareAccount
+
+	if *compareAccounts {
+		account = &compareAccount{
+			validator: newValidator(),
+			apiKey:    os.Getenv("DD_CLIENT_COMPARE_API_KEY"),
+			appKey:    os.Getenv("DD_CLIENT_COMPARE_APP_KEY"),
+			site:      *compareSite,
+		}
+
+		// Only whether the metrics have data is compared, so the extra API calls for these would be wasted.
+		account.validator.CompareMasked = false
+		account.validator.CheckDeprecated = false
 
-	failures := 0
+		if account.apiKey == "" || account.appKey == "" {
+			slog.Error("-compare-accounts needs DD_CLIENT_COMPARE_API_KEY and DD_CLIENT_COMPARE_APP_KEY to be set")
+			os.Exit(1)
+		}
+	}
 
-	for _, file := range files {
-		query, err := extractQuery(file)
+	// Everything's resolved by now, so this is what the run would actually use, e.g. to compare CI with a local run.
+	if *printConfig {
+		// The server is only left out if it couldn't be resolved, which the first API call would report anyway.
+		server, _ := cfg.ServerURLWithContext(ctx, "v1.MetricsApi.QueryMetrics")
+
+		err := writeConfig(os.Stdout, newEffectiveConfig(flag.CommandLine, rules, validator, server, apiKey, appKey, len(files)))
 		if err != nil {
-			slog.Error("Error extracting query from file",
-				slog.String("filename", file),
-				slog.Any("err", err),
-			)
+			slog.Error("Failed to write -print-config", slog.Any("err", err))
+			os.Exit(1)
+		}
+
+		return
+	}
 
-			failures++
+	if *doctor {
+		os.Exit(runDoctor(ctx, validator, cfg, apiKey, appKey))
+	}
+
+	var metrics *runMetrics
 
+	if *metricsAddr != "" {
+		metrics = newRunMetrics(validator.Retries, validator.SkippedRetries)
+		metrics.serve(*metricsAddr)
+	}
+
+	// Ctrl-C, or CI cancelling the job, cancels the API calls in flight and stops the run, but still reports on what was
+	// validated.
+	ctx, stopSignals := catchSignals(ctx)
+	defer stopSignals()
+
+	signaled := ctx
+
+	if *maxDuration > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, *maxDuration)
+		defer cancel()
+	}
+
+	counts := tally{}
+
+	targets := collectTargets(files, *kind, *queryPath, *emptyQueryAsError, *quietSkips, &counts)
+
+	// What's actually deployed can drift from what's in git, so it's worth auditing on its own.
+	if *fromCluster {
+		deployed, err := listClusterTargets(ctx)
+		if err != nil {
+			slog.Error("Failed to list the DatadogMetrics in the cluster", slog.Any("err", err))
+			os.Exit(1)
+		}
+
+		targets = append(targets, deployed...)
+	}
+
+	r := &runner{
+		kind:             *kind,
+		queryPath:        *queryPath,
+		commentPrefix:    *commentPrefix,
+		lookupEnv:        lookupEnv,
+		detectDuplicates: *detectDuplicates,
+		printCanonical:   *printCanonical,
+		printMetrics:     *printMetrics,
+		dumpAST:          *dumpASTFlag,
+		explain:          *explain,
+		maxQueryLength:   *maxQueryLength,
+		rules:            rules,
+		requiredTags:     requiredTags,
+		onlyChanged:      *onlyChanged,
+		baseRef:          *baseRef,
+		catalog:          catalog,
+		validator:        validator,
+		account:          account,
+		fresh:            newNewMetrics(*newMetricGrace, targets, gitRepoHistory()),
+		seriesStats:      *seriesStatsFlag,
+		requireData:      *requireData,
+		reportAt:         reportAt,
+		maxSeries:        *maxSeries,
+		maxFailures:      failureLimit(*maxFailures, *failFast),
+		maxDuration:      *maxDuration,
+		known:            known,
+		writeBaseline:    *writeBaselineFile,
+		metrics:          metrics,
+		counts:           counts,
+	}
+
+	// The progress is noise next to the output of -print-canonical, -print-metrics and -dump-ast, and defeats the point of
+	// -summary-only.
+	if !*summaryOnly && !*printCanonical && !*printMetrics && !*dumpASTFlag {
+		r.bar = startProgress(os.Stderr, len(targets))
+	}
+
+	stopped := r.lintTargets(ctx, signaled, targets)
+	counts = r.counts
+
+	if *printMetrics {
+		printMetricNames(os.Stdout, r.usedMetrics)
+	}
+
+	if *writeBaselineFile {
+		err = writeBaseline(*baselinePath, r.failing)
+		if err != nil {
+			slog.Error("Failed to write -baseline", slog.Any("err", err))
+			os.Exit(1)
+		}
+
+		slog.Info("Wrote the failing queries to the baseline",
+			slog.String("baseline", *baselinePath),
+			slog.Int("queries", len(r.failing)),
+		)
+
+		return
+	}
+
+	reportDuplicates(r.seen, &counts)
+	metrics.setTally(counts)
+
+	// The API was flaky across the board, so some of the failures might have passed with more retries.
+	if skipped := validator.SkippedRetries(); skipped > 0 {
+		slog.Warn("The retry budget ran out, so some API calls weren't retried",
+			slog.Int64("retry_budget", *retryBudget),
+			slog.Int64("skipped_retries", skipped),
+		)
+	}
+
+	if *summaryOnly {
+		writeSummary(os.Stdout, report, len(files), counts)
+	}
+
+	if *groupBy == groupByReason {
+		err = writeReasonGroups(os.Stdout, groupByReasons(counts.problems))
+		if err != nil {
+			slog.Error("Failed to write the problems grouped by reason", slog.Any("err", err))
+		}
+	}
+
+	if tmpl != nil {
+		err = renderOutputTemplate(os.Stdout, tmpl, templateData{
+			Results:  r.results,
+			Files:    len(files),
+			Failures: counts.failures,
+			Warnings: counts.warnings,
+			Skipped:  counts.skipped,
+			TimedOut: stopped == stoppedByDuration,
+		})
+		if err != nil {
+			slog.Error("Failed to render -output-template", slog.Any("err", err))
+			os.Exit(1)
+		}
+	}
+
+	// Best effort, like Slack.
+	if pr != nil && len(r.reviewFailures) > 0 {
+		err = postReview(context.Background(), *pr, buildReview(r.reviewFailures, repoRoot()))
+		if err != nil {
+			slog.Warn("Failed to post the -github-review", slog.Any("err", err))
+		}
+	}
+
+	// Best effort: the run's outcome is already decided, so Slack being unreachable mustn't change it.
+	if *slackWebhook != "" && counts.failures > 0 {
+		err = postSlack(context.Background(), *slackWebhook, slackSummary(len(files), counts, r.failing))
+		if err != nil {
+			slog.Warn("Failed to post the summary to -slack-webhook", slog.Any("err", err))
+		}
+	}
+
+	if *failOnWarning {
+		counts.failures += counts.warnings
+	}
+
+	if code := exitCode(counts, signaled.Err() != nil, stopped == stoppedByDuration); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// Print the -summary-only summary of the run: the totals, then the findings of each rule. It's what -summary-only is
+// for, so it goes in the -output-file report too, if there is one.
+func writeSummary(stdout io.Writer, report io.Writer, files int, counts tally) {
+	w := stdout
+	if report != nil {
+		w = io.MultiWriter(stdout, report)
+	}
+
+	breakdown := ""
+	if apiErrors := counts.apiErrorBreakdown(); apiErrors != "" {
+		breakdown = fmt.Sprintf(" (API errors: %s)", apiErrors)
+	}
+
+	interrupted := ""
+	if counts.interrupted > 0 {
+		interrupted = fmt.Sprintf(", %d API call(s) cut short by a timeout or cancellation", counts.interrupted)
+	}
+
+	skipped := ""
+	if counts.skipped > 0 {
+		skipped = fmt.Sprintf(", %d query(s) skipped as unchanged from -base-ref", counts.skipped)
+	}
+
+	fmt.Fprintf(w, "Linted %d file(s): %d failure(s)%s, %d warning(s)%s%s\n",
+		files, counts.failures, breakdown, counts.warnings, interrupted, skipped)
+
+	for _, line := range counts.ruleBreakdown() {
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+}
+
+// The exit code for how the run went. A run that was cut short says so, whatever it found before then.
+func exitCode(counts tally, interrupted bool, timedOut bool) int {
+	switch {
+	case interrupted:
+		return interruptedExitCode
+	case timedOut:
+		return timedOutExitCode
+	case counts.failures > 0:
+		return failureExitCode
+	case counts.warnings > 0:
+		return softFailExitCode
+	default:
+		return 0
+	}
+}
+
+// Resolve the severity of each rule from its flag, then promote the rules in -warn-as-error from warnings to errors.
+func resolveRules(ruleFlags map[string]*string, warnAsError string) (querylint.Rules, error) {
+	rules := querylint.Rules{}
+
+	for rule, value := range ruleFlags {
+		severity, err := querylint.ParseSeverity(*value)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Invalid -%s", rule))
+		}
+
+		rules[rule] = severity
+	}
+
+	// Promoting the rules one at a time lets the strictness be ratcheted up, without -fail-on-warning failing on every
+	// warning at once. A rule that's off stays off.
+	for _, rule := range strings.Split(warnAsError, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
 			continue
 		}
 
-		// The file was valid yaml, but didnt contain a `spec.query` field, so while it's technically invalid, this
-		// shouldn't count as a failure for the linting process. Just move on and dont increment `failures`.
-		if query == "" {
-			slog.Warn("File didn't contain a metric query, skipping it", slog.String("filename", file))
+		if _, ok := ruleFlags[rule]; !ok {
+			return nil, fmt.Errorf("unknown rule in -warn-as-error: %s", rule)
+		}
+
+		if rules[rule] == querylint.SeverityWarn {
+			rules[rule] = querylint.SeverityError
+		}
+	}
+
+	return rules, nil
+}
+
+// The number of failures the run stops at, or 0 to never stop early. -fail-fast is just the common case of
+// -max-failures.
+func failureLimit(maxFailures int, failFast bool) int {
+	if failFast {
+		return 1
+	}
+
+	return maxFailures
+}
+
+// Parse -parallel-metrics: a number, or auto for one per CPU, so the API calls, and the parsing and logging between them,
+// keep every CPU busy. With auto, -max-conns-per-host caps it, since any more would only wait for a free connection,
+// and it's the knob for staying within the API's rate limits.
+func parseParallelMetrics(value string, cpus int, maxConnsPerHost int) (int, error) {
+	if value == parallelMetricsAuto {
+		if maxConnsPerHost > 0 {
+			return min(cpus, maxConnsPerHost), nil
+		}
+
+		return cpus, nil
+	}
+
+	parallel, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("Failed to parse -parallel-metrics, expected a number or %s: %s",
+			parallelMetricsAuto, value))
+	}
+
+	return parallel, nil
+}
+
+// Print the explanation for a query to stdout, outside of the logger so it shows up regardless of the log level.
+func printExplanation(file string, result querylint.Result, err error) {
+	fmt.Fprintf(os.Stdout, "%s:\n%s\n\n", file, querylint.Explain(result, err))
+}
+
+// Log each query that was found in more than one file, and count it as a warning. The same query being defined twice
+// is usually a copy-paste mistake.
+func reportDuplicates(seen map[string][]string, counts *tally) {
+	canonicals := make([]string, 0, len(seen))
+
+	for canonical, files := range seen {
+		if len(files) > 1 {
+			canonicals = append(canonicals, canonical)
+		}
+	}
+
+	sort.Strings(canonicals)
+
+	for _, canonical := range canonicals {
+		slog.Warn("Query is defined in more than one file",
+			slog.String("canonical", canonical),
+			slog.Any("files", seen[canonical]),
+		)
+
+		counts.warn(reasonDuplicate, strings.Join(seen[canonical], ", "))
+	}
+}
+
+// Print a query's analysis as indented JSON, for reporting parser bugs, or generating test fixtures. The field names
+// are the Go ones, so the analysis can be unmarshaled straight back into a querylint.QueryAnalysis.
+func dumpAST(w io.Writer, file string, analysis querylint.QueryAnalysis) error {
+	data, err := json.MarshalIndent(struct {
+		File     string
+		Analysis querylint.QueryAnalysis
+	}{file, analysis}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Failed to marshal the analysis: %s", file))
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", data)
+
+	return err //nolint:wrapcheck
+}
+
+// Print each metric name on its own line, sorted, for -print-metrics. That's also a CSV -catalog, with a column of names.
+func printMetricNames(w io.Writer, names map[string]struct{}) {
+	sorted := make([]string, 0, len(names))
+
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		fmt.Fprintf(w, "%s\n", name)
+	}
+}
+
+// Check that every metric in the query is in the -catalog, counting each that isn't as a failure.
+func reportCatalog(file string, query string, analysis querylint.QueryAnalysis, catalog *querylint.Catalog, counts *tally) {
+	missing := catalog.Missing(analysis)
+
+	for _, metric := range missing {
+		slog.Error("Metric isn't in the catalog",
+			slog.String("file", file),
+			slog.String("metric", metric.CleanMetric),
+			slog.String("name", querylint.MetricName(metric)),
+		)
+
+		counts.fail(reasonNotInCatalog, file)
+	}
+
+	if len(missing) == 0 {
+		slog.Info("Every metric in the query is in the catalog",
+			slog.String("file", file),
+			queryAttr(query),
+			slog.Int("metrics", len(analysis.Metrics)),
+		)
+	}
+}
+
+// Log the findings from the static lint rules, and count them as failures or warnings depending on their severity.
+func reportFindings(file string, findings []querylint.Finding, counts *tally) {
+	for _, finding := range findings {
+		counts.countFinding(finding)
+
+		attrs := []any{
+			slog.String("file", file),
+			slog.String("rule", finding.Rule),
+		}
+
+		// Some rules are about the whole query, rather than one of its metrics.
+		if finding.Metric.CleanMetric != "" {
+			attrs = append(attrs, slog.String("metric", finding.Metric.CleanMetric))
+		}
+
+		if finding.Severity == querylint.SeverityError {
+			slog.Error(finding.Message, attrs...)
+
+			counts.fail(finding.Rule, file)
+		} else {
+			slog.Warn(finding.Message, attrs...)
+
+			counts.warn(finding.Rule, file)
+		}
+	}
+}
+
+// Log the outcome of each metric inside the query, and count the failures and warnings. A metric that makes up the
+// whole query was already reported along with the query itself, so it's skipped here.
+func reportMetrics(file string, result querylint.Result, rules querylint.Rules, withStats bool, requireData bool,
+	fresh *newMetrics, counts *tally,
+) {
+	for _, metric := range result.Metrics {
+		if result.Analysis.MakesUpQuery(metric.Metric) {
 			continue
 		}
 
-		value, err := fetchMetric(ctx, api, query)
+		// The masked-in-complex-query rule reported it already, at the severity it's running at.
+		maskedRule := rules[querylint.RuleMaskedInComplexQuery] != querylint.SeverityOff
+		if maskedRule && querylint.MaskedInComplexQuery(result, metric) {
+			continue
+		}
 
-		var mqe *MetricQueryError
-		if err != nil {
-			if errors.As(err, &mqe) {
-				slog.Error("Error calling `MetricsApi.Querymetrics`",
-					slog.String("file", file),
-					slog.String("query", query),
-					slog.Any("err", mqe.NestedError),
-				)
+		attrs := []any{
+			slog.String("file", file),
+			slog.String("metric", metric.Metric.CleanMetric),
+			slog.Duration("api_latency", metric.APILatency),
+		}
+
+		if metric.Status == querylint.StatusNoData || metric.Status == querylint.StatusMasked {
+			if firstSeen, ok := fresh.isNew(metric.Metric); ok {
+				slog.Info("Metric returned no data, but it's new, within -new-metric-grace",
+					append(attrs, slog.Time("first_seen", firstSeen))...)
+
+				continue
 			}
+		}
 
-			failures++
-		} else {
-			if value == nil {
-				slog.Warn("Query returned no data; the metric might not be real or there may not be any datapoints",
-					slog.String("file", file),
-					slog.String("query", query),
-				)
-			} else {
-				slog.Info("Query result",
-					slog.String("file", file),
-					slog.String("query", query),
-					slog.Float64("value", *value.Get()),
-				)
+		switch metric.Status {
+		case querylint.StatusOK:
+			attrs = append(attrs, slog.Float64("value", *metric.Value), slog.Duration("window", metric.Window))
+
+			if withStats {
+				attrs = append(attrs, statsAttr(metric.Stats))
+			}
+
+			slog.Debug("Metric result", attrs...)
+		case querylint.StatusNoData:
+			slog.Log(context.Background(), noDataLevel(requireData),
+				"Metric returned no data; it might not be real or there may not be any datapoints", attrs...)
+			printMetricSpan(noDataLevel(requireData), result.Query, metric.Metric)
+
+			counts.noData(reasonNoData, file, requireData)
+		case querylint.StatusMasked:
+			attrs = append(attrs, slog.Any("masked_by", metric.Metric.MaskingFunctions))
+
+			// With -compare-inner-vs-outer, show the value the masking function is making up.
+			switch {
+			case metric.OuterErr != nil:
+				attrs = append(attrs, slog.Any("outer_err", metric.OuterErr))
+			case metric.OuterValue != nil:
+				attrs = append(attrs, slog.Float64("outer_value", *metric.OuterValue), slog.String("inner_value", "no data"))
+			}
+
+			slog.Log(context.Background(), noDataLevel(requireData),
+				"Metric returned no data, but a masking function is hiding that in the query", attrs...)
+			printMetricSpan(noDataLevel(requireData), result.Query, metric.Metric)
+
+			counts.noData(reasonMasked, file, requireData)
+		case querylint.StatusError:
+			attrs = append(attrs, slog.Any("err", metric.Err))
+
+			if isInterrupted(metric.Err) {
+				slog.Warn("API call for the metric was cut short, so it wasn't validated", attrs...)
+
+				counts.interrupted++
+
+				continue
+			}
+
+			if isBenign(metric.Err) {
+				slog.Warn("API rejected the metric with a -benign-error, so it wasn't validated", attrs...)
+
+				counts.warn(apiErrorReason(metric.Err), file)
+
+				continue
+			}
+
+			var mqe *querylint.MetricQueryError
+			if errors.As(metric.Err, &mqe) {
+				attrs = append(attrs, slog.String("request_id", mqe.RequestID))
 			}
+
+			slog.Error("Error validating metric", attrs...)
+			printMetricSpan(slog.LevelError, result.Query, metric.Metric)
+
+			counts.fail(apiErrorReason(metric.Err), file)
+			counts.countAPIError(metric.Err)
+		case querylint.StatusSparse:
+			slog.Info("Metric has a series, but no datapoints in the window",
+				append(attrs, slog.Duration("interval", metric.Interval), slog.Duration("window", metric.Window))...,
+			)
 		}
 	}
+}
 
-	if failures > 0 {
-		os.Exit(failures)
+// Under the log line for one of the metrics in a complex query, print the query with the metric highlighted, which is
+// much easier to take in than its clean form on its own. It's only printed if the log line was.
+func printMetricSpan(level slog.Level, query string, metric querylint.MetricInfo) {
+	if !slog.Default().Enabled(context.Background(), level) {
+		return
 	}
+
+	fmt.Fprintf(os.Stdout, "%s\n", highlightSpan(query, metric.StartPos, metric.EndPos, true))
+}
+
+// The line the query is on in its file, or nothing when it isn't known, e.g. for -from-cluster.
+func lineAttr(line int) slog.Attr {
+	if line == 0 {
+		return slog.Attr{}
+	}
+
+	return slog.Int("line", line)
+}
+
+// Which of the comma separated queries returned no data, as a log attribute, or nothing if there's only one query.
+func emptyQueriesAttr(result querylint.Result) slog.Attr {
+	var empty []string
+
+	for _, queryResult := range result.Queries {
+		if queryResult.Value == nil {
+			empty = append(empty, queryResult.Query)
+		}
+	}
+
+	if len(empty) == 0 {
+		return slog.Attr{}
+	}
+
+	return slog.Any("empty_queries", empty)
+}
+
+// The query as a log attribute, cut down to its first loggedQueryLength characters so a generated query thousands of
+// characters long doesn't swamp the log line. -dump-ast and -output-template still have the whole query.
+func queryAttr(query string) slog.Attr {
+	if utf8.RuneCountInString(query) <= loggedQueryLength {
+		return slog.String("query", query)
+	}
+
+	return slog.String("query", string([]rune(query)[:loggedQueryLength])+"…")
+}
+
+// The -series-stats for a result, as a group of attributes, e.g. `stats.min=1 stats.max=4 ...`.
+func statsAttr(stats *querylint.SeriesStats) slog.Attr {
+	if stats == nil {
+		return slog.Group("stats")
+	}
+
+	return slog.Group("stats",
+		slog.Int("points", stats.Points),
+		slog.Int("non_null", stats.NonNull),
+		slog.Float64("min", stats.Min),
+		slog.Float64("max", stats.Max),
+		slog.Float64("mean", stats.Mean),
+		slog.Float64("p50", stats.P50),
+		slog.Float64("p95", stats.P95),
+		slog.Float64("latest", stats.Latest),
+	)
 }
 
-func setupLogger(logLevel string) {
+func setupLogger(logLevel string, report io.Writer) {
 	var level slog.Level
 
 	switch logLevel {
@@ -132,69 +1260,18 @@ func setupLogger(logLevel string) {
 		level = slog.LevelInfo
 	}
 
-	handler := tint.NewHandler(os.Stdout, &tint.Options{
+	var handler slog.Handler = tint.NewHandler(os.Stdout, &tint.Options{
 		AddSource:  false,
 		Level:      level,
 		TimeFormat: time.RFC3339,
 	})
-	logger := slog.New(handler)
 
-	slog.SetDefault(logger)
-}
-
-// Load the yaml file, and extract `spec.query` from the data. This is the datadog query that needs to be
-// validated, which is returned as a string.
-func extractQuery(filePath string) (string, error) {
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return "", errors.Wrap(err, fmt.Sprintf("Failed to read file: %s", filePath))
+	// The report is meant to be kept as a CI artifact, so it's plain text, without the colors.
+	if report != nil {
+		handler = teeHandler{handler, slog.NewTextHandler(report, &slog.HandlerOptions{Level: level})}
 	}
 
-	var metric DatadogMetricDefinition
-
-	err = yaml.Unmarshal(data, &metric)
-	if err != nil {
-		return "", errors.Wrap(err, fmt.Sprintf("Failed to unmarshal yaml: %s", filePath))
-	}
-
-	return metric.Spec.Query, nil
-}
-
-// Fetch the metric value for the specified query from the Datadog API, if possible.
-func fetchMetric(ctx context.Context, api *datadogV1.MetricsApi, query string) (*datadog.NullableFloat64, error) {
-	fiveMinAgo := time.Now().Add(-1 * time.Minute).Unix()
-	metricResp, httpResp, err := api.QueryMetrics(ctx, fiveMinAgo, time.Now().Unix(), query)
-
-	switch {
-	case err != nil:
-		// HTTP error or some other lower level issue.
-		mqe := &MetricQueryError{
-			HTTPResponse: httpResp,
-			NestedError:  err,
-		}
-
-		return nil, mqe
-
-	case metricResp.Status != nil && *metricResp.Status == "error":
-		// Error occurred in the API, so it's a bad query, bad auth, or something similar.
-		mqe := &MetricQueryError{
-			HTTPResponse: httpResp,
-			NestedError:  fmt.Errorf("MetricResponseError: %v", *metricResp.Error),
-		}
-
-		return nil, mqe
+	logger := slog.New(handler)
 
-	default:
-		// The API call technically succeeded in that the query wasn't malformed.
-		// Note that this doesn't mean the metric is necessarily a real metric, just that the query succeeded.
-		if len(metricResp.Series) > 0 && metricResp.Series[0].End != nil {
-			// Return the value of the latest datapoint in the time series.
-			value := *metricResp.Series[0].Pointlist[len(metricResp.Series[0].Pointlist)-1][1]
-			return datadog.NewNullableFloat64(&value), nil
-		} else {
-			// No time series was returned, so it's probably a metric without data or it doesn't exist.
-			//nolint:nilnil
-			return nil, nil
-		}
-	}
+	slog.SetDefault(logger)
 }
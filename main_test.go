@@ -1,49 +1,342 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
 	"strings"
 	"testing"
+
+	"github.com/persona-id/datadog-query-linter/querylint"
 )
 
-func TestFileLoading(t *testing.T) {
-	t.Run("validate that files load", func(t *testing.T) {
-		query, err := extractQuery("tests/datadogmetric-working.yaml")
-		if err != nil {
-			t.Fatalf("Expected no error, got %v", err)
+func TestReportDuplicates(t *testing.T) {
+	counts := tally{}
+
+	reportDuplicates(map[string][]string{
+		"avg:foo{*} | avg:foo{*}": {"a.yaml", "b.yaml"},
+		"avg:bar{*} | avg:bar{*}": {"c.yaml"},
+	}, &counts)
+
+	if counts.warnings != 1 || counts.failures != 0 {
+		t.Errorf("Expected 1 warning and no failures, got %d and %d", counts.warnings, counts.failures)
+	}
+}
+
+func TestReportCatalog(t *testing.T) {
+	counts := tally{}
+	catalog := querylint.NewCatalog([]string{"foo", "bar.*"})
+
+	query := "avg:foo{*} + avg:bar.count{*} + avg:baz{*} / avg:qux{*}"
+	reportCatalog("a.yaml", query, querylint.ParseQuery(query), catalog, &counts)
+
+	if counts.failures != 2 || counts.warnings != 0 {
+		t.Errorf("Expected 2 failures and no warnings, got %d and %d", counts.failures, counts.warnings)
+	}
+}
+
+func TestReportMetricsRequireData(t *testing.T) {
+	query := "avg:foo{*} + default_zero(avg:bar{*}) + avg:baz{*}"
+	analysis := querylint.ParseQuery(query)
+	value := 1.0
+
+	result := querylint.Result{
+		Query:    query,
+		Analysis: analysis,
+		Metrics: []querylint.MetricResult{
+			{Metric: analysis.Metrics[0], Status: querylint.StatusNoData},
+			{Metric: analysis.Metrics[1], Status: querylint.StatusMasked},
+			{Metric: analysis.Metrics[2], Status: querylint.StatusOK, Value: &value},
+		},
+	}
+
+	t.Run("no data is a warning by default", func(t *testing.T) {
+		counts := tally{}
+
+		reportMetrics("a.yaml", result, nil, false, false, nil, &counts)
+
+		if counts.warnings != 2 || counts.failures != 0 {
+			t.Errorf("Expected 2 warnings and no failures, got %d and %d", counts.warnings, counts.failures)
 		}
+	})
 
-		expectedQuery := "default_zero(avg:rails.temporal.workflow_task.queue_time.avg{app:persona-web-temporal-worker-retention,env:production,region:us-central1,task_queue:retention}.fill(null))"
-		if query != expectedQuery {
-			t.Errorf("Expected query %q, got %q", expectedQuery, query)
+	t.Run("no data is a failure with -require-data", func(t *testing.T) {
+		counts := tally{}
+
+		reportMetrics("a.yaml", result, nil, false, true, nil, &counts)
+
+		if counts.failures != 2 || counts.warnings != 0 {
+			t.Errorf("Expected 2 failures and no warnings, got %d and %d", counts.failures, counts.warnings)
 		}
 	})
+}
+
+func TestReportMetricsMaskedInComplexQuery(t *testing.T) {
+	query := "avg:foo{*} + default_zero(avg:bar{*})"
+	analysis := querylint.ParseQuery(query)
+	value := 1.0
+
+	result := querylint.Result{
+		Query:    query,
+		Analysis: analysis,
+		Value:    &value,
+		Metrics: []querylint.MetricResult{
+			{Metric: analysis.Metrics[0], Status: querylint.StatusOK, Value: &value},
+			{Metric: analysis.Metrics[1], Status: querylint.StatusError, Err: errors.New("Error parsing query")},
+		},
+	}
 
-	t.Run("error if the files don't exist", func(t *testing.T) {
-		_, err := extractQuery("tests/datadogmetric-no-file.yaml")
-		if err == nil {
-			t.Fatalf("Expected an error but didn't receive one.")
+	t.Run("a failed masked metric is a failure with the rule off", func(t *testing.T) {
+		counts := tally{}
+
+		reportMetrics("a.yaml", result, nil, false, false, nil, &counts)
+
+		if counts.failures != 1 {
+			t.Errorf("Expected 1 failure, got %d", counts.failures)
 		}
+	})
+
+	t.Run("the rule reports it instead when it's on", func(t *testing.T) {
+		counts := tally{}
+		rules := querylint.Rules{querylint.RuleMaskedInComplexQuery: querylint.SeverityWarn}
+
+		reportFindings("a.yaml", querylint.LintResult(result, rules, querylint.DefaultMaxSeries), &counts)
+		reportMetrics("a.yaml", result, rules, false, false, nil, &counts)
 
-		expectedErr := "Failed to read file: tests/datadogmetric-no-file.yaml: open tests/datadogmetric-no-file.yaml: no such file or directory"
-		if err.Error() != expectedErr {
-			t.Fatalf("Expected error string `%s` but got `%v`.", expectedErr, err)
+		if counts.failures != 0 || counts.warnings != 1 {
+			t.Errorf("Expected 1 warning and no failures, got %d and %d", counts.warnings, counts.failures)
 		}
 	})
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name        string
+		counts      tally
+		interrupted bool
+		timedOut    bool
+		expected    int
+	}{
+		{"clean", tally{}, false, false, 0},
+		{"warnings", tally{warnings: 3}, false, false, softFailExitCode},
+		{"failures", tally{failures: 2, warnings: 3}, false, false, failureExitCode},
+		{"as many failures as the soft fail code", tally{failures: softFailExitCode}, false, false, failureExitCode},
+		{"as many failures as the timeout code", tally{failures: timedOutExitCode}, false, false, failureExitCode},
+		{"enough failures to wrap around to 0", tally{failures: 256}, false, false, failureExitCode},
+		{"timed out", tally{failures: 1}, false, true, timedOutExitCode},
+		{"interrupted", tally{failures: 1}, true, true, interruptedExitCode},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := exitCode(test.counts, test.interrupted, test.timedOut); actual != test.expected {
+				t.Errorf("Expected %d, got %d", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestAPIErrorBreakdown(t *testing.T) {
+	counts := tally{}
+
+	counts.countAPIError(&querylint.MetricQueryError{Kind: querylint.KindBadQuery})
+	counts.countAPIError(&querylint.MetricQueryError{Kind: querylint.KindAuth})
+	counts.countAPIError(&querylint.MetricQueryError{Kind: querylint.KindAuth})
+	counts.countAPIError(errors.New("not an API error"))
+
+	expected := "auth: 2, bad_query: 1"
+	if breakdown := counts.apiErrorBreakdown(); breakdown != expected {
+		t.Errorf("Expected %q, got %q", expected, breakdown)
+	}
+}
+
+func TestRuleBreakdown(t *testing.T) {
+	counts := tally{}
+
+	counts.countFinding(querylint.Finding{Rule: querylint.RuleRequireFill, Severity: querylint.SeverityWarn})
+	counts.countFinding(querylint.Finding{Rule: querylint.RuleRedundantDefaultZero, Severity: querylint.SeverityWarn})
+	counts.countFinding(querylint.Finding{Rule: querylint.RuleRedundantDefaultZero, Severity: querylint.SeverityWarn})
+	counts.countFinding(querylint.Finding{Rule: querylint.RuleRequireFill, Severity: querylint.SeverityError})
+
+	expected := []string{
+		"redundant-default-zero: 2 warnings",
+		"require-fill: 1 error",
+		"require-fill: 1 warning",
+	}
+
+	breakdown := counts.ruleBreakdown()
+	if strings.Join(breakdown, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected %q, got %q", expected, breakdown)
+	}
+}
+
+func TestSummaryOnlyWithOutputFile(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	var stdout, report bytes.Buffer
 
-	t.Run("error if the yaml is invalid", func(t *testing.T) {
-		_, err := extractQuery("tests/invalid-yaml.yaml")
-		if err == nil {
-			t.Fatalf("Exected an error unmarshaling yaml, but didn't receive one")
+	// What -summary-only with -output-file sets up: only errors are logged, to stdout and the report.
+	setupLogger("ERROR", &report)
+
+	counts := tally{}
+	counts.fail(reasonNoData, "a.yaml")
+	slog.Error("Query returned no data", slog.String("file", "a.yaml"))
+
+	writeSummary(&stdout, &report, 3, counts)
+
+	expected := "Linted 3 file(s): 1 failure(s), 0 warning(s)\n"
+	if stdout.String() != expected {
+		t.Errorf("Expected %q on stdout, got %q", expected, stdout.String())
+	}
+
+	if !strings.Contains(report.String(), "Query returned no data") || !strings.HasSuffix(report.String(), expected) {
+		t.Errorf("Expected the report to have the failure, followed by the summary, got %q", report.String())
+	}
+}
+
+func TestSummarySkipped(t *testing.T) {
+	var stdout bytes.Buffer
+
+	counts := tally{skipped: 2}
+	counts.warn(reasonNoData, "a.yaml")
+
+	writeSummary(&stdout, nil, 3, counts)
+
+	expected := "Linted 3 file(s): 0 failure(s), 1 warning(s), 2 query(s) skipped as unchanged from -base-ref\n"
+	if stdout.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, stdout.String())
+	}
+}
+
+func TestIsInterrupted(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{&querylint.MetricQueryError{Kind: querylint.KindTimeout}, true},
+		{&querylint.MetricQueryError{Kind: querylint.KindCanceled}, true},
+		{&querylint.MetricQueryError{Kind: querylint.KindServer}, false},
+		{errors.New("not an API error"), false},
+		{nil, false},
+	}
+
+	for _, test := range tests {
+		if actual := isInterrupted(test.err); actual != test.expected {
+			t.Errorf("Expected %v for %v, got %v", test.expected, test.err, actual)
 		}
+	}
+}
+
+func TestDumpAST(t *testing.T) {
+	var out bytes.Buffer
+
+	err := dumpAST(&out, "foo.yaml", querylint.ParseQuery("default_zero(avg:foo{*}) + avg:bar{*}"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var dumped struct {
+		File     string
+		Analysis querylint.QueryAnalysis
+	}
+
+	if err := json.Unmarshal(out.Bytes(), &dumped); err != nil {
+		t.Fatalf("Expected valid JSON, got %v: %s", err, out.String())
+	}
+
+	if dumped.File != "foo.yaml" || len(dumped.Analysis.Metrics) != 2 {
+		t.Fatalf("Expected foo.yaml with 2 metrics, got %+v", dumped)
+	}
+
+	if masked := dumped.Analysis.Metrics[0]; masked.DefaultZeroNesting != 1 || masked.StartPos != 0 {
+		t.Errorf("Expected the first metric to keep its nesting and position, got %+v", masked)
+	}
+}
 
-		expectedErr := "line 1: cannot unmarshal !!str `Hello, ...` into main.DatadogMetricDefinition"
-		if !strings.Contains(err.Error(), expectedErr) {
-			t.Fatalf("Expected error string `%s` but got `%v`.", expectedErr, err)
+func TestPrintMetricNames(t *testing.T) {
+	var buf bytes.Buffer
+
+	printMetricNames(&buf, map[string]struct{}{"system.mem.used": {}, "aws.ec2.cpu": {}, "system.cpu.user": {}})
+
+	expected := "aws.ec2.cpu\nsystem.cpu.user\nsystem.mem.used\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestQueryAttr(t *testing.T) {
+	short := "avg:foo{*}"
+	if value := queryAttr(short).Value.String(); value != short {
+		t.Errorf("Expected a short query to be logged whole, got %q", value)
+	}
+
+	long := strings.Repeat("é", loggedQueryLength+1)
+
+	value := queryAttr(long).Value.String()
+	if value != strings.Repeat("é", loggedQueryLength)+"…" {
+		t.Errorf("Expected the query to be cut off at %d characters, got %q", loggedQueryLength, value)
+	}
+}
+
+func TestParseParallelMetrics(t *testing.T) {
+	tests := []struct {
+		value           string
+		maxConnsPerHost int
+		expected        int
+	}{
+		{"4", 0, 4},
+		{"4", 2, 4},
+		{"auto", 0, 8},
+		{"auto", 3, 3},
+		{"auto", 16, 8},
+	}
+
+	for _, test := range tests {
+		parallel, err := parseParallelMetrics(test.value, 8, test.maxConnsPerHost)
+		if err != nil {
+			t.Fatalf("Expected no error for %q, got %v", test.value, err)
 		}
-	})
+
+		if parallel != test.expected {
+			t.Errorf("Expected %d for %q with 8 CPUs and -max-conns-per-host %d, got %d",
+				test.expected, test.value, test.maxConnsPerHost, parallel)
+		}
+	}
+
+	if _, err := parseParallelMetrics("lots", 8, 0); err == nil {
+		t.Errorf("Expected an error for %q", "lots")
+	}
 }
 
-// TODO: figure out how to mock calls to datadog so we don't need to use our API keys in the tests.
-func TestMetricFetching(t *testing.T) {
-	t.SkipNow()
+func TestResolveRules(t *testing.T) {
+	warn := "warn"
+	off := "off"
+	ruleFlags := map[string]*string{
+		querylint.RuleDeprecatedMetric: &warn,
+		querylint.RuleSeriesCount:      &off,
+	}
+
+	rules, err := resolveRules(ruleFlags, querylint.RuleDeprecatedMetric+", "+querylint.RuleSeriesCount)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if rules[querylint.RuleDeprecatedMetric] != querylint.SeverityError {
+		t.Errorf("Expected -warn-as-error to promote %s to an error, got %v",
+			querylint.RuleDeprecatedMetric, rules[querylint.RuleDeprecatedMetric])
+	}
+
+	if rules[querylint.RuleSeriesCount] != querylint.SeverityOff {
+		t.Errorf("Expected %s to stay off, got %v", querylint.RuleSeriesCount, rules[querylint.RuleSeriesCount])
+	}
+
+	if _, err := resolveRules(ruleFlags, "no-such-rule"); err == nil {
+		t.Errorf("Expected an error for an unknown rule in -warn-as-error")
+	}
+
+	bad := "loud"
+	if _, err := resolveRules(map[string]*string{querylint.RuleDeprecatedMetric: &bad}, ""); err == nil {
+		t.Errorf("Expected an error for an invalid severity")
+	}
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/persona-id/datadog-query-linter/querylint"
+)
+
+// How long a scrape of the metrics endpoint can take to send its headers.
+const metricsReadHeaderTimeout = 5 * time.Second
+
+// Upper bounds of the API latency histogram buckets, in seconds.
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10} //nolint:gochecknoglobals
+
+// Metrics about the linter itself, served in the Prometheus text format by -metrics-addr. A nil *runMetrics is valid,
+// and records nothing, so callers don't need to check whether -metrics-addr was set.
+type runMetrics struct {
+	mu sync.Mutex
+
+	queries       int     // Queries sent to the API
+	failures      int     // Same as tally.failures
+	warnings      int     // Same as tally.warnings
+	masked        int     // Metrics whose missing data was hidden by a masking function
+	latencyCounts []int   // Number of API calls in each of latencyBuckets, not cumulative
+	latencyCount  int     // Total number of API calls
+	latencySum    float64 // Total time spent in API calls, in seconds
+
+	retries        func() int64 // Reads the retry count from the validator
+	skippedRetries func() int64 // Reads the count of retries skipped because -retry-budget was spent
+}
+
+func newRunMetrics(retries func() int64, skippedRetries func() int64) *runMetrics {
+	return &runMetrics{
+		latencyCounts:  make([]int, len(latencyBuckets)),
+		retries:        retries,
+		skippedRetries: skippedRetries,
+	}
+}
+
+// Serve the metrics on addr in the background, for the rest of the run.
+func (m *runMetrics) serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: metricsReadHeaderTimeout,
+	}
+
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil {
+			slog.Error("Metrics server stopped", slog.String("addr", addr), slog.Any("err", err))
+		}
+	}()
+}
+
+// Record the outcome of validating a query, including the API calls for each metric inside it.
+func (m *runMetrics) observe(result querylint.Result) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.queries++
+	m.observeLatency(result.APILatency)
+
+	for _, metric := range result.Metrics {
+		// A bare metric that makes up the whole query reuses the query's API call.
+		if !result.Analysis.MakesUpQuery(metric.Metric) {
+			m.observeLatency(metric.APILatency)
+		}
+
+		if metric.Status == querylint.StatusMasked {
+			m.masked++
+		}
+	}
+}
+
+func (m *runMetrics) observeLatency(latency time.Duration) {
+	seconds := latency.Seconds()
+
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			m.latencyCounts[i]++
+
+			break
+		}
+	}
+
+	m.latencyCount++
+	m.latencySum += seconds
+}
+
+// Record the failures and warnings found so far.
+func (m *runMetrics) setTally(counts tally) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.failures = counts.failures
+	m.warnings = counts.warnings
+}
+
+func (m *runMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	m.write(w)
+}
+
+// Write the metrics in the Prometheus text exposition format.
+func (m *runMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counters := []struct {
+		name  string
+		help  string
+		value int64
+	}{
+		{"queries_validated_total", "Queries sent to the Datadog API.", int64(m.queries)},
+		{"failures_total", "Failures found so far.", int64(m.failures)},
+		{"warnings_total", "Warnings found so far.", int64(m.warnings)},
+		{"masked_metrics_total", "Metrics with no data hidden by a masking function like default_zero().", int64(m.masked)},
+		{"retries_total", "API calls retried because of -max-retries or -retry-empty.", m.retries()},
+		{"retries_skipped_total", "Retries skipped because -retry-budget was spent.", m.skippedRetries()},
+	}
+
+	for _, counter := range counters {
+		name := "datadog_query_linter_" + counter.name
+
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, counter.help, name, name, counter.value)
+	}
+
+	name := "datadog_query_linter_api_latency_seconds"
+
+	fmt.Fprintf(w, "# HELP %s Time spent in Datadog API calls.\n# TYPE %s histogram\n", name, name)
+
+	cumulative := 0
+
+	for i, bound := range latencyBuckets {
+		cumulative += m.latencyCounts[i]
+
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'f', -1, 64), cumulative)
+	}
+
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %v\n%s_count %d\n", name, m.latencyCount, name, m.latencySum,
+		name, m.latencyCount)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/persona-id/datadog-query-linter/querylint"
+)
+
+func TestRunMetrics(t *testing.T) {
+	metrics := newRunMetrics(func() int64 { return 3 }, func() int64 { return 1 })
+
+	metrics.observe(querylint.Result{
+		Query:      "default_zero(avg:foo{*})",
+		APILatency: 200 * time.Millisecond,
+		Metrics: []querylint.MetricResult{{
+			Metric:     querylint.MetricInfo{CleanMetric: "avg:foo{*}"},
+			Status:     querylint.StatusMasked,
+			APILatency: 3 * time.Second,
+		}},
+	})
+	metrics.setTally(tally{failures: 1, warnings: 2})
+
+	var out bytes.Buffer
+
+	metrics.write(&out)
+
+	for _, expected := range []string{
+		"datadog_query_linter_queries_validated_total 1\n",
+		"datadog_query_linter_failures_total 1\n",
+		"datadog_query_linter_warnings_total 2\n",
+		"datadog_query_linter_masked_metrics_total 1\n",
+		"datadog_query_linter_retries_total 3\n",
+		"datadog_query_linter_retries_skipped_total 1\n",
+		"datadog_query_linter_api_latency_seconds_bucket{le=\"0.1\"} 0\n",
+		"datadog_query_linter_api_latency_seconds_bucket{le=\"0.25\"} 1\n",
+		"datadog_query_linter_api_latency_seconds_bucket{le=\"5\"} 2\n",
+		"datadog_query_linter_api_latency_seconds_bucket{le=\"+Inf\"} 2\n",
+		"datadog_query_linter_api_latency_seconds_count 2\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected the output to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestNilRunMetrics(t *testing.T) {
+	var metrics *runMetrics
+
+	// Without -metrics-addr, recording is a no-op rather than a panic.
+	metrics.observe(querylint.Result{})
+	metrics.setTally(tally{failures: 1})
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/persona-id/datadog-query-linter/querylint"
+	"github.com/pkg/errors"
+)
+
+// The metrics that are new enough, with -new-metric-grace, that no data is expected: a PR can add a query for a metric
+// along with the code that starts emitting it. Datadog's metric metadata doesn't say when a metric was created, so
+// when it was first seen is taken from git instead: the commit that first added its name, or now if it's only in the
+// working tree. A nil *newMetrics has no grace window, so no metric is new.
+type newMetrics struct {
+	grace time.Duration
+	now   time.Time
+	seen  map[string]time.Time // When each metric in the queries was first seen, or missing if it's unknown
+}
+
+// The git commands behind newMetrics, so they can be faked in tests.
+type gitHistory struct {
+	isShallow func() (bool, error)
+	firstSeen func(names []string) (map[string]time.Time, error)
+}
+
+// Find when every metric in the targets' queries was first seen, with a single search of the git history. In a shallow
+// clone, e.g. a CI checkout with the default depth of 1, every metric would look like it was first added in the latest
+// commit, and so new, which would hide every metric without data. The grace window is disabled there instead, as it is
+// if the history can't be searched at all.
+func newNewMetrics(grace time.Duration, targets []target, history gitHistory) *newMetrics {
+	if grace <= 0 {
+		return nil
+	}
+
+	shallow, err := history.isShallow()
+	if err != nil {
+		slog.Warn("Failed to check the git history, so -new-metric-grace is disabled", slog.Any("err", err))
+
+		return nil
+	}
+
+	if shallow {
+		slog.Warn("The git history is shallow, so when metrics were first committed can't be found, and " +
+			"-new-metric-grace is disabled. Fetch the full history, e.g. with `fetch-depth: 0` for actions/checkout")
+
+		return nil
+	}
+
+	names := map[string]struct{}{}
+
+	for _, t := range targets {
+		for _, metric := range querylint.ParseQuery(t.query).Metrics {
+			if name := querylint.MetricName(metric); name != "" {
+				names[name] = struct{}{}
+			}
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+
+	sort.Strings(sorted)
+
+	seen, err := history.firstSeen(sorted)
+	if err != nil {
+		slog.Warn("Failed to search the git history for the metrics, so -new-metric-grace is disabled",
+			slog.Any("err", err))
+
+		return nil
+	}
+
+	return &newMetrics{grace: grace, now: time.Now(), seen: seen}
+}
+
+// When the metric was first seen, and whether that's within the grace window. A metric whose first seen time isn't
+// known isn't new, so it's reported as usual.
+func (n *newMetrics) isNew(metric querylint.MetricInfo) (time.Time, bool) {
+	name := querylint.MetricName(metric)
+	if n == nil || name == "" {
+		return time.Time{}, false
+	}
+
+	firstSeen, ok := n.seen[name]
+
+	return firstSeen, ok && n.now.Sub(firstSeen) < n.grace
+}
+
+// Whether every metric in the query without data is new, so the query having no data is expected too.
+func (n *newMetrics) explainNoData(result querylint.Result) bool {
+	explained := false
+
+	for _, metric := range result.Metrics {
+		if metric.Status != querylint.StatusNoData && metric.Status != querylint.StatusMasked {
+			continue
+		}
+
+		if _, ok := n.isNew(metric.Metric); !ok {
+			return false
+		}
+
+		explained = true
+	}
+
+	return explained
+}
+
+// The git history as it really is.
+func gitRepoHistory() gitHistory {
+	return gitHistory{isShallow: gitIsShallow, firstSeen: gitFirstSeen}
+}
+
+// Whether the repo is a shallow clone, without the older history.
+func gitIsShallow() (bool, error) {
+	out, err := exec.Command("git", "rev-parse", "--is-shallow-repository").Output()
+	if err != nil {
+		return false, errors.Wrap(err, "Failed to check whether the git repo is shallow")
+	}
+
+	return strings.TrimSpace(string(out)) == "true", nil
+}
+
+// When each of the metric names was first committed, from the oldest commit that added a line with it, or now if it
+// hasn't been yet. The history is only searched once, for the commits that touch any of the names, oldest first.
+func gitFirstSeen(names []string) (map[string]time.Time, error) {
+	seen := map[string]time.Time{}
+	if len(names) == 0 {
+		return seen, nil
+	}
+
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = regexp.QuoteMeta(name)
+	}
+
+	// Each commit starts with a NUL and its time, which can't be mistaken for a line of the diff.
+	out, err := exec.Command("git", "log", "--reverse", "--format=%x00%cI", "--patch", "--unified=0", "--no-color",
+		"--no-ext-diff", "--extended-regexp", "-G("+strings.Join(quoted, "|")+")").Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to search the git history for the metrics")
+	}
+
+	seen, err = parseFirstSeen(out, names)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	for _, name := range names {
+		if _, ok := seen[name]; !ok {
+			seen[name] = now
+		}
+	}
+
+	return seen, nil
+}
+
+// Find the time of the first commit in the `git log --reverse --patch` output that added a line with each name.
+func parseFirstSeen(out []byte, names []string) (map[string]time.Time, error) {
+	seen := map[string]time.Time{}
+
+	var commitTime time.Time
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if rest, ok := strings.CutPrefix(line, "\x00"); ok {
+			parsed, err := time.Parse(time.RFC3339, rest)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("Failed to parse the commit time: %s", rest))
+			}
+
+			commitTime = parsed
+
+			continue
+		}
+
+		if !strings.HasPrefix(line, "+") || strings.HasPrefix(line, "+++ ") {
+			continue
+		}
+
+		for _, name := range names {
+			if _, ok := seen[name]; !ok && strings.Contains(line, name) {
+				seen[name] = commitTime
+			}
+		}
+	}
+
+	return seen, nil
+}
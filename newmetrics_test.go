@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/persona-id/datadog-query-linter/querylint"
+)
+
+func TestNewMetrics(t *testing.T) {
+	query := "avg:app.new{*} + default_zero(avg:app.old{*}) + avg:app.unknown{*}"
+	analysis := querylint.ParseQuery(query)
+	targets := []target{{file: "a.yaml", query: query}, {file: "b.yaml", query: "avg:app.new{env:prod}"}}
+
+	var searched [][]string
+
+	history := gitHistory{
+		isShallow: func() (bool, error) { return false, nil },
+		firstSeen: func(names []string) (map[string]time.Time, error) {
+			searched = append(searched, names)
+
+			return map[string]time.Time{
+				"app.new": time.Now().Add(-time.Hour),
+				"app.old": time.Now().Add(-30 * 24 * time.Hour),
+			}, nil
+		},
+	}
+
+	fresh := newNewMetrics(24*time.Hour, targets, history)
+
+	t.Run("the history is searched once, for every metric", func(t *testing.T) {
+		expected := []string{"app.new", "app.old", "app.unknown"}
+		if len(searched) != 1 || !slices.Equal(searched[0], expected) {
+			t.Errorf("Expected a single search for %v, got %v", expected, searched)
+		}
+	})
+
+	t.Run("only metrics first seen within the grace window are new", func(t *testing.T) {
+		for i, expected := range []bool{true, false, false} {
+			if _, ok := fresh.isNew(analysis.Metrics[i]); ok != expected {
+				t.Errorf("Expected %s to be new: %t, got %t", analysis.Metrics[i].CleanMetric, expected, ok)
+			}
+		}
+	})
+
+	t.Run("new metrics without data aren't warned about", func(t *testing.T) {
+		result := querylint.Result{
+			Query:    query,
+			Analysis: analysis,
+			Metrics: []querylint.MetricResult{
+				{Metric: analysis.Metrics[0], Status: querylint.StatusNoData},
+				{Metric: analysis.Metrics[1], Status: querylint.StatusMasked},
+				{Metric: analysis.Metrics[2], Status: querylint.StatusNoData},
+			},
+		}
+
+		counts := tally{}
+
+		reportMetrics("a.yaml", result, nil, false, false, fresh, &counts)
+
+		if counts.warnings != 2 {
+			t.Errorf("Expected 2 warnings, got %d", counts.warnings)
+		}
+
+		if fresh.explainNoData(result) {
+			t.Errorf("Expected the old metrics to still explain the query having no data")
+		}
+
+		result.Metrics = result.Metrics[:1]
+
+		if !fresh.explainNoData(result) {
+			t.Errorf("Expected the new metric to explain the query having no data")
+		}
+	})
+
+	t.Run("no grace window means no metric is new", func(t *testing.T) {
+		if _, ok := newNewMetrics(0, targets, history).isNew(analysis.Metrics[0]); ok {
+			t.Errorf("Expected no metric to be new")
+		}
+	})
+
+	t.Run("a shallow clone disables the grace window", func(t *testing.T) {
+		searched = nil
+
+		shallow := history
+		shallow.isShallow = func() (bool, error) { return true, nil }
+
+		if fresh := newNewMetrics(24*time.Hour, targets, shallow); fresh != nil {
+			t.Errorf("Expected no grace window, got %+v", fresh)
+		}
+
+		if len(searched) != 0 {
+			t.Errorf("Expected the history not to be searched, got %v", searched)
+		}
+	})
+
+	t.Run("a history that can't be searched disables the grace window", func(t *testing.T) {
+		broken := history
+		broken.isShallow = func() (bool, error) { return false, errors.New("not a git repository") }
+
+		if fresh := newNewMetrics(24*time.Hour, targets, broken); fresh != nil {
+			t.Errorf("Expected no grace window, got %+v", fresh)
+		}
+	})
+}
+
+func TestParseFirstSeen(t *testing.T) {
+	out := "\x002024-05-01T10:00:00Z\n\n" +
+		"diff --git a/a.yaml b/a.yaml\n" +
+		"+++ b/a.yaml\n" +
+		"@@ -0,0 +1 @@\n" +
+		"+  query: avg:app.old{*}\n" +
+		"\x002024-05-31T10:00:00Z\n\n" +
+		"-  query: avg:app.old{*}\n" +
+		"+  query: avg:app.old{*} + avg:app.new{*}\n"
+
+	seen, err := parseFirstSeen([]byte(out), []string{"app.new", "app.old", "app.unknown"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := map[string]time.Time{
+		"app.old": time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		"app.new": time.Date(2024, 5, 31, 10, 0, 0, 0, time.UTC),
+	}
+
+	if len(seen) != len(expected) {
+		t.Errorf("Expected %v, got %v", expected, seen)
+	}
+
+	for name, firstSeen := range expected {
+		if !seen[name].Equal(firstSeen) {
+			t.Errorf("Expected %s to be first seen at %s, got %s", name, firstSeen, seen[name])
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The -tag-override flag, a tag key and the value to use for it, which can be repeated.
+type tagOverrides map[string]string
+
+func (o *tagOverrides) String() string {
+	pairs := make([]string, 0, len(*o))
+
+	for key, value := range *o {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, value))
+	}
+
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+func (o *tagOverrides) Set(override string) error {
+	key, value, found := strings.Cut(override, "=")
+
+	key = strings.TrimSpace(key)
+	value = strings.TrimSpace(value)
+
+	if !found || key == "" || value == "" {
+		return fmt.Errorf("tag override must be key=value: %q", override)
+	}
+
+	if *o == nil {
+		*o = tagOverrides{}
+	}
+
+	(*o)[key] = value
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTagOverrides(t *testing.T) {
+	t.Run("overrides can be repeated", func(t *testing.T) {
+		var overrides tagOverrides
+
+		for _, override := range []string{"env=staging", "region = eu-west-1", "env=qa"} {
+			err := overrides.Set(override)
+			if err != nil {
+				t.Fatalf("Expected no error for %q, got %v", override, err)
+			}
+		}
+
+		expected := "env=qa,region=eu-west-1"
+		if actual := overrides.String(); actual != expected {
+			t.Errorf("Expected %q, got %q", expected, actual)
+		}
+	})
+
+	t.Run("overrides must be key=value", func(t *testing.T) {
+		for _, override := range []string{"env", "env=", "=staging", "env:staging"} {
+			var overrides tagOverrides
+
+			err := overrides.Set(override)
+			if err == nil {
+				t.Errorf("Expected an error for %q but didn't receive one.", override)
+			}
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How often the progress line is printed.
+const progressInterval = 5 * time.Second
+
+// Prints a `[123/400] linting <file>` line every so often during a long run, so there's some feedback before the end.
+// The lines are full lines, rather than a single line redrawn in place, so they don't get mangled by the logs. A nil
+// *progress is valid, and prints nothing.
+type progress struct {
+	out     io.Writer
+	total   int
+	done    atomic.Int64
+	current atomic.Value // The target being linted, as a string
+
+	stop     chan struct{}
+	finished sync.WaitGroup
+}
+
+// Start printing progress to out, which is only done when it's a terminal; in CI, the logs are enough.
+func startProgress(out *os.File, total int) *progress {
+	info, err := out.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+
+	return newProgress(out, total, progressInterval)
+}
+
+func newProgress(out io.Writer, total int, interval time.Duration) *progress {
+	p := &progress{
+		out:   out,
+		total: total,
+		stop:  make(chan struct{}),
+	}
+
+	p.current.Store("")
+	p.finished.Add(1)
+
+	go func() {
+		defer p.finished.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.render()
+			}
+		}
+	}()
+
+	return p
+}
+
+// Record that the previous target is done, and the next one has started.
+func (p *progress) next(name string) {
+	if p == nil {
+		return
+	}
+
+	if p.current.Load() != "" {
+		p.done.Add(1)
+	}
+
+	p.current.Store(name)
+}
+
+func (p *progress) render() {
+	fmt.Fprintf(p.out, "[%d/%d] linting %s\n", p.done.Load(), p.total, p.current.Load())
+}
+
+// Stop printing progress.
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+
+	close(p.stop)
+	p.finished.Wait()
+}
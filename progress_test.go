@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A bytes.Buffer that's safe to write to from the progress goroutine while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p) //nolint:wrapcheck
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestProgress(t *testing.T) {
+	var out syncBuffer
+
+	p := newProgress(&out, 3, time.Millisecond)
+	p.next("a.yaml")
+	p.next("b.yaml")
+
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(out.String(), "[1/3] linting b.yaml\n") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	p.finish()
+
+	if !strings.Contains(out.String(), "[1/3] linting b.yaml\n") {
+		t.Errorf("Expected a progress line for b.yaml, got %q", out.String())
+	}
+
+	// Without a terminal there's no progress, and recording it is a no-op.
+	var none *progress
+
+	none.next("a.yaml")
+	none.finish()
+}
@@ -0,0 +1,75 @@
+package querylint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Canonical returns a stable form of the query, for spotting near-duplicate queries and for use as a cache key. The
+// query's whitespace is normalized, and it's followed by its metrics in sorted order, each annotated with the masking
+// functions wrapping it, e.g.
+//
+//	default_zero(avg:foo{env:prod}) + avg:bar{*} | avg:bar{*}; avg:foo{env:prod} [default_zero]
+func (a QueryAnalysis) Canonical() string {
+	metrics := make([]string, 0, len(a.Metrics))
+
+	for _, metric := range a.Metrics {
+		clean := normalizeWhitespace(metric.CleanMetric)
+
+		if metric.IsMasked() {
+			clean = fmt.Sprintf("%s [%s]", clean, describeFunctions(metric.MaskingFunctions))
+		}
+
+		metrics = append(metrics, clean)
+	}
+
+	sort.Strings(metrics)
+
+	return fmt.Sprintf("%s | %s", normalizeWhitespace(a.Query), strings.Join(metrics, "; "))
+}
+
+// Collapse runs of whitespace into a single space, and drop the whitespace just inside parens and braces, and around
+// commas, so `sum( avg:foo{a:b , c:d} )` and `sum(avg:foo{a:b,c:d})` come out the same.
+func normalizeWhitespace(s string) string {
+	var b strings.Builder
+
+	fields := strings.FieldsFunc(s, unicode.IsSpace)
+
+	for i, field := range fields {
+		if i > 0 {
+			prev := fields[i-1]
+
+			if !strings.ContainsAny(prev[len(prev)-1:], "({,") && !strings.ContainsAny(field[:1], ")},") {
+				b.WriteByte(' ')
+			}
+		}
+
+		b.WriteString(field)
+	}
+
+	return b.String()
+}
+
+// Describe a chain of masking functions, collapsing repeats, e.g. `default_zero x2, clamp_min`.
+func describeFunctions(functions []string) string {
+	var parts []string
+
+	for i := 0; i < len(functions); {
+		count := 1
+		for i+count < len(functions) && functions[i+count] == functions[i] {
+			count++
+		}
+
+		if count > 1 {
+			parts = append(parts, fmt.Sprintf("%s x%d", functions[i], count))
+		} else {
+			parts = append(parts, functions[i])
+		}
+
+		i += count
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,26 @@
+package querylint
+
+import (
+	"testing"
+)
+
+func TestCanonical(t *testing.T) {
+	t.Run("whitespace and metric order don't matter", func(t *testing.T) {
+		a := ParseQuery("default_zero( avg:foo{env:prod , service:web} )  +   avg:bar{*}")
+		b := ParseQuery("default_zero(avg:foo{env:prod,service:web}) + avg:bar{*}")
+
+		if a.Canonical() != b.Canonical() {
+			t.Errorf("Expected %q and %q to be the same", a.Canonical(), b.Canonical())
+		}
+	})
+
+	t.Run("metrics are sorted and annotated", func(t *testing.T) {
+		canonical := ParseQuery("default_zero(default_zero(sum:foo{*})) / clamp_min(avg:bar{*} by {host}, 0)").Canonical()
+
+		expected := "default_zero(default_zero(sum:foo{*})) / clamp_min(avg:bar{*} by {host},0) | " +
+			"avg:bar{*} by {host} [clamp_min]; sum:foo{*} [default_zero x2]"
+		if canonical != expected {
+			t.Errorf("Expected %q, got %q", expected, canonical)
+		}
+	})
+}
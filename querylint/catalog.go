@@ -0,0 +1,135 @@
+package querylint
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Catalog is a list of every valid metric name in an org, for checking that the metrics in a query exist without
+// calling the API. Entries are either an exact metric name, or a wildcard with `*`, e.g. `aws.ec2.*`.
+type Catalog struct {
+	names     map[string]struct{}
+	wildcards []*regexp.Regexp
+}
+
+// LoadCatalog reads a catalog from a file. It can be JSON, either a list of names or an object with a `metrics` list
+// like the one the list active metrics API returns, or CSV with the names in the first column. A CSV header row of
+// `metric` or `name`, and lines starting with `#`, are skipped.
+func LoadCatalog(path string) (*Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Failed to read catalog: %s", path))
+	}
+
+	var names []string
+
+	trimmed := bytes.TrimSpace(data)
+
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		err = json.Unmarshal(trimmed, &names)
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		var list struct {
+			Metrics []string `json:"metrics"`
+		}
+
+		err = json.Unmarshal(trimmed, &list)
+		names = list.Metrics
+	default:
+		names, err = readCatalogCSV(bytes.NewReader(data))
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Failed to parse catalog: %s", path))
+	}
+
+	return NewCatalog(names), nil
+}
+
+// NewCatalog creates a Catalog from a list of metric names and wildcards.
+func NewCatalog(names []string) *Catalog {
+	catalog := &Catalog{names: map[string]struct{}{}}
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+
+		switch {
+		case name == "":
+			continue
+		case strings.Contains(name, "*"):
+			pattern := strings.ReplaceAll(regexp.QuoteMeta(name), `\*`, `.*`)
+			catalog.wildcards = append(catalog.wildcards, regexp.MustCompile("^"+pattern+"$"))
+		default:
+			catalog.names[name] = struct{}{}
+		}
+	}
+
+	return catalog
+}
+
+// Contains returns true if the metric name is in the catalog, either exactly or by matching one of its wildcards.
+func (c *Catalog) Contains(name string) bool {
+	if _, ok := c.names[name]; ok {
+		return true
+	}
+
+	for _, wildcard := range c.wildcards {
+		if wildcard.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Missing returns the metrics in the query whose names aren't in the catalog, in the order they appear.
+func (c *Catalog) Missing(analysis QueryAnalysis) []MetricInfo {
+	var missing []MetricInfo
+
+	for _, metric := range analysis.Metrics {
+		if !c.Contains(MetricName(metric)) {
+			missing = append(missing, metric)
+		}
+	}
+
+	return missing
+}
+
+// Read the metric names from the first column of a CSV catalog.
+func readCatalogCSV(r io.Reader) ([]string, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+
+	var names []string
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimSpace(record[0])
+
+		// A header row.
+		if len(names) == 0 && (strings.EqualFold(name, "metric") || strings.EqualFold(name, "name")) {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	return names, nil
+}
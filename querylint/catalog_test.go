@@ -0,0 +1,89 @@
+package querylint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCatalog(t *testing.T) {
+	t.Run("names and wildcards", func(t *testing.T) {
+		catalog := NewCatalog([]string{"system.cpu.user", "aws.ec2.*", " trace.*.hits ", ""})
+
+		tests := []struct {
+			name     string
+			expected bool
+		}{
+			{"system.cpu.user", true},
+			{"system.cpu.system", false},
+			{"aws.ec2.cpuutilization", true},
+			{"aws.ec2", false},
+			{"trace.http.request.hits", true},
+			{"trace.http.request.errors", false},
+			{"", false},
+		}
+
+		for _, test := range tests {
+			if actual := catalog.Contains(test.name); actual != test.expected {
+				t.Errorf("Expected %v for %q, got %v", test.expected, test.name, actual)
+			}
+		}
+	})
+
+	t.Run("missing metrics", func(t *testing.T) {
+		catalog := NewCatalog([]string{"foo", "bar.*"})
+
+		missing := catalog.Missing(ParseQuery("default_zero(avg:baz{*}) + avg:foo{*} + avg:bar.count{*} / avg:qux{*}"))
+		if len(missing) != 2 || missing[0].CleanMetric != "avg:baz{*}" || missing[1].CleanMetric != "avg:qux{*}" {
+			t.Errorf("Expected avg:baz{*} and avg:qux{*} to be missing, got %v", missing)
+		}
+	})
+
+	formats := []struct {
+		name string
+		data string
+	}{
+		{"json list", `["system.cpu.user", "aws.ec2.*"]`},
+		{"json object", `{"from": "1700000000", "metrics": ["system.cpu.user", "aws.ec2.*"]}`},
+		{"csv", "metric,type\n# exported from Datadog\nsystem.cpu.user,gauge\naws.ec2.*,gauge\n"},
+		{"plain text", "system.cpu.user\naws.ec2.*\n"},
+	}
+
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "catalog")
+
+			err := os.WriteFile(path, []byte(format.data), 0o600)
+			if err != nil {
+				t.Fatalf("Failed to write catalog: %v", err)
+			}
+
+			catalog, err := LoadCatalog(path)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if !catalog.Contains("system.cpu.user") || !catalog.Contains("aws.ec2.cpuutilization") {
+				t.Errorf("Expected the catalog to contain both metrics, got %+v", catalog)
+			}
+
+			if catalog.Contains("metric") || catalog.Contains("type") {
+				t.Errorf("Expected the header to be skipped, got %+v", catalog)
+			}
+		})
+	}
+
+	t.Run("invalid json", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "catalog.json")
+
+		err := os.WriteFile(path, []byte(`["unterminated`), 0o600)
+		if err != nil {
+			t.Fatalf("Failed to write catalog: %v", err)
+		}
+
+		_, err = LoadCatalog(path)
+		if err == nil {
+			t.Errorf("Expected an error but didn't receive one.")
+		}
+	})
+}
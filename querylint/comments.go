@@ -0,0 +1,52 @@
+package querylint
+
+import (
+	"strings"
+	"unicode"
+)
+
+// StripComments removes the comments from a query, for teams that annotate their queries, e.g. with a `# owner:team-x`
+// line above the query. Datadog doesn't have query comments, so the prefix they start with is up to the caller, and an
+// empty prefix leaves the query as it is. A comment runs from the prefix to the end of the line, and starts either a
+// line or after whitespace, so a tag value like `channel:#alerts` isn't one. Lines left empty are dropped, along with
+// the whitespace before a trailing comment.
+func StripComments(query string, prefix string) string {
+	if prefix == "" || !strings.Contains(query, prefix) {
+		return query
+	}
+
+	var lines []string
+
+	for _, line := range strings.Split(query, "\n") {
+		start := commentStart(line, prefix)
+		if start == -1 {
+			lines = append(lines, line)
+			continue
+		}
+
+		if code := strings.TrimRightFunc(line[:start], unicode.IsSpace); code != "" {
+			lines = append(lines, code)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// The position of the comment in the line, or -1 if it doesn't have one.
+func commentStart(line string, prefix string) int {
+	for pos := 0; pos < len(line); {
+		i := strings.Index(line[pos:], prefix)
+		if i == -1 {
+			return -1
+		}
+
+		i += pos
+		if i == 0 || unicode.IsSpace(rune(line[i-1])) {
+			return i
+		}
+
+		pos = i + len(prefix)
+	}
+
+	return -1
+}
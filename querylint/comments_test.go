@@ -0,0 +1,45 @@
+package querylint
+
+import (
+	"testing"
+)
+
+func TestStripComments(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{"a leading annotation line", "# owner:team-x\navg:foo{*}", "avg:foo{*}"},
+		{"an indented annotation line", "  # owner:team-x\n  avg:foo{*}", "  avg:foo{*}"},
+		{"a trailing comment", "avg:foo{*} # the request rate", "avg:foo{*}"},
+		{
+			"comments in a multi-line query",
+			"# errors over hits\nsum:errors{*}.as_count() # 5xx only\n/\nsum:hits{*}.as_count()",
+			"sum:errors{*}.as_count()\n/\nsum:hits{*}.as_count()",
+		},
+		{"a prefix inside a tag value", "avg:foo{channel:#alerts}", "avg:foo{channel:#alerts}"},
+		{"no comments", "avg:foo{*}\n+ avg:bar{*}", "avg:foo{*}\n+ avg:bar{*}"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := StripComments(test.query, "#"); actual != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+
+	t.Run("the prefix is configurable", func(t *testing.T) {
+		if actual := StripComments("// owner:team-x\navg:foo{*}", "//"); actual != "avg:foo{*}" {
+			t.Errorf("Expected %q, got %q", "avg:foo{*}", actual)
+		}
+	})
+
+	t.Run("no prefix leaves the query alone", func(t *testing.T) {
+		query := "# owner:team-x\navg:foo{*}"
+		if actual := StripComments(query, ""); actual != query {
+			t.Errorf("Expected %q, got %q", query, actual)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package querylint
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Explain describes, in plain English, what the linter made of a query and why it passed or failed. It's meant as a
+// teaching and debugging aid, so it spells out things the structured output leaves implicit, like which masking
+// function is hiding a metric with no data. The err is the error returned by Validate, if any.
+func Explain(result Result, err error) string {
+	var lines []string
+
+	analysis := result.Analysis
+
+	lines = append(lines, describeShape(analysis))
+
+	if len(analysis.Problems) > 0 {
+		lines = append(lines, fmt.Sprintf("It has %d syntax problem(s), so it wasn't sent to the API:", len(analysis.Problems)))
+
+		for _, problem := range analysis.Problems {
+			lines = append(lines, fmt.Sprintf("  - at position %d, %s", problem.Pos, problem.Message))
+		}
+
+		return strings.Join(lines, "\n")
+	}
+
+	switch {
+	case err != nil:
+		lines = append(lines, fmt.Sprintf("The Datadog API rejected the query: %v", err))
+
+		return strings.Join(lines, "\n")
+	case result.Value == nil && result.Interval > 0:
+		lines = append(lines, fmt.Sprintf("The query is valid, and has a series reporting every %v, but there were no "+
+			"datapoints in the last %v.", result.Interval, result.Window))
+	case result.Value == nil:
+		lines = append(lines, "The query is valid, but returned no data.")
+	default:
+		lines = append(lines, fmt.Sprintf("The query is valid, and returned %v.", *result.Value))
+	}
+
+	for i, metric := range result.Metrics {
+		// A single bare metric is the query, and has already been described.
+		if !analysis.IsComplex && !metric.Metric.IsMasked() {
+			continue
+		}
+
+		lines = append(lines, fmt.Sprintf("Metric #%d, `%s`, %s", i+1, metric.Metric.CleanMetric, describeMetric(metric)))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func describeShape(analysis QueryAnalysis) string {
+	masked := 0
+
+	for _, metric := range analysis.Metrics {
+		if metric.IsMasked() {
+			masked++
+		}
+	}
+
+	var shape string
+
+	switch {
+	case len(analysis.Metrics) == 0:
+		return "No metrics could be found in this query."
+	case analysis.IsComplex:
+		shape = fmt.Sprintf("This query is complex, and contains %d metric(s)", len(analysis.Metrics))
+	default:
+		shape = "This query is a single metric"
+	}
+
+	if masked > 0 {
+		shape += fmt.Sprintf(", %d of them wrapped in a masking function like default_zero()", masked)
+	}
+
+	return shape + "."
+}
+
+func describeMetric(metric MetricResult) string {
+	switch metric.Status {
+	case StatusOK:
+		return fmt.Sprintf("returned %v.", *metric.Value)
+	case StatusNoData:
+		return "returned no data; the metric might not exist, or it hasn't reported any datapoints recently."
+	case StatusMasked:
+		functions := describeFunctions(metric.Metric.MaskingFunctions)
+		description := fmt.Sprintf("returned no data when queried on its own, which %s is hiding in the full query. "+
+			"This is likely a typo in the metric name or tags.", functions)
+
+		if metric.OuterValue != nil {
+			description += fmt.Sprintf(" With %s, it returned %v, so that value is made up entirely by %s.",
+				functions, *metric.OuterValue, functions)
+		}
+
+		return description
+	case StatusError:
+		return fmt.Sprintf("was rejected by the Datadog API: %v", metric.Err)
+	case StatusSparse:
+		return fmt.Sprintf("has a series reporting every %v, but no datapoints in the last %v; it's real, it just "+
+			"reports less often than that.", metric.Interval, metric.Window)
+	default:
+		return "has an unknown status."
+	}
+}
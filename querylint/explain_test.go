@@ -0,0 +1,45 @@
+package querylint
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	t.Run("masked metrics are called out", func(t *testing.T) {
+		value := 0.0
+		analysis := ParseQuery("default_zero(avg:foo{*}) + avg:bar{*}")
+
+		result := Result{
+			Query:    analysis.Query,
+			Analysis: analysis,
+			Value:    &value,
+			Metrics: []MetricResult{
+				newMetricResult(analysis.Metrics[0], nil, 0, nil),
+				newMetricResult(analysis.Metrics[1], &value, 0, nil),
+			},
+		}
+
+		explanation := Explain(result, nil)
+
+		for _, expected := range []string{
+			"This query is complex, and contains 2 metric(s), 1 of them wrapped in a masking function",
+			"The query is valid, and returned 0.",
+			"Metric #1, `avg:foo{*}`, returned no data when queried on its own, which default_zero is hiding",
+			"Metric #2, `avg:bar{*}`, returned 0.",
+		} {
+			if !strings.Contains(explanation, expected) {
+				t.Errorf("Expected the explanation to contain %q, got:\n%s", expected, explanation)
+			}
+		}
+	})
+
+	t.Run("syntax problems are listed", func(t *testing.T) {
+		analysis := ParseQuery("avg:foo{env=prod}")
+		explanation := Explain(Result{Query: analysis.Query, Analysis: analysis}, nil)
+
+		if !strings.Contains(explanation, "at position 8, tag \"env=prod\" uses '='") {
+			t.Errorf("Expected the explanation to list the syntax problem, got:\n%s", explanation)
+		}
+	})
+}
@@ -0,0 +1,345 @@
+// Package querylint contains the parsing and validation logic behind the datadog-query-linter CLI, so that it can be
+// embedded in other Go programs (admission webhooks, custom CI tooling, etc) without shelling out to the binary.
+package querylint
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultQueryPath is where the query lives in a DatadogMetric custom resource.
+const DefaultQueryPath = "spec.query"
+
+// ErrBinaryFile is returned when a file doesn't look like text at all, so it can't possibly contain a query. This
+// usually means a stray binary file was picked up by a directory scan, rather than a broken manifest.
+var ErrBinaryFile = errors.New("file is not valid UTF-8 text")
+
+// DatadogMetricDefinition is the subset of a DatadogMetric custom resource that the linter cares about.
+type DatadogMetricDefinition struct {
+	Spec struct {
+		Query string `json:"query" yaml:"query"`
+	} `json:"spec"`
+}
+
+// ExtractQuery loads the yaml file, and extracts `spec.query` from the data. This is the datadog query that needs to be
+// validated, which is returned as a string.
+func ExtractQuery(filePath string) (string, error) {
+	return ExtractQueryAtPath(filePath, DefaultQueryPath)
+}
+
+// ExtractQueryAtPath is like ExtractQuery, but for manifests that keep the query somewhere other than `spec.query`. The
+// queryPath is a dotted path of map keys and list indexes, e.g. `spec.groups.0.query`.
+func ExtractQueryAtPath(filePath string, queryPath string) (string, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("Failed to read file: %s", filePath))
+	}
+
+	return ExtractQueryFromBytes(data, filePath, queryPath)
+}
+
+// ExtractQueryFromBytes extracts the query at queryPath from yaml that has already been read, e.g. from an older git
+// revision. Files ending in `.json` are parsed as JSON instead, so the errors point at the JSON that's broken. The
+// filePath is only used in error messages, and to tell the two apart. An empty string is returned if there's nothing at
+// queryPath, including for yaml that isn't a manifest at all, like a list or a plain string, the same as
+// ExtractQueriesFromBytes.
+func ExtractQueryFromBytes(data []byte, filePath string, queryPath string) (string, error) {
+	var doc interface{}
+
+	err := unmarshalManifest(data, filePath, &doc)
+	if err != nil {
+		return "", err
+	}
+
+	value := lookupPath(doc, strings.Split(queryPath, "."))
+	if value == nil {
+		return "", nil
+	}
+
+	// Decoding into the definition keeps the errors for the default path pointing at the field that's the wrong type.
+	if queryPath == DefaultQueryPath {
+		var metric DatadogMetricDefinition
+
+		err = unmarshalManifest(data, filePath, &metric)
+		if err != nil {
+			return "", err
+		}
+
+		return metric.Spec.Query, nil
+	}
+
+	query, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("value at %s is not a string: %s", queryPath, filePath)
+	}
+
+	return query, nil
+}
+
+// DocumentQuery is a query found in one of the documents of a multi-document yaml file.
+type DocumentQuery struct {
+	Document int    // The index of the document in the file, starting from 0
+	Name     string // The document's `metadata.name`, or empty if it doesn't have one
+	Field    string // The dotted path of the field the query is in, e.g. `spec.query` or `spec.queries.1`
+	Query    string
+	Line     int // The line the query is on in the file, starting from 1
+}
+
+// ExtractQueriesFromBytes is like ExtractQueryFromBytes, but looks in every document of a multi-document yaml file, like
+// the output of `helm template` or `kustomize build`, rather than only the first. The query at queryPath is returned
+// from each document that has one, so documents of other kinds are skipped, even ones that aren't a map at all, like a
+// list or a plain string. Only yaml that doesn't parse is an error. A JSON file is a single document.
+//
+// As well as the query at queryPath, each query in a `queries` list next to it is returned, e.g. `spec.queries` for
+// `spec.query`. The list can hold the queries themselves, or maps with a `query` field.
+func ExtractQueriesFromBytes(data []byte, filePath string, queryPath string) ([]DocumentQuery, error) {
+	if isJSONFile(filePath) {
+		// Parsing it as JSON first points any errors at the JSON that's broken. It's yaml too, for finding the queries.
+		_, err := ExtractQueryFromBytes(data, filePath, queryPath)
+		if err != nil {
+			return nil, err
+		}
+	} else if isBinary(data) {
+		return nil, errors.Wrap(ErrBinaryFile, fmt.Sprintf("Failed to unmarshal yaml: %s", filePath))
+	}
+
+	var queries []DocumentQuery
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+
+	for document := 0; ; document++ {
+		var doc yaml.Node
+
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Failed to unmarshal yaml: %s", filePath))
+		}
+
+		found, err := documentQueries(&doc, filePath, queryPath)
+		if err != nil {
+			return nil, err
+		}
+
+		name := ""
+		if node := lookupNode(&doc, []string{"metadata", "name"}); node != nil && node.Kind == yaml.ScalarNode {
+			name = node.Value
+		}
+
+		for _, query := range found {
+			query.Document = document
+			query.Name = name
+			queries = append(queries, query)
+		}
+	}
+
+	return queries, nil
+}
+
+// Find the query at queryPath in a single yaml document, and the queries in the `queries` list next to it.
+func documentQueries(doc *yaml.Node, filePath string, queryPath string) ([]DocumentQuery, error) {
+	var queries []DocumentQuery
+
+	path := strings.Split(queryPath, ".")
+
+	query, found, err := scalarQuery(lookupNode(doc, path), filePath, queryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if found {
+		queries = append(queries, query)
+	}
+
+	listPath := strings.Join(append(slices.Clone(path[:len(path)-1]), "queries"), ".")
+
+	list := lookupNode(doc, strings.Split(listPath, "."))
+	if list == nil || list.Kind != yaml.SequenceNode {
+		return queries, nil
+	}
+
+	for i, item := range list.Content {
+		field := fmt.Sprintf("%s.%d", listPath, i)
+
+		if item = resolveNode(item); item != nil && item.Kind == yaml.MappingNode {
+			field += ".query"
+			item = lookupNode(item, []string{"query"})
+		}
+
+		query, found, err := scalarQuery(item, filePath, field)
+		if err != nil {
+			return nil, err
+		}
+
+		if found {
+			queries = append(queries, query)
+		}
+	}
+
+	return queries, nil
+}
+
+// The query in the node at field, and false if there isn't one there. Anything but a string there is an error.
+func scalarQuery(node *yaml.Node, filePath string, field string) (DocumentQuery, bool, error) {
+	if node == nil {
+		return DocumentQuery{}, false, nil
+	}
+
+	if node.Kind != yaml.ScalarNode {
+		return DocumentQuery{}, false, fmt.Errorf("value at %s is not a string: %s", field, filePath)
+	}
+
+	if node.Value == "" || node.Tag == "!!null" {
+		return DocumentQuery{}, false, nil
+	}
+
+	return DocumentQuery{Field: field, Query: node.Value, Line: node.Line}, true, nil
+}
+
+// DefaultFormulaPath is where the formula definition, with its `formula` and `queries`, lives in a manifest.
+const DefaultFormulaPath = "spec"
+
+// ExtractFormulaFromBytes extracts the formula definition at path from yaml, e.g. `spec`, which has the `formula`, and
+// the named `queries` it's built from. The zero value is returned if there's nothing at path.
+func ExtractFormulaFromBytes(data []byte, filePath string, path string) (FormulaDefinition, error) {
+	var definition FormulaDefinition
+
+	var doc interface{}
+
+	err := unmarshalManifest(data, filePath, &doc)
+	if err != nil {
+		return definition, err
+	}
+
+	node := lookupPath(doc, strings.Split(path, "."))
+	if node == nil {
+		return definition, nil
+	}
+
+	// Round trip the node, so the definition is decoded with the usual yaml rules.
+	encoded, err := yaml.Marshal(node)
+	if err != nil {
+		return definition, errors.Wrap(err, fmt.Sprintf("Failed to marshal formula definition: %s", filePath))
+	}
+
+	err = yaml.Unmarshal(encoded, &definition)
+	if err != nil {
+		return definition, errors.Wrap(err, fmt.Sprintf("Failed to unmarshal formula definition at %s: %s", path, filePath))
+	}
+
+	return definition, nil
+}
+
+// Unmarshal a manifest into out, as JSON if the file ends in `.json`, or yaml otherwise. Yaml is a superset of JSON, so
+// the yaml parser would mostly cope with JSON too, but its errors talk about yaml tags and line numbers of a document
+// that was never yaml.
+func unmarshalManifest(data []byte, filePath string, out interface{}) error {
+	format := "yaml"
+	if isJSONFile(filePath) {
+		format = "json"
+	}
+
+	if isBinary(data) {
+		return errors.Wrap(ErrBinaryFile, fmt.Sprintf("Failed to unmarshal %s: %s", format, filePath))
+	}
+
+	var err error
+
+	switch {
+	case format == "json" && len(bytes.TrimSpace(data)) == 0:
+		// Like an empty yaml file, there's just no query in it.
+		return nil
+	case format == "json":
+		err = unmarshalJSON(data, out)
+	default:
+		err = yaml.Unmarshal(data, out)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Failed to unmarshal %s: %s", format, filePath))
+	}
+
+	return nil
+}
+
+func isJSONFile(filePath string) bool {
+	return strings.EqualFold(filepath.Ext(filePath), ".json")
+}
+
+// Unmarshal JSON, with the line and column of a syntax or type error rather than just its byte offset.
+func unmarshalJSON(data []byte, out interface{}) error {
+	err := json.Unmarshal(data, out)
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line, column := lineAndColumn(data, syntaxErr.Offset)
+
+		return fmt.Errorf("line %d, column %d: %w", line, column, err)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		line, column := lineAndColumn(data, typeErr.Offset)
+
+		return fmt.Errorf("line %d, column %d: %s at %s should be a %s", line, column, typeErr.Value, typeErr.Field,
+			typeErr.Type)
+	}
+
+	return err //nolint:wrapcheck
+}
+
+// The 1-based line and column of the last byte the JSON decoder read before the error, which is the offending one.
+func lineAndColumn(data []byte, offset int64) (int, int) {
+	if len(data) == 0 {
+		return 1, 1
+	}
+
+	before := data[:min(max(offset, 1), int64(len(data)))]
+	line := bytes.Count(before[:len(before)-1], []byte("\n")) + 1
+	column := len(before) - 1 - bytes.LastIndexByte(before[:len(before)-1], '\n')
+
+	return line, column
+}
+
+// Binary files (images, compiled artifacts, etc) are full of NUL bytes and invalid UTF-8, neither of which can appear in
+// a yaml document.
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data, 0) != -1 || !utf8.Valid(data)
+}
+
+// Walk the unmarshaled yaml or JSON, following map keys and list indexes. Returns nil if any part of the path doesn't exist.
+func lookupPath(node interface{}, path []string) interface{} {
+	for _, segment := range path {
+		switch typed := node.(type) {
+		case map[interface{}]interface{}:
+			node = typed[segment]
+		case map[string]interface{}:
+			node = typed[segment]
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(typed) {
+				return nil
+			}
+
+			node = typed[index]
+		default:
+			return nil
+		}
+	}
+
+	return node
+}
@@ -0,0 +1,246 @@
+package querylint
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFileLoading(t *testing.T) {
+	t.Run("validate that files load", func(t *testing.T) {
+		query, err := ExtractQuery("../tests/datadogmetric-working.yaml")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		expectedQuery := "default_zero(avg:rails.temporal.workflow_task.queue_time.avg{app:persona-web-temporal-worker-retention,env:production,region:us-central1,task_queue:retention}.fill(null))"
+		if query != expectedQuery {
+			t.Errorf("Expected query %q, got %q", expectedQuery, query)
+		}
+	})
+
+	t.Run("error if the files don't exist", func(t *testing.T) {
+		_, err := ExtractQuery("../tests/datadogmetric-no-file.yaml")
+		if err == nil {
+			t.Fatalf("Expected an error but didn't receive one.")
+		}
+
+		expectedErr := "Failed to read file: ../tests/datadogmetric-no-file.yaml: open ../tests/datadogmetric-no-file.yaml: no such file or directory"
+		if err.Error() != expectedErr {
+			t.Fatalf("Expected error string `%s` but got `%v`.", expectedErr, err)
+		}
+	})
+
+	t.Run("no query if the yaml isn't a manifest", func(t *testing.T) {
+		query, err := ExtractQuery("../tests/invalid-yaml.yaml")
+		if err != nil || query != "" {
+			t.Fatalf("Expected no query and no error, got %q and %v", query, err)
+		}
+
+		for _, other := range []string{"- a\n- b\n", "spec: hello\n", "spec:\n  - a\n"} {
+			query, err := ExtractQueryFromBytes([]byte(other), "other.yaml", DefaultQueryPath)
+			if err != nil || query != "" {
+				t.Errorf("Expected no query and no error for %q, got %q and %v", other, query, err)
+			}
+		}
+	})
+
+	t.Run("error if the yaml is invalid", func(t *testing.T) {
+		_, err := ExtractQueryFromBytes([]byte("spec:\n  query: [a, b]\n"), "test.yaml", DefaultQueryPath)
+		if err == nil {
+			t.Fatalf("Exected an error unmarshaling yaml, but didn't receive one")
+		}
+
+		expectedErr := "line 2: cannot unmarshal !!seq into string"
+		if !strings.Contains(err.Error(), expectedErr) {
+			t.Fatalf("Expected error string `%s` but got `%v`.", expectedErr, err)
+		}
+
+		if _, err := ExtractQueryFromBytes([]byte("spec: [\n"), "test.yaml", DefaultQueryPath); err == nil {
+			t.Errorf("Expected an error for yaml that doesn't parse, but didn't receive one")
+		}
+	})
+}
+
+func TestQueryPath(t *testing.T) {
+	data := []byte(`
+spec:
+  groups:
+    - name: first
+      query: avg:foo{*}
+    - name: second
+      query: sum:bar{*}
+  threshold: 5
+`)
+
+	t.Run("follows keys and list indexes", func(t *testing.T) {
+		query, err := ExtractQueryFromBytes(data, "test.yaml", "spec.groups.1.query")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if query != "sum:bar{*}" {
+			t.Errorf("Expected query %q, got %q", "sum:bar{*}", query)
+		}
+	})
+
+	t.Run("missing paths have no query", func(t *testing.T) {
+		for _, path := range []string{"spec.query", "spec.groups.2.query", "spec.groups.name", "metadata.name"} {
+			query, err := ExtractQueryFromBytes(data, "test.yaml", path)
+			if err != nil {
+				t.Fatalf("Expected no error for %s, got %v", path, err)
+			}
+
+			if query != "" {
+				t.Errorf("Expected no query for %s, got %q", path, query)
+			}
+		}
+	})
+
+	t.Run("non-string values are an error", func(t *testing.T) {
+		_, err := ExtractQueryFromBytes(data, "test.yaml", "spec.groups")
+		if err == nil {
+			t.Fatalf("Expected an error but didn't receive one.")
+		}
+	})
+}
+
+func TestBinaryFiles(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"nul bytes":     {'a', 0, 'b'},
+		"invalid utf-8": {0xff, 0xfe, 0xfd},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ExtractQueryFromBytes(data, "test.bin", DefaultQueryPath)
+			if !errors.Is(err, ErrBinaryFile) {
+				t.Fatalf("Expected ErrBinaryFile, got %v", err)
+			}
+		})
+	}
+}
+
+func TestJSONFiles(t *testing.T) {
+	t.Run("json files load", func(t *testing.T) {
+		query, err := ExtractQuery("../tests/datadogmetric-working.json")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		expectedQuery := "default_zero(avg:rails.temporal.workflow_task.queue_time.avg{app:persona-web-temporal-worker-retention,env:production,region:us-central1,task_queue:retention}.fill(null))"
+		if query != expectedQuery {
+			t.Errorf("Expected query %q, got %q", expectedQuery, query)
+		}
+	})
+
+	t.Run("follows keys and list indexes", func(t *testing.T) {
+		data := []byte(`{"spec": {"groups": [{"query": "avg:foo{*}"}, {"query": "sum:bar{*}"}]}}`)
+
+		query, err := ExtractQueryFromBytes(data, "test.json", "spec.groups.1.query")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if query != "sum:bar{*}" {
+			t.Errorf("Expected query %q, got %q", "sum:bar{*}", query)
+		}
+	})
+
+	t.Run("errors point at the broken json", func(t *testing.T) {
+		for data, expectedErr := range map[string]string{
+			"{\n  \"spec\": {\n    \"query\": \"avg:foo{*}\",\n  }\n}": "Failed to unmarshal json: test.json: line 4, column 3: invalid character '}'",
+			`{"spec": {"query": 5}}`:                                   "Failed to unmarshal json: test.json: line 1, column 20: number at spec.query should be a string",
+		} {
+			_, err := ExtractQueryFromBytes([]byte(data), "test.json", DefaultQueryPath)
+			if err == nil || !strings.HasPrefix(err.Error(), expectedErr) {
+				t.Errorf("Expected error starting with `%s` but got `%v`.", expectedErr, err)
+			}
+		}
+	})
+
+	t.Run("an empty json file has no query", func(t *testing.T) {
+		query, err := ExtractQueryFromBytes([]byte("\n"), "test.json", DefaultQueryPath)
+		if err != nil || query != "" {
+			t.Errorf("Expected no query and no error, got %q and %v", query, err)
+		}
+	})
+}
+
+func TestMultipleDocuments(t *testing.T) {
+	data := []byte(`---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+---
+apiVersion: datadoghq.com/v1alpha1
+kind: DatadogMetric
+metadata:
+  name: web-latency
+spec:
+  query: avg:web.latency{*}
+---
+apiVersion: datadoghq.com/v1alpha1
+kind: DatadogMetric
+spec:
+  query: avg:web.errors{*}
+`)
+
+	t.Run("every document with a query is extracted", func(t *testing.T) {
+		queries, err := ExtractQueriesFromBytes(data, "rendered.yaml", DefaultQueryPath)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		expected := []DocumentQuery{
+			{Document: 1, Name: "web-latency", Field: "spec.query", Query: "avg:web.latency{*}", Line: 12},
+			{Document: 2, Field: "spec.query", Query: "avg:web.errors{*}", Line: 17},
+		}
+
+		if len(queries) != len(expected) {
+			t.Fatalf("Expected %d queries, got %+v", len(expected), queries)
+		}
+
+		for i, query := range queries {
+			if query != expected[i] {
+				t.Errorf("Expected %+v, got %+v", expected[i], query)
+			}
+		}
+	})
+
+	t.Run("a broken document is an error", func(t *testing.T) {
+		broken := append(append([]byte{}, data...), []byte("---\nspec: [unclosed\n")...)
+
+		if _, err := ExtractQueriesFromBytes(broken, "rendered.yaml", DefaultQueryPath); err == nil {
+			t.Errorf("Expected an error but didn't receive one.")
+		}
+	})
+
+	t.Run("queries lists are extracted too", func(t *testing.T) {
+		data := []byte("spec:\n  queries:\n    - avg:foo{*}\n    - query: avg:bar{*}\n")
+
+		queries, err := ExtractQueriesFromBytes(data, "metric.yaml", DefaultQueryPath)
+		if err != nil || len(queries) != 2 || queries[0].Field != "spec.queries.0" || queries[1].Query != "avg:bar{*}" {
+			t.Errorf("Expected both queries in the list, and no error, got %+v and %v", queries, err)
+		}
+
+		if _, err := ExtractQueriesFromBytes([]byte("spec:\n  queries:\n    - [avg:foo{*}]\n"), "metric.yaml", DefaultQueryPath); err == nil {
+			t.Errorf("Expected an error for a query that isn't a string, but didn't receive one.")
+		}
+	})
+
+	t.Run("documents that aren't manifests are skipped", func(t *testing.T) {
+		for _, other := range []string{"Hello, world.", "- a\n- b\n", "spec: hello\n", "spec:\n  - a\n"} {
+			queries, err := ExtractQueriesFromBytes([]byte(other), "other.yaml", DefaultQueryPath)
+			if err != nil || len(queries) != 0 {
+				t.Errorf("Expected no queries and no error for %q, got %+v and %v", other, queries, err)
+			}
+		}
+	})
+
+	t.Run("a json file is a single document", func(t *testing.T) {
+		queries, err := ExtractQueriesFromBytes([]byte(`{"spec": {"query": "avg:foo{*}"}}`), "metric.json", DefaultQueryPath)
+		if err != nil || len(queries) != 1 || queries[0].Query != "avg:foo{*}" {
+			t.Errorf("Expected a single query and no error, got %+v and %v", queries, err)
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package querylint
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// formulaIdentifierPattern matches a name in a formula, e.g. the `a` and `b` in `a / b * 100`, or the `abs` in `abs(a)`.
+//
+//nolint:gochecknoglobals
+var formulaIdentifierPattern = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// FormulaQuery is one of the named queries a formula is built from.
+type FormulaQuery struct {
+	Name  string `yaml:"name"`  // The name the formula refers to the query by, e.g. `a`
+	Query string `yaml:"query"` // The metric query
+}
+
+// FormulaDefinition is a set of named queries, combined with a formula, e.g. `a / b * 100`, the same as a dashboard
+// widget or a formula and functions monitor.
+type FormulaDefinition struct {
+	Formula string         `yaml:"formula"`
+	Queries []FormulaQuery `yaml:"queries"`
+}
+
+// CheckFormula checks that the formula only refers to queries that are defined, and that every query it defines is
+// used. The API accepts (or rejects) both of these opaquely, e.g. `a / c` when only `a` and `b` are defined. Names
+// followed by a `(` are functions, like `abs(a)`, rather than queries. The problems for unused queries are at the end
+// of the formula, since there's nowhere in it to point at.
+func CheckFormula(formula string, names []string) []ParseError {
+	var problems []ParseError
+
+	used := map[string]bool{}
+
+	for _, loc := range formulaIdentifierPattern.FindAllStringIndex(formula, -1) {
+		name := formula[loc[0]:loc[1]]
+
+		// Skip function calls, and the exponent of a number like 1e3.
+		if isFunctionCall(formula, loc[1]) || (loc[0] > 0 && isDigit(formula[loc[0]-1])) {
+			continue
+		}
+
+		used[name] = true
+
+		if !slices.Contains(names, name) {
+			problems = append(problems, ParseError{
+				Pos:     loc[0],
+				Message: fmt.Sprintf("formula refers to %q, which isn't one of the defined queries", name),
+			})
+		}
+	}
+
+	for _, name := range names {
+		if !used[name] {
+			problems = append(problems, ParseError{
+				Pos:     len(formula),
+				Message: fmt.Sprintf("query %q is defined, but the formula doesn't use it", name),
+			})
+		}
+	}
+
+	return problems
+}
+
+// Whether the name ending at end is followed by an opening paren, ignoring whitespace.
+func isFunctionCall(formula string, end int) bool {
+	for i := end; i < len(formula); i++ {
+		switch formula[i] {
+		case ' ', '\t':
+			continue
+		case '(':
+			return true
+		default:
+			return false
+		}
+	}
+
+	return false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
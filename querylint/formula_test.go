@@ -0,0 +1,36 @@
+package querylint
+
+import (
+	"testing"
+)
+
+func TestCheckFormula(t *testing.T) {
+	t.Run("valid formulas have no problems", func(t *testing.T) {
+		for _, formula := range []string{"a / b * 100", "abs(a - b)", "(a + b) / 1e3", "clamp_min(query1, 0) + query2"} {
+			names := []string{"a", "b"}
+			if formula == "clamp_min(query1, 0) + query2" {
+				names = []string{"query1", "query2"}
+			}
+
+			if problems := CheckFormula(formula, names); len(problems) != 0 {
+				t.Errorf("Expected no problems for %q, got %v", formula, problems)
+			}
+		}
+	})
+
+	t.Run("undefined queries are flagged where they're used", func(t *testing.T) {
+		problems := CheckFormula("a / c", []string{"a", "b"})
+
+		if len(problems) != 2 {
+			t.Fatalf("Expected 2 problems, got %v", problems)
+		}
+
+		if problems[0].Pos != 4 || problems[0].Message != `formula refers to "c", which isn't one of the defined queries` {
+			t.Errorf("Expected c to be flagged at position 4, got %v", problems[0])
+		}
+
+		if problems[1].Pos != 5 || problems[1].Message != `query "b" is defined, but the formula doesn't use it` {
+			t.Errorf("Expected b to be flagged as unused, got %v", problems[1])
+		}
+	})
+}
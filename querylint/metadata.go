@@ -0,0 +1,135 @@
+package querylint
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
+)
+
+// DefaultDeprecatedPattern is matched against a metric's metadata, its description and short name, to tell if it's
+// deprecated, unless Validator.DeprecatedPattern is set.
+const DefaultDeprecatedPattern = `(?i)\bdeprecated\b`
+
+//nolint:gochecknoglobals
+var defaultDeprecatedPattern = regexp.MustCompile(DefaultDeprecatedPattern)
+
+// The type in the metadata of a metric that can be queried at a percentile.
+const distributionType = "distribution"
+
+// percentilePattern matches a percentile aggregator, e.g. `p95` or `p99.9`.
+//
+//nolint:gochecknoglobals
+var percentilePattern = regexp.MustCompile(`^p\d+(?:\.\d+)?$`)
+
+// metricNamePrefixPattern matches the bare name of a metric, e.g. `system.cpu.user` in
+// `avg:system.cpu.user{*}.as_count()`, stopping at its tag filter or first function call.
+//
+//nolint:gochecknoglobals
+var metricNamePrefixPattern = regexp.MustCompile(`^(?:[\w.]+:)?([a-zA-Z0-9_.]+?)(?:\{|\.\w+\(|\s|$)`)
+
+// MetricName returns the bare name of the metric, without its aggregator, tag filter or functions, e.g.
+// `system.cpu.user` for `avg:system.cpu.user{env:prod}.as_count()`. This is what Datadog keys the metric's metadata on.
+func MetricName(metric MetricInfo) string {
+	match := metricNamePrefixPattern.FindStringSubmatch(metric.CleanMetric)
+	if match == nil {
+		return ""
+	}
+
+	return match[1]
+}
+
+// Look up the metadata of each metric the API didn't reject, for CheckDeprecated, and record why any that are deprecated
+// are.
+func (v *Validator) checkDeprecated(ctx context.Context, results []MetricResult) {
+	pattern := v.DeprecatedPattern
+	if pattern == nil {
+		pattern = defaultDeprecatedPattern
+	}
+
+	for i, result := range results {
+		if result.Status == StatusError {
+			continue
+		}
+
+		name := MetricName(result.Metric)
+		if name == "" {
+			continue
+		}
+
+		results[i].Deprecated = v.deprecation(ctx, name, pattern)
+	}
+}
+
+// Why the metric is deprecated, i.e. the part of its metadata that matches the pattern, or an empty string if it isn't.
+// A metric without metadata, or whose metadata can't be fetched, isn't deprecated as far as this is concerned; whether
+// it exists at all is checked by querying it.
+func (v *Validator) deprecation(ctx context.Context, name string, pattern *regexp.Regexp) string {
+	metadata, ok := v.metricMetadata(ctx, name)
+	if !ok {
+		return ""
+	}
+
+	for _, field := range []*string{metadata.Description, metadata.ShortName} {
+		if field != nil && pattern.MatchString(*field) {
+			return *field
+		}
+	}
+
+	return ""
+}
+
+// The metric's metadata, or false if it can't be fetched, e.g. because the metric doesn't have any. Each metric name is
+// only looked up once for the life of the Validator, since the metadata rarely changes.
+func (v *Validator) metricMetadata(ctx context.Context, name string) (datadogV1.MetricMetadata, bool) {
+	if cached, ok := v.metadata.Load(name); ok {
+		metadata, _ := cached.(datadogV1.MetricMetadata)
+
+		return metadata, true
+	}
+
+	metadata, _, err := v.api.GetMetricMetadata(ctx, name)
+	if err != nil {
+		return datadogV1.MetricMetadata{}, false
+	}
+
+	v.metadata.Store(name, metadata)
+
+	return metadata, true
+}
+
+// LintPercentiles runs the percentile-distribution rule, which needs each metric's metadata, so unlike Lint it calls the
+// API. A metric can only be queried at a percentile, like `p95:`, if it's a distribution; the API rejects any other
+// metric with an error that doesn't say why. Only the metrics queried at a percentile are looked up, and one whose
+// metadata can't be fetched, or doesn't have a type, is left to the API.
+func (v *Validator) LintPercentiles(ctx context.Context, analysis QueryAnalysis, rules Rules) []Finding {
+	severity := rules[RulePercentileDistribution]
+	if severity == SeverityOff {
+		return nil
+	}
+
+	var findings []Finding
+
+	for _, metric := range analysis.Metrics {
+		aggregator := metricAggregator(metric)
+		if !percentilePattern.MatchString(aggregator) {
+			continue
+		}
+
+		metadata, ok := v.metricMetadata(ctx, MetricName(metric))
+		if !ok || metadata.GetType() == "" || metadata.GetType() == distributionType {
+			continue
+		}
+
+		findings = append(findings, Finding{
+			Rule:     RulePercentileDistribution,
+			Severity: severity,
+			Metric:   metric,
+			Message: fmt.Sprintf("Metric %s is queried at a percentile, %s, but it's a %s, not a distribution; only "+
+				"distributions have percentiles", MetricName(metric), aggregator, metadata.GetType()),
+		})
+	}
+
+	return findings
+}
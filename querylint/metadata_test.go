@@ -0,0 +1,175 @@
+package querylint
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMetricName(t *testing.T) {
+	tests := []struct {
+		metric   string
+		expected string
+	}{
+		{"avg:system.cpu.user{*}", "system.cpu.user"},
+		{"sum:requests.count{env:prod} by {service}.as_count()", "requests.count"},
+		{"avg:system.load.1{*}.rollup(avg, 60)", "system.load.1"},
+		{"avg:foo.bar.as_count()", "foo.bar"},
+		{"foo.bar", "foo.bar"},
+		{"p99.9:trace.http.request{service:web}", "trace.http.request"},
+		{"sum:trace.http.request.hits.by_http_status{*}.as_count()", "trace.http.request.hits.by_http_status"},
+	}
+
+	for _, test := range tests {
+		if actual := MetricName(MetricInfo{CleanMetric: test.metric}); actual != test.expected {
+			t.Errorf("Expected %q for %q, got %q", test.expected, test.metric, actual)
+		}
+	}
+}
+
+func TestCheckDeprecated(t *testing.T) {
+	// Serve the metadata for each metric by name, and data for every query.
+	newMetadataValidator := func(t *testing.T, lookups *atomic.Int32) *Validator {
+		t.Helper()
+
+		return newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+			name, found := strings.CutPrefix(r.URL.Path, "/api/v1/metrics/")
+			if !found {
+				seriesResponse(w, 1)
+
+				return
+			}
+
+			lookups.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+
+			switch name {
+			case "old.metric":
+				fmt.Fprint(w, `{"description":"DEPRECATED: use new.metric instead","type":"gauge"}`)
+			case "legacy.metric":
+				fmt.Fprint(w, `{"description":"Requests served","short_name":"legacy requests"}`)
+			case "new.metric":
+				fmt.Fprint(w, `{"description":"Requests served","type":"gauge"}`)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"errors":["Not Found"]}`)
+			}
+		})
+	}
+
+	t.Run("deprecated metrics are recorded", func(t *testing.T) {
+		var lookups atomic.Int32
+
+		validator := newMetadataValidator(t, &lookups)
+		validator.CheckDeprecated = true
+
+		result, err := validator.Validate(context.Background(),
+			"avg:old.metric{*} + avg:new.metric{*} + avg:missing.metric{*} + avg:old.metric{env:prod}")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		expected := []string{"DEPRECATED: use new.metric instead", "", "", "DEPRECATED: use new.metric instead"}
+		for i, metric := range result.Metrics {
+			if metric.Deprecated != expected[i] {
+				t.Errorf("Expected %q for %s, got %q", expected[i], metric.Metric.CleanMetric, metric.Deprecated)
+			}
+		}
+
+		if lookups.Load() != 3 {
+			t.Errorf("Expected each metric name to be looked up once, got %d lookups", lookups.Load())
+		}
+	})
+
+	t.Run("the pattern can be changed", func(t *testing.T) {
+		var lookups atomic.Int32
+
+		validator := newMetadataValidator(t, &lookups)
+		validator.CheckDeprecated = true
+		validator.DeprecatedPattern = regexp.MustCompile(`^legacy `)
+
+		result, err := validator.Validate(context.Background(), "avg:legacy.metric{*} + avg:old.metric{*}")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if result.Metrics[0].Deprecated != "legacy requests" || result.Metrics[1].Deprecated != "" {
+			t.Errorf("Expected only legacy.metric to be deprecated, got %q and %q",
+				result.Metrics[0].Deprecated, result.Metrics[1].Deprecated)
+		}
+	})
+
+	t.Run("metadata isn't looked up unless it's enabled", func(t *testing.T) {
+		var lookups atomic.Int32
+
+		validator := newMetadataValidator(t, &lookups)
+
+		_, err := validator.Validate(context.Background(), "avg:old.metric{*}")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if lookups.Load() != 0 {
+			t.Errorf("Expected no metadata lookups, got %d", lookups.Load())
+		}
+	})
+}
+
+func TestLintPercentiles(t *testing.T) {
+	var lookups atomic.Int32
+
+	validator := newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+		lookups.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+
+		switch strings.TrimPrefix(r.URL.Path, "/api/v1/metrics/") {
+		case "trace.http.request":
+			fmt.Fprint(w, `{"type":"distribution"}`)
+		case "http.latency":
+			fmt.Fprint(w, `{"type":"gauge"}`)
+		case "untyped.metric":
+			fmt.Fprint(w, `{"description":"No type"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors":["Not Found"]}`)
+		}
+	})
+
+	rules := Rules{RulePercentileDistribution: SeverityWarn}
+
+	t.Run("percentiles of metrics that aren't distributions are flagged", func(t *testing.T) {
+		analysis := ParseQuery("p95:trace.http.request{*} / p99.9:http.latency{*} + p50:untyped.metric{*} + " +
+			"p90:missing.metric{*} + avg:http.latency{*}")
+
+		findings := validator.LintPercentiles(context.Background(), analysis, rules)
+		if len(findings) != 1 {
+			t.Fatalf("Expected 1 finding, got %v", findings)
+		}
+
+		expected := "Metric http.latency is queried at a percentile, p99.9, but it's a gauge, not a distribution; only " +
+			"distributions have percentiles"
+		if findings[0].Message != expected || findings[0].Metric.CleanMetric != "p99.9:http.latency{*}" {
+			t.Errorf("Expected %q for p99.9:http.latency{*}, got %+v", expected, findings[0])
+		}
+
+		if lookups.Load() != 4 {
+			t.Errorf("Expected only the percentile metrics to be looked up, got %d lookups", lookups.Load())
+		}
+	})
+
+	t.Run("metadata isn't looked up with the rule off", func(t *testing.T) {
+		lookups.Store(0)
+
+		if findings := validator.LintPercentiles(context.Background(), ParseQuery("p95:other.metric{*}"), Rules{}); len(findings) != 0 {
+			t.Errorf("Expected no findings with the rule off, got %v", findings)
+		}
+
+		if lookups.Load() != 0 {
+			t.Errorf("Expected no metadata lookups, got %d", lookups.Load())
+		}
+	})
+}
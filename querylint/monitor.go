@@ -0,0 +1,79 @@
+package querylint
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MonitorQuery is the outer syntax of a metric monitor's query, e.g. `avg(last_5m):avg:foo{*} by {host} > 90`: how the
+// monitor evaluates the metric query inside it, and what it compares the result with.
+type MonitorQuery struct {
+	Aggregator string        // How the values in the window are combined, e.g. `avg`, or `change` for a change monitor
+	Window     string        // The evaluation window as written, e.g. `last_5m`
+	Duration   time.Duration // The evaluation window, e.g. 5m for `last_5m`, or 0 if it couldn't be worked out
+	Query      string        // The metric query being evaluated, e.g. `avg:foo{*} by {host}`
+	Comparator string        // How the result is compared with the threshold, e.g. `>`, or empty if there isn't one
+	Threshold  string        // The threshold as written, e.g. `90`, or empty if there isn't one
+}
+
+// monitorPrefixPattern matches the evaluation prefix of a monitor query, e.g. `avg(last_5m):`, or
+// `pct_change(avg(last_5m),last_5m):` for a change monitor.
+//
+//nolint:gochecknoglobals
+var monitorPrefixPattern = regexp.MustCompile(`^\s*([a-z_]+)\(([^:]*)\)\s*:`)
+
+// monitorWindowPattern matches an evaluation window in a monitor query's prefix, e.g. `last_5m` or `last_1w`.
+//
+//nolint:gochecknoglobals
+var monitorWindowPattern = regexp.MustCompile(`\blast_(\d+)([mhdw])\b`)
+
+// monitorThresholdPattern matches the comparison at the end of a monitor query, e.g. `> 90` or `<= -0.5`.
+//
+//nolint:gochecknoglobals
+var monitorThresholdPattern = regexp.MustCompile(`\s*(>=|<=|==|!=|>|<)\s*(-?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?)\s*$`)
+
+// How long each unit of a monitor's evaluation window is.
+//
+//nolint:gochecknoglobals
+var monitorWindowUnits = map[string]time.Duration{
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// ParseMonitorQuery splits a metric monitor's query into its evaluation prefix, the metric query it evaluates, and its
+// threshold. It returns false if the query doesn't start with an evaluation prefix like `avg(last_5m):`, i.e. it's a
+// plain metric query.
+func ParseMonitorQuery(query string) (MonitorQuery, bool) {
+	loc := monitorPrefixPattern.FindStringSubmatchIndex(query)
+	if loc == nil {
+		return MonitorQuery{}, false
+	}
+
+	monitor := MonitorQuery{
+		Aggregator: query[loc[2]:loc[3]],
+		Query:      query[loc[1]:],
+	}
+
+	if window := monitorWindowPattern.FindStringSubmatch(query[loc[4]:loc[5]]); window != nil {
+		monitor.Window = window[0]
+
+		count, err := strconv.Atoi(window[1])
+		if err == nil {
+			monitor.Duration = time.Duration(count) * monitorWindowUnits[window[2]]
+		}
+	}
+
+	if threshold := monitorThresholdPattern.FindStringSubmatchIndex(monitor.Query); threshold != nil {
+		monitor.Comparator = monitor.Query[threshold[2]:threshold[3]]
+		monitor.Threshold = monitor.Query[threshold[4]:threshold[5]]
+		monitor.Query = monitor.Query[:threshold[0]]
+	}
+
+	monitor.Query = strings.TrimSpace(monitor.Query)
+
+	return monitor, true
+}
@@ -0,0 +1,87 @@
+package querylint
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseMonitorQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected MonitorQuery
+	}{
+		{
+			"avg(last_5m):avg:system.cpu.user{env:prod} by {host} > 90",
+			MonitorQuery{"avg", "last_5m", 5 * time.Minute, "avg:system.cpu.user{env:prod} by {host}", ">", "90"},
+		},
+		{
+			"sum(last_1h):sum:errors{*}.as_count() / sum:requests{*}.as_count() >= 0.05",
+			MonitorQuery{"sum", "last_1h", time.Hour, "sum:errors{*}.as_count() / sum:requests{*}.as_count()", ">=", "0.05"},
+		},
+		{
+			"pct_change(avg(last_1d),last_1w):avg:queue.depth{*} < -50",
+			MonitorQuery{"pct_change", "last_1d", 24 * time.Hour, "avg:queue.depth{*}", "<", "-50"},
+		},
+		{
+			"min(last_15m): default_zero(avg:foo{*})",
+			MonitorQuery{"min", "last_15m", 15 * time.Minute, "default_zero(avg:foo{*})", "", ""},
+		},
+	}
+
+	for _, test := range tests {
+		monitor, ok := ParseMonitorQuery(test.query)
+		if !ok {
+			t.Errorf("Expected %q to be a monitor query", test.query)
+
+			continue
+		}
+
+		if monitor != test.expected {
+			t.Errorf("Expected %+v for %q, got %+v", test.expected, test.query, monitor)
+		}
+	}
+
+	for _, query := range []string{"avg:foo{*}", "default_zero(avg:foo{*})", "timeshift(avg:foo{*}, -3600) > 1"} {
+		if monitor, ok := ParseMonitorQuery(query); ok {
+			t.Errorf("Expected %q not to be a monitor query, got %+v", query, monitor)
+		}
+	}
+}
+
+func TestMonitorQueries(t *testing.T) {
+	t.Run("a monitor with a single metric isn't complex", func(t *testing.T) {
+		analysis := ParseQuery("avg(last_5m):avg:foo{*} > 1")
+
+		if analysis.IsComplex || len(analysis.Metrics) != 1 || len(analysis.Problems) != 0 {
+			t.Errorf("Expected a single metric and no problems, got %+v", analysis)
+		}
+
+		if analysis.MetricQuery() != "avg:foo{*}" {
+			t.Errorf("Expected the metric query to be avg:foo{*}, got %q", analysis.MetricQuery())
+		}
+	})
+
+	t.Run("only the metric query is sent to the API", func(t *testing.T) {
+		var queries []string
+
+		validator := newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+			queries = append(queries, r.URL.Query().Get("query"))
+			seriesResponse(w, 95)
+		})
+
+		result, err := validator.Validate(context.Background(), "avg(last_5m):avg:foo{*} > 90")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if len(queries) != 1 || queries[0] != "avg:foo{*}" {
+			t.Errorf("Expected a single call for avg:foo{*}, got %q", queries)
+		}
+
+		if result.Value == nil || *result.Value != 95 || result.Analysis.Monitor.Threshold != "90" {
+			t.Errorf("Expected a value of 95 for a monitor with a threshold of 90, got %+v", result)
+		}
+	})
+}
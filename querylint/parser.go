@@ -0,0 +1,662 @@
+package querylint
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const defaultZero = "default_zero"
+
+// The pieces of a single metric query, e.g. `avg:foo.bar{env:prod} by {host}.fill(null)`. Datadog metric names are
+// ASCII alphanumerics, underscores and periods; any segment can start with a digit (`aws.elb.httpcode_elb_5xx`), and
+// underscores can repeat (`custom.queue__depth`), so the name is matched greedily right up to the tag filter. As well as
+// the space aggregators, distributions, like APM's `trace.*` metrics, can be queried at a percentile, e.g. `p99:` or
+// `p99.9:`. Tag values can be any UTF-8, e.g. `service:café`, so the tag filter is everything up to its closing brace,
+// rather than a character class.
+const (
+	aggregatorPattern = `(?:avg|sum|min|max|count|p\d+(?:\.\d+)?):`
+	metricNamePattern = `[a-zA-Z0-9_.]+`
+	tagFilterPattern  = `\{[^}]*\}`
+	groupByPattern    = `(?:\s*by\s*\{[^}]*\})?`
+)
+
+// metricPattern matches a single metric query, up to the functions chained onto it, like `.fill(null)`. Their arguments
+// can have commas, spaces and nested parens, which a regexp can't match up, so they're found by chainedCallsEnd.
+//
+//nolint:gochecknoglobals
+var metricPattern = regexp.MustCompile(aggregatorPattern + metricNamePattern + tagFilterPattern + groupByPattern)
+
+// leadingMetricPattern matches a single metric query at the start of an expression, so an expression that doesn't start
+// with one is rejected straight away, rather than searched.
+//
+//nolint:gochecknoglobals
+var leadingMetricPattern = regexp.MustCompile(`^` + metricPattern.String())
+
+// chainedCallPattern matches the start of a function chained onto a metric, like `.rollup(`.
+//
+//nolint:gochecknoglobals
+var chainedCallPattern = regexp.MustCompile(`^\.[a-z_][a-z0-9_]*\(`)
+
+// aggregatorPrefixPattern matches the aggregator at the start of a metric query, wherever it is in the query.
+//
+//nolint:gochecknoglobals
+var aggregatorPrefixPattern = regexp.MustCompile(`\b` + aggregatorPattern)
+
+// numericLiteralPattern matches a scalar in a query's arithmetic, like `5`, `-5`, `0.25` or `1e3`. A sign is only part of
+// the literal when it can't be an operator, i.e. at the start of the query, or after another operator or a paren, so the
+// `-` in `avg:foo{*} - 5` is left as an operator, while `avg:foo{*} - -5` subtracts a negative number.
+//
+//nolint:gochecknoglobals
+var numericLiteralPattern = regexp.MustCompile(`(?:^|[-+*/(,]\s*)([-+]?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?)\b`)
+
+// scalarOperatorsPattern matches what's left of an expression made only of numbers once they're removed.
+//
+//nolint:gochecknoglobals
+var scalarOperatorsPattern = regexp.MustCompile(`^[\s()+\-*/]*$`)
+
+// What a wrapper function does to the metric it wraps.
+type wrapperKind int
+
+const (
+	wrapperMasking   wrapperKind = iota // Can hide the metric not returning any data, by filling or clamping values
+	wrapperTimeShift                    // Shifts the metric back in time
+	wrapperCount                        // Counts the metric's series, rather than aggregating their values
+	wrapperMath                         // Transforms each of the metric's values, without hiding whether it has any
+)
+
+// wrapperFunctions are the functions that wrap a single metric, which is always their first argument, and can be
+// peeled off to get at the bare metric. Any other arguments are scalars, like the bound in `clamp_min(q, 0)`.
+//
+//nolint:gochecknoglobals
+var wrapperFunctions = map[string]wrapperKind{
+	"default_zero": wrapperMasking,
+	"clamp_min":    wrapperMasking,
+	"clamp_max":    wrapperMasking,
+	"cutoff_min":   wrapperMasking,
+	"cutoff_max":   wrapperMasking,
+
+	// The regression functions replace the metric's values with a fitted line (or steps), hiding the metric itself.
+	"robust_trend":       wrapperMasking,
+	"trend_line":         wrapperMasking,
+	"piecewise_constant": wrapperMasking,
+
+	"timeshift":   wrapperTimeShift,
+	"hour_before": wrapperTimeShift,
+	"day_before":  wrapperTimeShift,
+	"week_before": wrapperTimeShift,
+
+	"count_nonzero":  wrapperCount,
+	"count_not_null": wrapperCount,
+
+	// The single argument math functions are only peeled off a single metric; wrapped around an expression, like
+	// `abs(a - b)`, the metrics inside are found one by one instead.
+	"abs":   wrapperMath,
+	"log2":  wrapperMath,
+	"log10": wrapperMath,
+}
+
+// How far back the fixed time shift functions look. timeshift() takes its offset, in seconds, as an argument.
+//
+//nolint:gochecknoglobals
+var timeShifts = map[string]time.Duration{
+	"hour_before": -time.Hour,
+	"day_before":  -24 * time.Hour,
+	"week_before": -7 * 24 * time.Hour,
+}
+
+// wrapperFunctionPattern matches a call to any of the wrapperFunctions.
+//
+//nolint:gochecknoglobals
+var wrapperFunctionPattern = regexp.MustCompile(`\b(` + strings.Join(wrapperFunctionNames(), "|") + `)\(`)
+
+// leadingWrapperFunctionPattern matches a call to any of the wrapperFunctions at the start of an expression.
+//
+//nolint:gochecknoglobals
+var leadingWrapperFunctionPattern = regexp.MustCompile(`^(` + strings.Join(wrapperFunctionNames(), "|") + `)\(`)
+
+func wrapperFunctionNames() []string {
+	names := make([]string, 0, len(wrapperFunctions))
+
+	for name := range wrapperFunctions {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// A wrapper function call peeled off a metric.
+type functionCall struct {
+	name string
+	args []string // The scalar arguments, after the metric
+}
+
+// MetricInfo describes a single metric found inside a (possibly complex) datadog query.
+type MetricInfo struct {
+	Metric             string        // The metric as it appears in the query, including any wrapper functions
+	CleanMetric        string        // The bare metric, with any wrapper functions removed
+	StartPos           int           // Byte offset in the query where Metric starts
+	EndPos             int           // Byte offset in the query just past the end of Metric
+	DefaultZeroNesting int           // Number of default_zero() calls wrapping the metric
+	Functions          []string      // Every wrapper function around the metric, outermost first
+	MaskingFunctions   []string      // The masking functions wrapping the metric, outermost first
+	TimeShift          time.Duration // How far timeshift(), hour_before(), etc shift the metric; negative is the past
+	CountModifier      string        // count_nonzero or count_not_null, if the metric is wrapped in either
+}
+
+// IsMasked returns true if the metric is wrapped in a function that can hide it not returning any data.
+func (m MetricInfo) IsMasked() bool {
+	return len(m.MaskingFunctions) > 0
+}
+
+// QueryAnalysis is the result of parsing a datadog query into the individual metrics it references.
+type QueryAnalysis struct {
+	Query     string        // The original query
+	IsComplex bool          // True if the query is more than a single bare metric (arithmetic, functions, etc)
+	Metrics   []MetricInfo  // Every metric found in the query, ordered by position
+	Problems  []ParseError  // Syntax problems found in the query, ordered by position
+	Monitor   *MonitorQuery // The monitor's evaluation prefix and threshold, for a monitor query, or nil
+	Queries   []SubQuery    // The comma separated queries, for a query that plots more than one, or nil
+}
+
+// SubQuery is one of the comma separated queries in a query that plots more than one, like `avg:a{*}, avg:b{*}`. Each
+// is a series of its own, so it's validated on its own.
+type SubQuery struct {
+	Query    string // The query, without the whitespace around it
+	StartPos int    // Byte offset in the full query where Query starts
+}
+
+// MetricQuery returns the part of the query that's a metric query, i.e. the query without a monitor's evaluation prefix
+// and threshold. This is what's sent to the API.
+func (a QueryAnalysis) MetricQuery() string {
+	if a.Monitor != nil {
+		return a.Monitor.Query
+	}
+
+	return a.Query
+}
+
+// MakesUpQuery reports whether the metric is the whole metric query on its own, or the whole of one of its comma
+// separated queries, in which case validating the query already validated the metric.
+func (a QueryAnalysis) MakesUpQuery(metric MetricInfo) bool {
+	if metric.CleanMetric == strings.TrimSpace(a.MetricQuery()) {
+		return true
+	}
+
+	for _, query := range a.Queries {
+		if metric.CleanMetric == query.Query {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The analysis of each of the comma separated queries on its own, or just the analysis if the query isn't split. The
+// positions of the metrics and problems are still in the whole query.
+func (a QueryAnalysis) subAnalyses() []QueryAnalysis {
+	if len(a.Queries) == 0 {
+		return []QueryAnalysis{a}
+	}
+
+	analyses := make([]QueryAnalysis, len(a.Queries))
+	for i, query := range a.Queries {
+		analyses[i].Query = query.Query
+	}
+
+	// The queries are in order, so each position is in the last one that starts at or before it.
+	queryAt := func(pos int) *QueryAnalysis {
+		i := len(a.Queries) - 1
+		for i > 0 && a.Queries[i].StartPos > pos {
+			i--
+		}
+
+		return &analyses[i]
+	}
+
+	for _, metric := range a.Metrics {
+		analysis := queryAt(metric.StartPos)
+		analysis.Metrics = append(analysis.Metrics, metric)
+	}
+
+	for _, problem := range a.Problems {
+		analysis := queryAt(problem.Pos)
+		analysis.Problems = append(analysis.Problems, problem)
+	}
+
+	for i := range analyses {
+		metrics := analyses[i].Metrics
+		analyses[i].IsComplex = len(metrics) > 1 || (len(metrics) == 1 && metrics[0].Metric != analyses[i].Query)
+	}
+
+	return analyses
+}
+
+// ParseQuery breaks a datadog query down into the metrics it references, and checks its syntax where it can. This is a
+// static operation, no API calls are made. Metrics wrapped in default_zero() (or clamp_min(), timeshift(), etc) are
+// reported with their wrapped and bare forms, since default_zero() will happily turn a metric that doesn't exist into a
+// stream of zeroes.
+func ParseQuery(query string) QueryAnalysis {
+	metrics, problems := extractAllMetrics(query)
+	problems = append(problems, validateTagFilters(query)...)
+
+	if problem := checkMetricQuery(query); problem != nil {
+		problems = append(problems, *problem)
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Pos < problems[j].Pos
+	})
+
+	analysis := QueryAnalysis{
+		Query:    query,
+		Metrics:  metrics,
+		Problems: problems,
+	}
+
+	// A monitor's evaluation prefix and threshold aren't part of the metric query, so they don't make it complex.
+	if monitor, ok := ParseMonitorQuery(query); ok {
+		analysis.Monitor = &monitor
+	}
+
+	// A monitor only ever evaluates a single query.
+	if analysis.Monitor == nil {
+		analysis.Queries = splitQueries(query)
+	}
+
+	analysis.IsComplex = len(metrics) > 1 ||
+		(len(metrics) == 1 && metrics[0].Metric != strings.TrimSpace(analysis.MetricQuery()))
+
+	return analysis
+}
+
+// Logs and APM queries, like `service:web status:error`, get pasted into metric query fields by mistake every so
+// often, and the API's errors for them are confusing. A metric query always has an aggregator like `avg:` or a `{}` tag
+// filter, so a query with neither can't be one.
+func checkMetricQuery(query string) *ParseError {
+	trimmed := strings.TrimSpace(query)
+	if trimmed == "" || strings.Contains(trimmed, "{") || aggregatorPrefixPattern.MatchString(trimmed) {
+		return nil
+	}
+
+	if isScalarExpression(trimmed) {
+		return &ParseError{
+			Pos:     strings.Index(query, trimmed),
+			Message: "this is only a number, it doesn't query any metrics",
+		}
+	}
+
+	return &ParseError{
+		Pos: strings.Index(query, trimmed),
+		Message: "this doesn't look like a metric query, it has no aggregator like `avg:` and no `{}` tag filter; " +
+			"is it a logs or APM query?",
+	}
+}
+
+// Check whether the expression is made up of numeric literals alone, e.g. `1e3` or `(2 * -5)`.
+func isScalarExpression(expr string) bool {
+	if numericLiteralPattern.FindStringIndex(expr) == nil {
+		return false
+	}
+
+	return scalarOperatorsPattern.MatchString(numericLiteralPattern.ReplaceAllStringFunc(expr, func(match string) string {
+		// Keep the operator or paren before the literal, only the literal itself is dropped.
+		literal := numericLiteralPattern.FindStringSubmatch(match)[1]
+
+		return strings.TrimSuffix(match, literal)
+	}))
+}
+
+// Find every metric in the query, both the ones wrapped in functions like default_zero() and the bare ones. Any wrapper
+// function calls that are never closed are returned as problems.
+func extractAllMetrics(query string) ([]MetricInfo, []ParseError) {
+	metrics, problems := extractWrappedMetrics(query)
+	metrics = append(metrics, extractRemainingMetrics(query, metrics)...)
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].StartPos < metrics[j].StartPos
+	})
+
+	return metrics, problems
+}
+
+// Find the outermost wrapper function calls in the query, and peel off any directly nested wrapper function calls to
+// get at the bare metric inside. A call that's never closed can't be extracted, so it's reported as a problem at the
+// start of the call instead; otherwise the metric inside would silently go unchecked.
+func extractWrappedMetrics(query string) ([]MetricInfo, []ParseError) {
+	var (
+		metrics  []MetricInfo
+		problems []ParseError
+	)
+
+	closing := matchParens(query)
+	offset := 0
+
+	for {
+		loc := wrapperFunctionPattern.FindStringSubmatchIndex(query[offset:])
+		if loc == nil {
+			break
+		}
+
+		startPos := offset + loc[0]
+		openPos := offset + loc[1] - 1
+
+		endPos := closing[openPos]
+		if endPos == -1 {
+			// Unbalanced, so there's nothing sensible to extract. Move past this call and keep looking.
+			problems = append(problems, ParseError{
+				Pos:     startPos,
+				Message: fmt.Sprintf("unbalanced '(' in %s(, it's never closed", query[offset+loc[2]:offset+loc[3]]),
+			})
+			offset = openPos + 1
+
+			continue
+		}
+
+		metric := MetricInfo{
+			Metric:   query[startPos : endPos+1],
+			StartPos: startPos,
+			EndPos:   endPos + 1,
+		}
+
+		cleanStart, cleanEnd, calls := unwrapCalls(query, startPos, endPos+1, closing)
+		if len(calls) == 0 {
+			// A math function around an expression, which isn't a wrapped metric, but might have some inside it.
+			offset = openPos + 1
+
+			continue
+		}
+
+		metric.CleanMetric = query[cleanStart:cleanEnd]
+
+		for _, call := range calls {
+			metric.Functions = append(metric.Functions, call.name)
+
+			switch wrapperFunctions[call.name] {
+			case wrapperMasking:
+				metric.MaskingFunctions = append(metric.MaskingFunctions, call.name)
+
+				if call.name == defaultZero {
+					metric.DefaultZeroNesting++
+				}
+			case wrapperTimeShift:
+				shift, err := timeShift(call)
+				if err != nil {
+					problems = append(problems, ParseError{Pos: startPos, Message: err.Error()})
+				}
+
+				metric.TimeShift += shift
+			case wrapperCount:
+				metric.CountModifier = call.name
+			}
+		}
+
+		metrics = append(metrics, metric)
+		offset = endPos + 1
+	}
+
+	return metrics, problems
+}
+
+// Peel wrapper function calls off the expression, returning the bare metric and the calls that wrapped it, outermost
+// first.
+func unwrapFunctions(expr string) (string, []functionCall) {
+	start, end, calls := unwrapCalls(expr, 0, len(expr), matchParens(expr))
+
+	return expr[start:end], calls
+}
+
+// Peel wrapper function calls off expr[start:end], returning the bounds of the bare metric and the calls that wrapped
+// it, outermost first. The parens are matched up by closing, which covers the whole of expr, and the expression is
+// never copied or rescanned, only narrowed, so deeply nested calls are still cheap.
+func unwrapCalls(expr string, start int, end int, closing []int) (int, int, []functionCall) {
+	var calls []functionCall
+
+	// The bounds of the expression before each call was peeled off, to put back any math functions that aren't around
+	// a single metric.
+	var outer [][2]int
+
+	for {
+		start, end = trimSpaceBounds(expr, start, end)
+
+		loc := leadingWrapperFunctionPattern.FindStringSubmatchIndex(expr[start:end])
+		if loc == nil {
+			break
+		}
+
+		openPos := start + loc[1] - 1
+		if closing[openPos] != end-1 {
+			break
+		}
+
+		// The metric is the first argument; the rest are scalars, and are only split up for this call.
+		argEnd := firstArgEnd(expr, openPos+1, end-1, closing)
+
+		var args []string
+		if argEnd < end-1 {
+			args = splitArgs(expr[argEnd+1 : end-1])
+		}
+
+		calls = append(calls, functionCall{name: expr[start+loc[2] : start+loc[3]], args: args})
+		outer = append(outer, [2]int{start, end})
+		start, end = openPos+1, argEnd
+	}
+
+	for len(calls) > 0 && wrapperFunctions[calls[len(calls)-1].name] == wrapperMath &&
+		!isSingleMetric(expr, start, end, closing) {
+		start, end = outer[len(outer)-1][0], outer[len(outer)-1][1]
+		calls, outer = calls[:len(calls)-1], outer[:len(outer)-1]
+	}
+
+	return start, end, calls
+}
+
+// Whether expr[start:end] is a single metric query, with any functions chained onto it, and nothing else.
+func isSingleMetric(expr string, start int, end int, closing []int) bool {
+	loc := leadingMetricPattern.FindStringIndex(expr[start:end])
+
+	return loc != nil && chainedCallsEnd(expr, start+loc[1], closing) == end
+}
+
+// Narrow the bounds of expr[start:end] to drop any whitespace at either end.
+func trimSpaceBounds(expr string, start int, end int) (int, int) {
+	trimmed := strings.TrimLeftFunc(expr[start:end], unicode.IsSpace)
+	start = end - len(trimmed)
+	end = start + len(strings.TrimRightFunc(trimmed, unicode.IsSpace))
+
+	return start, end
+}
+
+// Find the end of the first argument of a call whose arguments are expr[start:end], i.e. the first comma that isn't
+// nested inside parens or a tag filter, or end if there's only one argument. Nested calls are skipped over whole using
+// the matched parens, rather than walked through.
+func firstArgEnd(expr string, start int, end int, closing []int) int {
+	depth := 0
+
+	for i := start; i < end; i++ {
+		switch expr[i] {
+		case '(':
+			if closing[i] != -1 {
+				i = closing[i]
+			}
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return end
+}
+
+// Work out how far a time shift function call shifts its metric.
+func timeShift(call functionCall) (time.Duration, error) {
+	if shift, ok := timeShifts[call.name]; ok {
+		return shift, nil
+	}
+
+	if len(call.args) != 1 {
+		return 0, fmt.Errorf("%s() takes a metric and an offset in seconds, e.g. %s(q, -3600)", call.name, call.name)
+	}
+
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(call.args[0]), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s() offset %q is not a number of seconds", call.name, strings.TrimSpace(call.args[0]))
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// Split a function's arguments on the commas that aren't nested inside parens or a tag filter.
+func splitArgs(args string) []string {
+	var parts []string
+
+	depth := 0
+	start := 0
+
+	for i := range len(args) {
+		switch args[i] {
+		case '(', '{':
+			depth++
+		case ')', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, args[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, args[start:])
+}
+
+// Split the query on the commas that aren't nested inside parens or a tag filter, e.g. `avg:a{*}, avg:b{*}`. A query
+// without any, or with an empty part, like a stray trailing comma, isn't split.
+func splitQueries(query string) []SubQuery {
+	parts := splitArgs(query)
+	if len(parts) < 2 {
+		return nil
+	}
+
+	queries := make([]SubQuery, 0, len(parts))
+	pos := 0
+
+	for _, part := range parts {
+		start, end := trimSpaceBounds(query, pos, pos+len(part))
+		if start == end {
+			return nil
+		}
+
+		queries = append(queries, SubQuery{Query: query[start:end], StartPos: start})
+		pos += len(part) + 1
+	}
+
+	return queries
+}
+
+// Find the metrics that aren't already covered by one of the wrapped metrics.
+func extractRemainingMetrics(query string, covered []MetricInfo) []MetricInfo {
+	var metrics []MetricInfo
+
+	closing := matchParens(query)
+	lastEnd := 0
+
+	for _, loc := range metricPattern.FindAllStringIndex(query, -1) {
+		// Inside the arguments of the last metric's chained functions.
+		if loc[0] < lastEnd {
+			continue
+		}
+
+		// Part of a longer identifier, like the `max:` in `summax:foo{*}`, rather than an aggregator.
+		if !atMetricBoundary(query, loc[0]) {
+			continue
+		}
+
+		end := chainedCallsEnd(query, loc[1], closing)
+		lastEnd = end
+
+		if isCovered(loc[0], end, covered) {
+			continue
+		}
+
+		metric := query[loc[0]:end]
+
+		metrics = append(metrics, MetricInfo{
+			Metric:      metric,
+			CleanMetric: metric,
+			StartPos:    loc[0],
+			EndPos:      end,
+		})
+	}
+
+	return metrics
+}
+
+// Whether a metric can start at pos: at the start of the query, or after whitespace, a paren, a comma, an operator, or
+// the `:` ending a monitor's evaluation prefix. Go's regexps can't look behind, so this is checked on each match.
+func atMetricBoundary(query string, pos int) bool {
+	return pos == 0 || strings.ContainsRune(" \t\n\r(,+-*/:", rune(query[pos-1]))
+}
+
+// The position just past the functions chained on at pos, like `.rollup(sum, 300).fill(null)`, or pos if there aren't
+// any. Each call's closing paren is looked up in closing, from matchParens, so the arguments can be anything.
+func chainedCallsEnd(query string, pos int, closing []int) int {
+	for {
+		loc := chainedCallPattern.FindStringIndex(query[pos:])
+		if loc == nil {
+			return pos
+		}
+
+		end := closing[pos+loc[1]-1]
+		if end == -1 {
+			return pos
+		}
+
+		pos = end + 1
+	}
+}
+
+func isCovered(start int, end int, covered []MetricInfo) bool {
+	for _, metric := range covered {
+		if start >= metric.StartPos && end <= metric.EndPos {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Match up every paren in s with the one that closes it, in a single pass, so finding the end of any call is cheap
+// however deeply the calls are nested. The index of the paren closing an opening paren is at its index, and -1 is at
+// the index of every other byte, including opening parens that are never closed.
+func matchParens(s string) []int {
+	closing := make([]int, len(s))
+	open := []int{}
+
+	for i := range len(s) {
+		closing[i] = -1
+
+		switch s[i] {
+		case '(':
+			open = append(open, i)
+		case ')':
+			if len(open) > 0 {
+				closing[open[len(open)-1]] = i
+				open = open[:len(open)-1]
+			}
+		}
+	}
+
+	return closing
+}
@@ -0,0 +1,807 @@
+package querylint
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	t.Run("a bare metric is not complex", func(t *testing.T) {
+		query := "avg:kubernetes.cpu.usage.total{env:production,service:web}"
+		analysis := ParseQuery(query)
+
+		if analysis.IsComplex {
+			t.Errorf("Expected query %q not to be complex", query)
+		}
+
+		if len(analysis.Metrics) != 1 {
+			t.Fatalf("Expected 1 metric, got %d", len(analysis.Metrics))
+		}
+
+		metric := analysis.Metrics[0]
+		if metric.CleanMetric != query {
+			t.Errorf("Expected clean metric %q, got %q", query, metric.CleanMetric)
+		}
+
+		if metric.DefaultZeroNesting != 0 {
+			t.Errorf("Expected no default_zero nesting, got %d", metric.DefaultZeroNesting)
+		}
+	})
+
+	t.Run("default_zero is peeled off the metric", func(t *testing.T) {
+		query := "default_zero(avg:rails.temporal.workflow_task.queue_time.avg{app:web,env:production}.fill(null))"
+		analysis := ParseQuery(query)
+
+		if analysis.IsComplex {
+			t.Errorf("Expected query %q not to be complex", query)
+		}
+
+		if len(analysis.Metrics) != 1 {
+			t.Fatalf("Expected 1 metric, got %d", len(analysis.Metrics))
+		}
+
+		metric := analysis.Metrics[0]
+
+		expectedClean := "avg:rails.temporal.workflow_task.queue_time.avg{app:web,env:production}.fill(null)"
+		if metric.CleanMetric != expectedClean {
+			t.Errorf("Expected clean metric %q, got %q", expectedClean, metric.CleanMetric)
+		}
+
+		if metric.Metric != query {
+			t.Errorf("Expected metric %q, got %q", query, metric.Metric)
+		}
+
+		if metric.StartPos != 0 || metric.EndPos != len(query) {
+			t.Errorf("Expected span [0, %d), got [%d, %d)", len(query), metric.StartPos, metric.EndPos)
+		}
+
+		if metric.DefaultZeroNesting != 1 {
+			t.Errorf("Expected default_zero nesting of 1, got %d", metric.DefaultZeroNesting)
+		}
+	})
+
+	t.Run("nested default_zero calls are counted", func(t *testing.T) {
+		analysis := ParseQuery("default_zero(default_zero(sum:foo.bar{*}))")
+
+		if len(analysis.Metrics) != 1 {
+			t.Fatalf("Expected 1 metric, got %d", len(analysis.Metrics))
+		}
+
+		metric := analysis.Metrics[0]
+		if metric.CleanMetric != "sum:foo.bar{*}" {
+			t.Errorf("Expected clean metric %q, got %q", "sum:foo.bar{*}", metric.CleanMetric)
+		}
+
+		if metric.DefaultZeroNesting != 2 {
+			t.Errorf("Expected default_zero nesting of 2, got %d", metric.DefaultZeroNesting)
+		}
+	})
+
+	t.Run("arithmetic queries find every metric in order", func(t *testing.T) {
+		query := "sum:requests.errors{service:web}.as_count() / default_zero(sum:requests.total{service:web}.as_count())"
+		analysis := ParseQuery(query)
+
+		if !analysis.IsComplex {
+			t.Errorf("Expected query %q to be complex", query)
+		}
+
+		expected := []string{
+			"sum:requests.errors{service:web}.as_count()",
+			"sum:requests.total{service:web}.as_count()",
+		}
+
+		if len(analysis.Metrics) != len(expected) {
+			t.Fatalf("Expected %d metrics, got %d", len(expected), len(analysis.Metrics))
+		}
+
+		for i, metric := range analysis.Metrics {
+			if metric.CleanMetric != expected[i] {
+				t.Errorf("Expected metric %d to be %q, got %q", i, expected[i], metric.CleanMetric)
+			}
+		}
+
+		if analysis.Metrics[0].DefaultZeroNesting != 0 || analysis.Metrics[1].DefaultZeroNesting != 1 {
+			t.Errorf("Expected only the second metric to be wrapped in default_zero")
+		}
+	})
+
+	t.Run("group by clauses are part of the metric", func(t *testing.T) {
+		query := "max:system.load.1{env:staging} by {host}"
+		analysis := ParseQuery(query)
+
+		if len(analysis.Metrics) != 1 {
+			t.Fatalf("Expected 1 metric, got %d", len(analysis.Metrics))
+		}
+
+		if analysis.Metrics[0].CleanMetric != query {
+			t.Errorf("Expected clean metric %q, got %q", query, analysis.Metrics[0].CleanMetric)
+		}
+	})
+
+	t.Run("unbalanced default_zero is a problem", func(t *testing.T) {
+		analysis := ParseQuery("avg:bar{*} + default_zero(avg:foo{*}")
+
+		for _, metric := range analysis.Metrics {
+			if metric.DefaultZeroNesting != 0 {
+				t.Errorf("Expected no default_zero metrics, got %q", metric.Metric)
+			}
+		}
+
+		if len(analysis.Problems) != 1 {
+			t.Fatalf("Expected 1 problem, got %v", analysis.Problems)
+		}
+
+		if analysis.Problems[0].Pos != 13 {
+			t.Errorf("Expected the problem at position 13, got %d", analysis.Problems[0].Pos)
+		}
+
+		expected := "unbalanced '(' in default_zero(, it's never closed"
+		if analysis.Problems[0].Message != expected {
+			t.Errorf("Expected message %q, got %q", expected, analysis.Problems[0].Message)
+		}
+	})
+}
+
+func TestParseSameMetricDifferentFilters(t *testing.T) {
+	analysis := ParseQuery("avg:foo{env:prod} - avg:foo{env:staging} + default_zero(avg:foo{env:prod}.fill(zero))")
+
+	expected := []string{"avg:foo{env:prod}", "avg:foo{env:staging}", "avg:foo{env:prod}.fill(zero)"}
+
+	if len(analysis.Metrics) != len(expected) {
+		t.Fatalf("Expected %d metrics, got %d", len(expected), len(analysis.Metrics))
+	}
+
+	for i, metric := range analysis.Metrics {
+		if metric.CleanMetric != expected[i] {
+			t.Errorf("Expected metric #%d to be %q, got %q", i, expected[i], metric.CleanMetric)
+		}
+	}
+}
+
+func TestMetricNameExtraction(t *testing.T) {
+	queries := []string{
+		"sum:aws.elb.httpcode_elb_5xx{region:us-east-1}.as_count()",
+		"sum:aws.ec2.5xx_errors{*}",
+		"avg:aws.rds.cpuutilization{dbinstanceidentifier:primary}",
+		"max:kubernetes_state.deployment.replicas_available{kube_namespace:web} by {kube_deployment}",
+		"avg:kubernetes.cpu.usage.total{kube_container_name:web}.rollup(avg)",
+		"sum:custom.queue__depth{queue:default}",
+		"avg:2fa.sms.delivery_time.p95{*}",
+		"count:trace.rack.request.hits{service:web,env:production}.as_count()",
+	}
+
+	for _, query := range queries {
+		t.Run(query, func(t *testing.T) {
+			analysis := ParseQuery(query)
+
+			if len(analysis.Metrics) != 1 {
+				t.Fatalf("Expected 1 metric, got %d", len(analysis.Metrics))
+			}
+
+			if analysis.Metrics[0].CleanMetric != query {
+				t.Errorf("Expected clean metric %q, got %q", query, analysis.Metrics[0].CleanMetric)
+			}
+		})
+	}
+}
+
+func TestMaskingFunctions(t *testing.T) {
+	t.Run("scalar arguments are not part of the metric", func(t *testing.T) {
+		query := "clamp_min(avg:foo.bar{env:prod,service:web}, 0)"
+		analysis := ParseQuery(query)
+
+		if len(analysis.Metrics) != 1 {
+			t.Fatalf("Expected 1 metric, got %d", len(analysis.Metrics))
+		}
+
+		metric := analysis.Metrics[0]
+		if metric.CleanMetric != "avg:foo.bar{env:prod,service:web}" {
+			t.Errorf("Expected clean metric %q, got %q", "avg:foo.bar{env:prod,service:web}", metric.CleanMetric)
+		}
+
+		if metric.Metric != query {
+			t.Errorf("Expected metric %q, got %q", query, metric.Metric)
+		}
+
+		if len(metric.MaskingFunctions) != 1 || metric.MaskingFunctions[0] != "clamp_min" {
+			t.Errorf("Expected masking functions [clamp_min], got %v", metric.MaskingFunctions)
+		}
+
+		if metric.DefaultZeroNesting != 0 {
+			t.Errorf("Expected no default_zero nesting, got %d", metric.DefaultZeroNesting)
+		}
+	})
+
+	t.Run("masking functions nest in any order", func(t *testing.T) {
+		analysis := ParseQuery("cutoff_max(default_zero(clamp_max(sum:foo{*}.as_count(), 100)), 50)")
+
+		if len(analysis.Metrics) != 1 {
+			t.Fatalf("Expected 1 metric, got %d", len(analysis.Metrics))
+		}
+
+		metric := analysis.Metrics[0]
+		if metric.CleanMetric != "sum:foo{*}.as_count()" {
+			t.Errorf("Expected clean metric %q, got %q", "sum:foo{*}.as_count()", metric.CleanMetric)
+		}
+
+		expected := []string{"cutoff_max", "default_zero", "clamp_max"}
+		if len(metric.MaskingFunctions) != len(expected) {
+			t.Fatalf("Expected masking functions %v, got %v", expected, metric.MaskingFunctions)
+		}
+
+		for i, function := range expected {
+			if metric.MaskingFunctions[i] != function {
+				t.Errorf("Expected masking functions %v, got %v", expected, metric.MaskingFunctions)
+			}
+		}
+
+		if metric.DefaultZeroNesting != 1 {
+			t.Errorf("Expected default_zero nesting of 1, got %d", metric.DefaultZeroNesting)
+		}
+	})
+
+	t.Run("regression functions are peeled off too", func(t *testing.T) {
+		tests := map[string][]string{
+			"trend_line(default_zero(avg:foo{*}))":         {"trend_line", "default_zero"},
+			"robust_trend(avg:foo{*})":                     {"robust_trend"},
+			"default_zero(piecewise_constant(avg:foo{*}))": {"default_zero", "piecewise_constant"},
+		}
+
+		for query, expected := range tests {
+			analysis := ParseQuery(query)
+
+			if len(analysis.Metrics) != 1 || analysis.Metrics[0].CleanMetric != "avg:foo{*}" {
+				t.Fatalf("Expected the bare metric avg:foo{*} in %q, got %v", query, analysis.Metrics)
+			}
+
+			if !slices.Equal(analysis.Metrics[0].MaskingFunctions, expected) {
+				t.Errorf("Expected masking functions %v in %q, got %v", expected, query, analysis.Metrics[0].MaskingFunctions)
+			}
+		}
+	})
+
+	t.Run("bare metrics are not masked", func(t *testing.T) {
+		analysis := ParseQuery("avg:foo{*} + cutoff_min(avg:bar{*}, 1)")
+
+		if len(analysis.Metrics) != 2 {
+			t.Fatalf("Expected 2 metrics, got %d", len(analysis.Metrics))
+		}
+
+		if analysis.Metrics[0].IsMasked() {
+			t.Errorf("Expected %q not to be masked", analysis.Metrics[0].CleanMetric)
+		}
+
+		if !analysis.Metrics[1].IsMasked() {
+			t.Errorf("Expected %q to be masked", analysis.Metrics[1].CleanMetric)
+		}
+	})
+}
+
+func TestTimeShiftFunctions(t *testing.T) {
+	tests := []struct {
+		query string
+		clean string
+		shift time.Duration
+	}{
+		{"hour_before(avg:foo{*})", "avg:foo{*}", -time.Hour},
+		{"day_before(sum:foo{*}.as_count())", "sum:foo{*}.as_count()", -24 * time.Hour},
+		{"week_before(max:foo{env:prod,service:web})", "max:foo{env:prod,service:web}", -7 * 24 * time.Hour},
+		{"timeshift(avg:foo{*}, -3600)", "avg:foo{*}", -time.Hour},
+		{"timeshift(default_zero(avg:foo{*}), -1800)", "avg:foo{*}", -30 * time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			analysis := ParseQuery(test.query)
+
+			if len(analysis.Problems) != 0 {
+				t.Fatalf("Expected no problems, got %v", analysis.Problems)
+			}
+
+			if len(analysis.Metrics) != 1 {
+				t.Fatalf("Expected 1 metric, got %d", len(analysis.Metrics))
+			}
+
+			metric := analysis.Metrics[0]
+			if metric.CleanMetric != test.clean {
+				t.Errorf("Expected clean metric %q, got %q", test.clean, metric.CleanMetric)
+			}
+
+			if metric.TimeShift != test.shift {
+				t.Errorf("Expected a time shift of %s, got %s", test.shift, metric.TimeShift)
+			}
+		})
+	}
+
+	t.Run("comparison queries have one metric per side", func(t *testing.T) {
+		analysis := ParseQuery("avg:foo{*} - hour_before(avg:foo{*})")
+
+		if len(analysis.Metrics) != 2 {
+			t.Fatalf("Expected 2 metrics, got %d", len(analysis.Metrics))
+		}
+
+		if analysis.Metrics[0].TimeShift != 0 || analysis.Metrics[1].TimeShift != -time.Hour {
+			t.Errorf("Expected only the second metric to be shifted, got %s and %s",
+				analysis.Metrics[0].TimeShift, analysis.Metrics[1].TimeShift)
+		}
+
+		if analysis.Metrics[1].IsMasked() {
+			t.Errorf("Expected a time shifted metric not to be masked")
+		}
+	})
+
+	t.Run("non-numeric offsets are a problem", func(t *testing.T) {
+		analysis := ParseQuery("timeshift(avg:foo{*}, an_hour)")
+
+		if len(analysis.Problems) != 1 {
+			t.Fatalf("Expected 1 problem, got %v", analysis.Problems)
+		}
+	})
+}
+
+func TestCountModifiers(t *testing.T) {
+	tests := []struct {
+		query    string
+		modifier string
+		nesting  int
+	}{
+		{"count_nonzero(avg:foo{*} by {host})", "count_nonzero", 0},
+		{"count_not_null(sum:bar{*} by {host})", "count_not_null", 0},
+		{"default_zero(count_nonzero(avg:foo{*} by {host}))", "count_nonzero", 1},
+		{"count_not_null(default_zero(avg:foo{*} by {host}))", "count_not_null", 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			analysis := ParseQuery(test.query)
+
+			if len(analysis.Metrics) != 1 {
+				t.Fatalf("Expected 1 metric, got %d", len(analysis.Metrics))
+			}
+
+			metric := analysis.Metrics[0]
+			if metric.Metric != test.query {
+				t.Errorf("Expected the metric to span the whole query, got %q", metric.Metric)
+			}
+
+			if !strings.HasSuffix(metric.CleanMetric, "{*} by {host}") || strings.Contains(metric.CleanMetric, "(") {
+				t.Errorf("Expected a bare clean metric, got %q", metric.CleanMetric)
+			}
+
+			if metric.CountModifier != test.modifier {
+				t.Errorf("Expected count modifier %q, got %q", test.modifier, metric.CountModifier)
+			}
+
+			if metric.DefaultZeroNesting != test.nesting {
+				t.Errorf("Expected default_zero nesting of %d, got %d", test.nesting, metric.DefaultZeroNesting)
+			}
+		})
+	}
+}
+
+func TestNotAMetricQuery(t *testing.T) {
+	t.Run("logs and APM queries are flagged", func(t *testing.T) {
+		for _, query := range []string{
+			"service:web status:error",
+			"  @http.status_code:500 env:prod",
+			"trace.http.request.errors",
+		} {
+			problems := ParseQuery(query).Problems
+			if len(problems) != 1 || !strings.Contains(problems[0].Message, "doesn't look like a metric query") {
+				t.Errorf("Expected %q to be flagged as not a metric query, got %v", query, problems)
+			}
+		}
+	})
+
+	t.Run("metric queries aren't flagged", func(t *testing.T) {
+		for _, query := range []string{
+			"avg:foo{*}",
+			"sum:foo{env:prod}.as_count() / sum:bar{env:prod}.as_count()",
+			"default_zero(max:foo{*})",
+		} {
+			if problems := ParseQuery(query).Problems; len(problems) != 0 {
+				t.Errorf("Expected no problems for %q, got %v", query, problems)
+			}
+		}
+	})
+}
+
+func TestChainedFunctions(t *testing.T) {
+	tests := []struct {
+		query   string
+		metrics []string
+	}{
+		{"avg:foo{*}.rollup(sum, 300).fill(null)", []string{"avg:foo{*}.rollup(sum, 300).fill(null)"}},
+		{"avg:foo{*} by {host}.rollup( sum , 300 ).fill(null, 10)", []string{"avg:foo{*} by {host}.rollup( sum , 300 ).fill(null, 10)"}},
+		{"sum:foo{*}.as_count().rollup(sum, 300) / sum:bar{*}.fill(zero)", []string{"sum:foo{*}.as_count().rollup(sum, 300)", "sum:bar{*}.fill(zero)"}},
+		{"avg:foo{*}.rollup(avg, (60 * 5)).fill(null) + 1", []string{"avg:foo{*}.rollup(avg, (60 * 5)).fill(null)"}},
+		{"default_zero(avg:foo{*}.rollup(sum, 300).fill(null)) + avg:bar{*}", []string{"avg:foo{*}.rollup(sum, 300).fill(null)", "avg:bar{*}"}},
+		{"avg:foo{*}.rollup(sum, 300", []string{"avg:foo{*}"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			analysis := ParseQuery(test.query)
+
+			metrics := make([]string, 0, len(analysis.Metrics))
+			for _, metric := range analysis.Metrics {
+				metrics = append(metrics, metric.CleanMetric)
+
+				if test.query[metric.StartPos:metric.EndPos] != metric.Metric {
+					t.Errorf("Expected the positions of %q to match it, got %q", metric.Metric,
+						test.query[metric.StartPos:metric.EndPos])
+				}
+			}
+
+			if !slices.Equal(metrics, test.metrics) {
+				t.Errorf("Expected metrics %v, got %v", test.metrics, metrics)
+			}
+		})
+	}
+}
+
+func TestAggregatorBoundaries(t *testing.T) {
+	tests := []struct {
+		query   string
+		metrics []string
+	}{
+		{"avg:foo{*} + custommax:bar{*}", []string{"avg:foo{*}"}},
+		{"avg:foo{*} - rollup_sum:bar{*}", []string{"avg:foo{*}"}},
+		{"cumsum(avg:foo{*})", []string{"avg:foo{*}"}},
+		{"avg:foo{check:max:disk,name:web_sum:bar}", []string{"avg:foo{check:max:disk,name:web_sum:bar}"}},
+		{"max(last_5m):max:foo{*} > 1", []string{"max:foo{*}"}},
+		{"(sum:foo{*}*2)/min:bar{*},count:baz{*}", []string{"sum:foo{*}", "min:bar{*}", "count:baz{*}"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			analysis := ParseQuery(test.query)
+
+			metrics := make([]string, 0, len(analysis.Metrics))
+			for _, metric := range analysis.Metrics {
+				metrics = append(metrics, metric.CleanMetric)
+			}
+
+			if !slices.Equal(metrics, test.metrics) {
+				t.Errorf("Expected metrics %v, got %v", test.metrics, metrics)
+			}
+		})
+	}
+}
+
+func TestTraceMetrics(t *testing.T) {
+	tests := []struct {
+		query   string
+		metrics []string
+	}{
+		{
+			"avg:trace.http.request.duration{service:web,env:prod}.rollup(avg, 60)",
+			[]string{"avg:trace.http.request.duration{service:web,env:prod}.rollup(avg, 60)"},
+		},
+		{
+			"sum:trace.http.request.errors{service:web}.as_count() / sum:trace.http.request.hits{service:web}.as_count()",
+			[]string{"sum:trace.http.request.errors{service:web}.as_count()", "sum:trace.http.request.hits{service:web}.as_count()"},
+		},
+		{
+			"sum:trace.http.request.hits.by_http_status{http.status_class:5xx} by {resource_name}.as_count()",
+			[]string{"sum:trace.http.request.hits.by_http_status{http.status_class:5xx} by {resource_name}.as_count()"},
+		},
+		{
+			"avg:trace.rack.request.duration.by.service.99p{service:web}.fill(null).rollup(max)",
+			[]string{"avg:trace.rack.request.duration.by.service.99p{service:web}.fill(null).rollup(max)"},
+		},
+		{"p99:trace.http.request{service:web}", []string{"p99:trace.http.request{service:web}"}},
+		{"p99.9:trace.grpc.server{*} by {resource_name}", []string{"p99.9:trace.grpc.server{*} by {resource_name}"}},
+		{"default_zero(p95:trace.http.request{*})", []string{"p95:trace.http.request{*}"}},
+		{"percentile(last_5m):p99:trace.http.request{*} > 2", []string{"p99:trace.http.request{*}"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			analysis := ParseQuery(test.query)
+
+			metrics := make([]string, 0, len(analysis.Metrics))
+			for _, metric := range analysis.Metrics {
+				metrics = append(metrics, metric.CleanMetric)
+
+				// The span has to cover the metric exactly, for highlighting it in the logs.
+				if !strings.Contains(test.query[metric.StartPos:metric.EndPos], metric.CleanMetric) {
+					t.Errorf("Expected the span %q to cover %q", test.query[metric.StartPos:metric.EndPos], metric.CleanMetric)
+				}
+			}
+
+			if !slices.Equal(metrics, test.metrics) {
+				t.Errorf("Expected metrics %v, got %v", test.metrics, metrics)
+			}
+
+			if len(analysis.Problems) > 0 {
+				t.Errorf("Expected no problems, got %+v", analysis.Problems)
+			}
+		})
+	}
+}
+
+func TestUnicodeTagValues(t *testing.T) {
+	tests := []struct {
+		query   string
+		metrics []string
+	}{
+		{"avg:foo{service:café}", []string{"avg:foo{service:café}"}},
+		{"avg:foo{service:café,env:prod} by {host}.fill(null)", []string{"avg:foo{service:café,env:prod} by {host}.fill(null)"}},
+		{
+			"sum:foo{team:müller}.as_count() / sum:bar{name:日本語}.as_count()",
+			[]string{"sum:foo{team:müller}.as_count()", "sum:bar{name:日本語}.as_count()"},
+		},
+		{"default_zero(avg:foo{region:são_paulo})", []string{"avg:foo{region:são_paulo}"}},
+		{"avg(last_5m):avg:foo{service:ñandú} > 1", []string{"avg:foo{service:ñandú}"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			analysis := ParseQuery(test.query)
+
+			metrics := make([]string, 0, len(analysis.Metrics))
+			for _, metric := range analysis.Metrics {
+				metrics = append(metrics, metric.CleanMetric)
+
+				// The span is in bytes, so it has to land on the rune boundaries around the metric.
+				if !strings.Contains(test.query[metric.StartPos:metric.EndPos], metric.CleanMetric) {
+					t.Errorf("Expected the span %q to cover %q", test.query[metric.StartPos:metric.EndPos], metric.CleanMetric)
+				}
+			}
+
+			if !slices.Equal(metrics, test.metrics) {
+				t.Errorf("Expected metrics %v, got %v", test.metrics, metrics)
+			}
+
+			if len(analysis.Problems) > 0 {
+				t.Errorf("Expected no problems, got %+v", analysis.Problems)
+			}
+		})
+	}
+}
+
+func TestMathFunctions(t *testing.T) {
+	tests := []struct {
+		query     string
+		metrics   []string
+		functions []string
+		masking   []string
+	}{
+		{"abs(avg:foo{*})", []string{"avg:foo{*}"}, []string{"abs"}, nil},
+		{"log10(avg:foo{*}.rollup(sum, 60))", []string{"avg:foo{*}.rollup(sum, 60)"}, []string{"log10"}, nil},
+		{"log2(abs(default_zero(avg:foo{*})))", []string{"avg:foo{*}"}, []string{"log2", "abs", "default_zero"}, []string{"default_zero"}},
+		{"abs(avg:foo{*} - avg:bar{*})", []string{"avg:foo{*}", "avg:bar{*}"}, nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			analysis := ParseQuery(test.query)
+
+			metrics := make([]string, 0, len(analysis.Metrics))
+			for _, metric := range analysis.Metrics {
+				metrics = append(metrics, metric.CleanMetric)
+			}
+
+			if !slices.Equal(metrics, test.metrics) {
+				t.Fatalf("Expected metrics %v, got %v", test.metrics, metrics)
+			}
+
+			if len(metrics) != 1 {
+				return
+			}
+
+			metric := analysis.Metrics[0]
+			if !slices.Equal(metric.Functions, test.functions) {
+				t.Errorf("Expected functions %v, got %v", test.functions, metric.Functions)
+			}
+
+			if !slices.Equal(metric.MaskingFunctions, test.masking) {
+				t.Errorf("Expected masking functions %v, got %v", test.masking, metric.MaskingFunctions)
+			}
+		})
+	}
+}
+
+func TestNumericLiterals(t *testing.T) {
+	t.Run("scalars aren't metrics", func(t *testing.T) {
+		tests := []struct {
+			query   string
+			metrics []string
+		}{
+			{"avg:foo{*} * 1e3", []string{"avg:foo{*}"}},
+			{"avg:foo{*}*1E3", []string{"avg:foo{*}"}},
+			{"avg:bar{*} - -5", []string{"avg:bar{*}"}},
+			{"1.5e-3 * avg:foo{*}", []string{"avg:foo{*}"}},
+			{".5 * avg:foo{*} + +2", []string{"avg:foo{*}"}},
+			{"clamp_min(avg:foo{*}, -1e-3) / 2", []string{"avg:foo{*}"}},
+			{"sum:foo.5xx{*} * 1e3 / sum:bar{*}.rollup(sum, 60)", []string{"sum:foo.5xx{*}", "sum:bar{*}.rollup(sum, 60)"}},
+		}
+
+		for _, test := range tests {
+			analysis := ParseQuery(test.query)
+
+			if len(analysis.Problems) != 0 {
+				t.Errorf("Expected no problems for %q, got %v", test.query, analysis.Problems)
+			}
+
+			if !analysis.IsComplex {
+				t.Errorf("Expected %q to be complex", test.query)
+			}
+
+			metrics := make([]string, 0, len(analysis.Metrics))
+			for _, metric := range analysis.Metrics {
+				metrics = append(metrics, metric.CleanMetric)
+			}
+
+			if !slices.Equal(metrics, test.metrics) {
+				t.Errorf("Expected metrics %v for %q, got %v", test.metrics, test.query, metrics)
+			}
+		}
+	})
+
+	t.Run("a query that's only a number is flagged", func(t *testing.T) {
+		for _, query := range []string{"1e3", "-5", " (2 * -0.5) + 1 "} {
+			problems := ParseQuery(query).Problems
+			if len(problems) != 1 || problems[0].Message != "this is only a number, it doesn't query any metrics" {
+				t.Errorf("Expected %q to be flagged as only a number, got %v", query, problems)
+			}
+		}
+	})
+
+	t.Run("timeshift offsets can use scientific notation", func(t *testing.T) {
+		analysis := ParseQuery("timeshift(avg:foo{*}, -3.6e3)")
+
+		if len(analysis.Metrics) != 1 || analysis.Metrics[0].TimeShift != -time.Hour {
+			t.Errorf("Expected a metric shifted back an hour, got %+v", analysis.Metrics)
+		}
+	})
+}
+
+func TestParseDeeplyNested(t *testing.T) {
+	// Each level used to rescan everything inside it, which took seconds at this depth.
+	const depth = 20000
+
+	t.Run("nested calls", func(t *testing.T) {
+		query := strings.Repeat("default_zero(", depth) + "avg:foo{*}" + strings.Repeat(")", depth)
+
+		analysis := ParseQuery(query)
+		if len(analysis.Metrics) != 1 || analysis.Metrics[0].DefaultZeroNesting != depth {
+			t.Fatalf("Expected 1 metric nested %d deep, got %d metric(s)", depth, len(analysis.Metrics))
+		}
+
+		if analysis.Metrics[0].CleanMetric != "avg:foo{*}" {
+			t.Errorf("Expected avg:foo{*}, got %q", analysis.Metrics[0].CleanMetric)
+		}
+	})
+
+	t.Run("nested math functions", func(t *testing.T) {
+		query := strings.Repeat("abs(1 + ", depth) + "avg:foo{*}" + strings.Repeat(")", depth)
+
+		analysis := ParseQuery(query)
+		if len(analysis.Metrics) != 1 || analysis.Metrics[0].CleanMetric != "avg:foo{*}" {
+			t.Fatalf("Expected only avg:foo{*}, got %d metric(s)", len(analysis.Metrics))
+		}
+
+		if len(analysis.Metrics[0].Functions) != 0 {
+			t.Errorf("Expected no wrapper functions, got %v", analysis.Metrics[0].Functions)
+		}
+	})
+
+	t.Run("unclosed calls", func(t *testing.T) {
+		query := strings.Repeat("default_zero(", depth) + "avg:foo{*}"
+
+		if problems := ParseQuery(query).Problems; len(problems) != depth {
+			t.Errorf("Expected %d problems, got %d", depth, len(problems))
+		}
+	})
+}
+
+func FuzzParseQuery(f *testing.F) {
+	seeds := []string{
+		"avg:foo{*}",
+		"default_zero(avg:foo{env:prod}) + sum:bar{*}.as_count()",
+		"timeshift(default_zero(clamp_min(avg:foo{*}, 0)), -3600)",
+		"((((avg:foo{*}",
+		"default_zero(default_zero(",
+		"avg:foo{env:prod,}}",
+		"top(avg:foo{*} by {host}, 10, 'mean', 'desc')",
+		"count_nonzero(avg:foo{*}) / 100 ** 2",
+		"",
+	}
+
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, query string) {
+		analysis := ParseQuery(query)
+
+		for _, metric := range analysis.Metrics {
+			if metric.StartPos < 0 || metric.EndPos > len(query) || metric.StartPos > metric.EndPos {
+				t.Errorf("Metric %q is out of range [%d:%d] of %q", metric.Metric, metric.StartPos, metric.EndPos, query)
+			}
+		}
+
+		for _, problem := range analysis.Problems {
+			if problem.Pos < 0 || problem.Pos > len(query) {
+				t.Errorf("Problem %q is out of range at %d of %q", problem.Message, problem.Pos, query)
+			}
+		}
+
+		_ = analysis.Canonical()
+	})
+}
+
+func TestCommaSeparatedQueries(t *testing.T) {
+	t.Run("top level commas separate queries", func(t *testing.T) {
+		analysis := ParseQuery("avg:a{env:prod,region:us} by {host}, default_zero(clamp_min(sum:b{*}, 0)) ,count:c{*}")
+
+		expected := []SubQuery{
+			{Query: "avg:a{env:prod,region:us} by {host}", StartPos: 0},
+			{Query: "default_zero(clamp_min(sum:b{*}, 0))", StartPos: 37},
+			{Query: "count:c{*}", StartPos: 75},
+		}
+
+		if !slices.Equal(analysis.Queries, expected) {
+			t.Errorf("Expected queries %+v, got %+v", expected, analysis.Queries)
+		}
+
+		for _, metric := range analysis.Metrics {
+			if !analysis.MakesUpQuery(metric) && metric.CleanMetric != "sum:b{*}" {
+				t.Errorf("Expected %q to make up one of the queries", metric.CleanMetric)
+			}
+		}
+	})
+
+	t.Run("a single query isn't split", func(t *testing.T) {
+		for _, query := range []string{"avg:a{env:prod,region:us}", "clamp_min(avg:a{*}, 0)", "avg:a{*},", "avg(last_5m):avg:a{*} > 1"} {
+			if queries := ParseQuery(query).Queries; queries != nil {
+				t.Errorf("Expected %q not to be split, got %+v", query, queries)
+			}
+		}
+	})
+}
+
+func TestSubAnalyses(t *testing.T) {
+	t.Run("each query has its own metrics", func(t *testing.T) {
+		analyses := ParseQuery("count:a.x{*}, avg:b{*} / count:c{*}").subAnalyses()
+		if len(analyses) != 2 {
+			t.Fatalf("Expected 2 queries, got %d", len(analyses))
+		}
+
+		if analyses[0].Query != "count:a.x{*}" || len(analyses[0].Metrics) != 1 || analyses[0].IsComplex {
+			t.Errorf("Expected count:a.x{*} on its own, got %+v", analyses[0])
+		}
+
+		if analyses[1].Query != "avg:b{*} / count:c{*}" || len(analyses[1].Metrics) != 2 || !analyses[1].IsComplex {
+			t.Errorf("Expected the arithmetic with both of its metrics, got %+v", analyses[1])
+		}
+
+		if analyses[1].Metrics[0].StartPos != 14 {
+			t.Errorf("Expected the position in the whole query, got %d", analyses[1].Metrics[0].StartPos)
+		}
+	})
+
+	t.Run("query level rules check each query on its own", func(t *testing.T) {
+		findings := Lint(ParseQuery("count:a.x{*}, avg:b{*} / count:c{*}"), Rules{RuleMixedAggregation: SeverityWarn})
+		if len(findings) != 1 || findings[0].Metric.CleanMetric != "count:c{*}" {
+			t.Errorf("Expected only count:c{*} to be combined with avg:b{*}, got %v", findings)
+		}
+
+		findings = Lint(ParseQuery("avg:a{*}, ((("), Rules{RuleNoMetricsExtracted: SeverityWarn})
+		if len(findings) != 1 {
+			t.Errorf("Expected the query without any metrics to be found, got %v", findings)
+		}
+	})
+
+	t.Run("a single query is checked whole", func(t *testing.T) {
+		analysis := ParseQuery("avg:a{*} + avg:b{*}")
+
+		if analyses := analysis.subAnalyses(); len(analyses) != 1 || analyses[0].Query != analysis.Query {
+			t.Errorf("Expected the whole analysis, got %+v", analyses)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package querylint
+
+import (
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// QueryLine returns the line the value at queryPath is on in the yaml (or JSON, which is yaml too), starting from 1, so
+// a query can be pointed at in an editor or a pull request. The queryPath is a dotted path of map keys and list indexes,
+// like for ExtractQueryAtPath. It's 0 if there's nothing at queryPath, or the yaml can't be parsed.
+func QueryLine(data []byte, queryPath string) int {
+	var doc yaml.Node
+
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return 0
+	}
+
+	node := lookupNode(&doc, strings.Split(queryPath, "."))
+	if node == nil {
+		return 0
+	}
+
+	return node.Line
+}
+
+// Walk the yaml nodes, following map keys and list indexes, like lookupPath. Returns nil if any part of the path doesn't
+// exist.
+func lookupNode(node *yaml.Node, path []string) *yaml.Node {
+	for _, segment := range path {
+		node = resolveNode(node)
+		if node == nil {
+			return nil
+		}
+
+		switch node.Kind {
+		case yaml.MappingNode:
+			var next *yaml.Node
+
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == segment {
+					next = node.Content[i+1]
+
+					break
+				}
+			}
+
+			if next == nil {
+				return nil
+			}
+
+			node = next
+		case yaml.SequenceNode:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(node.Content) {
+				return nil
+			}
+
+			node = node.Content[index]
+		default:
+			return nil
+		}
+	}
+
+	return resolveNode(node)
+}
+
+// Step through documents and aliases to the node they stand for, or nil for an empty document.
+func resolveNode(node *yaml.Node) *yaml.Node {
+	for node != nil {
+		switch {
+		case node.Kind == yaml.AliasNode:
+			node = node.Alias
+		case node.Kind == yaml.DocumentNode && len(node.Content) > 0:
+			node = node.Content[0]
+		case node.Kind == yaml.DocumentNode:
+			return nil
+		default:
+			return node
+		}
+	}
+
+	return nil
+}
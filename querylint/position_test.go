@@ -0,0 +1,55 @@
+package querylint
+
+import (
+	"testing"
+)
+
+func TestQueryLine(t *testing.T) {
+	data := []byte(`apiVersion: datadoghq.com/v1alpha1
+kind: DatadogMetric
+metadata:
+  name: "query: in a name"
+spec:
+  groups:
+    - name: first
+      query: avg:foo{*}
+    - name: second
+      query: |
+        sum:bar{*}
+  query: avg:baz{*}
+`)
+
+	tests := []struct {
+		path     string
+		expected int
+	}{
+		{"spec.query", 12},
+		{"spec.groups.0.query", 8},
+		{"spec.groups.1.query", 10},
+		{"spec.groups.2.query", 0},
+		{"spec.missing", 0},
+		{"metadata.name.query", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if actual := QueryLine(data, tt.path); actual != tt.expected {
+				t.Errorf("Expected line %d, got %d", tt.expected, actual)
+			}
+		})
+	}
+
+	t.Run("json", func(t *testing.T) {
+		data := []byte("{\n  \"spec\": {\n    \"query\": \"avg:foo{*}\"\n  }\n}\n")
+
+		if actual := QueryLine(data, DefaultQueryPath); actual != 3 {
+			t.Errorf("Expected line 3, got %d", actual)
+		}
+	})
+
+	t.Run("invalid yaml has no lines", func(t *testing.T) {
+		if actual := QueryLine([]byte("spec: [unclosed"), DefaultQueryPath); actual != 0 {
+			t.Errorf("Expected line 0, got %d", actual)
+		}
+	})
+}
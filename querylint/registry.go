@@ -0,0 +1,70 @@
+package querylint
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Rule is a static lint rule, which checks a parsed query for one kind of problem without any API calls. Each finding
+// only needs its Message, and its Metric if the problem is with one metric rather than the whole query; Lint fills in
+// the Rule and Severity. Each of a query's comma separated queries is checked on its own, with the positions still in
+// the whole query.
+type Rule interface {
+	Check(analysis *QueryAnalysis) []Finding
+}
+
+// RuleFunc lets a plain function be used as a Rule.
+type RuleFunc func(analysis *QueryAnalysis) []Finding
+
+// Check calls f(analysis).
+func (f RuleFunc) Check(analysis *QueryAnalysis) []Finding {
+	return f(analysis)
+}
+
+type registeredRule struct {
+	id   string
+	rule Rule
+}
+
+// Every static rule Lint runs, in the order their findings are reported. The built-in rules come first.
+//
+//nolint:gochecknoglobals
+var registry = []registeredRule{
+	{RuleRequireFill, RuleFunc(requireFill)},
+	{RuleRedundantDefaultZero, RuleFunc(redundantDefaultZero)},
+	{RuleSuspiciousTagFilter, RuleFunc(suspiciousTagFilter)},
+	{RuleMixedAggregation, RuleFunc(mixedAggregationRule)},
+	{RuleNoMetricsExtracted, RuleFunc(noMetricsExtracted)},
+}
+
+// The built-in rules that Lint doesn't run, since they need more than the parsed query, but whose ids are still taken.
+//
+//nolint:gochecknoglobals
+var otherRules = []string{
+	RuleRequiredTags, RuleSeriesCount, RuleDeprecatedMetric, RulePercentileDistribution, RuleMaskedInComplexQuery,
+}
+
+// RegisterRule adds a static rule for Lint to run under the id, like the built-in ones, so organization specific rules
+// can be compiled in without forking the linter. Like the built-in rules, it only runs when the Rules passed to Lint
+// give it a severity. It's meant to be called from an init() function, and panics if the id is already taken, including
+// by one of the rules that aren't static.
+func RegisterRule(id string, rule Rule) {
+	if slices.Contains(RegisteredRules(), id) || slices.Contains(otherRules, id) {
+		panic(fmt.Sprintf("querylint: rule %q is already registered", id))
+	}
+
+	registry = append(registry, registeredRule{id: id, rule: rule})
+}
+
+// RegisteredRules returns the id of every static rule Lint runs, the built-in ones and any added with RegisterRule, in
+// the order they were registered. The required-tags rule isn't one of them, since it needs the tags to require; it's
+// run by LintRequiredTags.
+func RegisteredRules() []string {
+	ids := make([]string, 0, len(registry))
+
+	for _, registered := range registry {
+		ids = append(ids, registered.id)
+	}
+
+	return ids
+}
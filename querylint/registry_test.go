@@ -0,0 +1,65 @@
+package querylint
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRegisterRule(t *testing.T) {
+	builtIn := registry
+
+	t.Cleanup(func() { registry = builtIn })
+
+	// An org specific rule, e.g. that every metric is one of the team's own.
+	RegisterRule("team-prefix", RuleFunc(func(analysis *QueryAnalysis) []Finding {
+		var findings []Finding
+
+		for _, metric := range analysis.Metrics {
+			if !strings.Contains(metric.CleanMetric, ":team.") {
+				findings = append(findings, Finding{Metric: metric, Message: "Metric isn't one of the team's"})
+			}
+		}
+
+		return findings
+	}))
+
+	t.Run("registered rules are listed after the built-in ones", func(t *testing.T) {
+		ids := RegisteredRules()
+		if len(ids) != len(builtIn)+1 || ids[len(ids)-1] != "team-prefix" || !slices.Contains(ids, RuleRequireFill) {
+			t.Errorf("Expected the built-in rules followed by team-prefix, got %v", ids)
+		}
+	})
+
+	t.Run("registered rules run at their severity", func(t *testing.T) {
+		analysis := ParseQuery("avg:team.foo{*} + avg:other.bar{*}")
+
+		if findings := Lint(analysis, Rules{}); len(findings) != 0 {
+			t.Errorf("Expected no findings with the rule off, got %+v", findings)
+		}
+
+		findings := Lint(analysis, Rules{"team-prefix": SeverityWarn})
+		if len(findings) != 1 {
+			t.Fatalf("Expected 1 finding, got %+v", findings)
+		}
+
+		finding := findings[0]
+		if finding.Rule != "team-prefix" || finding.Severity != SeverityWarn || finding.Metric.CleanMetric != "avg:other.bar{*}" {
+			t.Errorf("Expected a team-prefix warning for avg:other.bar{*}, got %+v", finding)
+		}
+	})
+
+	t.Run("ids can't be registered twice", func(t *testing.T) {
+		for _, id := range []string{"team-prefix", RuleRequireFill, RuleSeriesCount} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("Expected registering %s again to panic", id)
+					}
+				}()
+
+				RegisterRule(id, RuleFunc(func(*QueryAnalysis) []Finding { return nil }))
+			}()
+		}
+	})
+}
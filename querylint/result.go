@@ -0,0 +1,84 @@
+package querylint
+
+import (
+	"time"
+)
+
+// Status is the outcome of validating a single metric.
+type Status int
+
+const (
+	StatusOK     Status = iota // The metric returned data
+	StatusNoData               // The metric is valid, but returned no data; it might not exist
+	StatusMasked               // The metric returned no data, but a masking function like default_zero() hides that
+	StatusError                // The API rejected the metric, or couldn't be reached
+	StatusSparse               // The metric has a series with a known interval, just no datapoints in the window
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOK:
+		return "ok"
+	case StatusNoData:
+		return "no_data"
+	case StatusMasked:
+		return "masked"
+	case StatusError:
+		return "error"
+	case StatusSparse:
+		return "sparse"
+	default:
+		return "unknown"
+	}
+}
+
+// MetricResult is the outcome of validating one of the metrics found in a query on its own.
+type MetricResult struct {
+	Metric     MetricInfo    // The metric that was validated, via its CleanMetric
+	Value      *float64      // The latest datapoint returned for the metric, or nil if there was no data
+	Err        error         // The error returned by the API, if any
+	Status     Status        // The overall outcome for the metric
+	APILatency time.Duration // How long the API call for the metric took
+	Window     time.Duration // How far back the value was looked for; with Validator.Windows, the first that had data
+	Interval   time.Duration // With no data, the interval of the series the API returned anyway, or 0 if there wasn't one
+	Stats      *SeriesStats  // A summary of every datapoint in the window, or nil if there weren't any
+	Series     int           // How many series the metric matched, e.g. one per group with a group by
+	OuterValue *float64      // With Validator.CompareMasked, what the masked metric returned with its masking functions
+	OuterErr   error         // With Validator.CompareMasked, the error from querying the masked metric with its functions
+	Deprecated string        // With Validator.CheckDeprecated, the metadata that marks the metric as deprecated, if it is
+}
+
+// Result is the outcome of validating a single query, and every metric inside it.
+type Result struct {
+	Query      string         // The query that was validated
+	Analysis   QueryAnalysis  // The parsed query
+	Value      *float64       // The latest datapoint returned for the full query, or nil if there was no data
+	Metrics    []MetricResult // The outcome for each metric in Analysis.Metrics, in the same order
+	APILatency time.Duration  // How long the API call for the full query took
+	Window     time.Duration  // How far back the value was looked for; with Validator.Windows, the first that had data
+	Interval   time.Duration  // With no data, the interval of the series the API returned anyway, or 0 if there wasn't one
+	Stats      *SeriesStats   // A summary of every datapoint in the window, or nil if there weren't any
+	Series     int            // How many series the query matched, e.g. one per group with a group by
+	Queries    []Result       // For comma separated queries, the outcome of each of them, validated on its own
+}
+
+func newMetricResult(metric MetricInfo, value *float64, latency time.Duration, err error) MetricResult {
+	status := StatusOK
+
+	switch {
+	case err != nil:
+		status = StatusError
+	case value == nil && metric.IsMasked():
+		status = StatusMasked
+	case value == nil:
+		status = StatusNoData
+	}
+
+	return MetricResult{
+		Metric:     metric,
+		Value:      value,
+		Err:        err,
+		Status:     status,
+		APILatency: latency,
+	}
+}
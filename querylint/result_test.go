@@ -0,0 +1,36 @@
+package querylint
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMetricResultStatus(t *testing.T) {
+	value := 1.5
+	bare := MetricInfo{CleanMetric: "avg:foo{*}"}
+	wrapped := MetricInfo{CleanMetric: "avg:foo{*}", DefaultZeroNesting: 1, MaskingFunctions: []string{"default_zero"}}
+
+	t.Run("metrics with data are ok", func(t *testing.T) {
+		if status := newMetricResult(wrapped, &value, 0, nil).Status; status != StatusOK {
+			t.Errorf("Expected status %s, got %s", StatusOK, status)
+		}
+	})
+
+	t.Run("bare metrics without data have no data", func(t *testing.T) {
+		if status := newMetricResult(bare, nil, 0, nil).Status; status != StatusNoData {
+			t.Errorf("Expected status %s, got %s", StatusNoData, status)
+		}
+	})
+
+	t.Run("masked metrics without data are masked", func(t *testing.T) {
+		if status := newMetricResult(wrapped, nil, 0, nil).Status; status != StatusMasked {
+			t.Errorf("Expected status %s, got %s", StatusMasked, status)
+		}
+	})
+
+	t.Run("errors take precedence", func(t *testing.T) {
+		if status := newMetricResult(wrapped, nil, 0, errors.New("boom")).Status; status != StatusError {
+			t.Errorf("Expected status %s, got %s", StatusError, status)
+		}
+	})
+}
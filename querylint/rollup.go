@@ -0,0 +1,109 @@
+package querylint
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultRollupMethod is how the points in a rollup interval are combined when the metric doesn't have a rollup already.
+const defaultRollupMethod = "avg"
+
+// Rollup sets the rollup interval of every metric in the query, e.g. with 5m, `avg:foo{*} + sum:bar{*}.rollup(sum, 60)`
+// becomes `avg:foo{*}.rollup(avg, 300) + sum:bar{*}.rollup(sum, 300)`. A metric without a rollup gets an avg one, and
+// one with a rollup keeps its method; an interval that's already coarser than the given one is left as it is, so the
+// granularity only ever gets coarser. An interval under a second leaves the query as it is.
+func Rollup(query string, interval time.Duration) string {
+	seconds := int(interval / time.Second)
+	if seconds <= 0 {
+		return query
+	}
+
+	var b strings.Builder
+
+	closing := matchParens(query)
+	pos := 0
+
+	for {
+		end := metricEnd(query[pos:])
+		if end == -1 {
+			break
+		}
+
+		end += pos
+
+		// The functions chained onto the metric, like `.fill(null).rollup(sum, 60)`.
+		chainEnd := chainedCallsEnd(query, end, closing)
+
+		b.WriteString(query[pos:end])
+		b.WriteString(rollupChain(query[end:chainEnd], seconds))
+
+		pos = chainEnd
+	}
+
+	b.WriteString(query[pos:])
+
+	return b.String()
+}
+
+// The position just past the end of the first metric's tag filter, and its `by {...}` group if it has one, or -1 if
+// there's no tag filter in the query.
+func metricEnd(query string) int {
+	start := strings.IndexByte(query, '{')
+	if start == -1 {
+		return -1
+	}
+
+	end := strings.IndexByte(query[start:], '}')
+	if end == -1 {
+		return -1
+	}
+
+	end += start + 1
+
+	rest := strings.TrimLeft(query[end:], " \t\n")
+	if strings.HasPrefix(rest, "by") && strings.HasPrefix(strings.TrimLeft(rest[len("by"):], " \t\n"), "{") {
+		group := strings.IndexByte(query[end:], '{') + end
+
+		closing := strings.IndexByte(query[group:], '}')
+		if closing == -1 {
+			return -1
+		}
+
+		end = group + closing + 1
+	}
+
+	return end
+}
+
+// Set the interval of the rollup in a metric's chained functions, adding one at the end if there isn't one.
+func rollupChain(chain string, seconds int) string {
+	start := strings.Index(chain, ".rollup(")
+	if start == -1 {
+		return fmt.Sprintf("%s.rollup(%s, %d)", chain, defaultRollupMethod, seconds)
+	}
+
+	argsStart := start + len(".rollup(")
+	argsEnd := strings.IndexByte(chain[argsStart:], ')') + argsStart
+
+	method := defaultRollupMethod
+	args := strings.Split(chain[argsStart:argsEnd], ",")
+
+	if first := strings.TrimSpace(args[0]); first != "" {
+		if existing, err := strconv.Atoi(first); err == nil {
+			// `.rollup(60)`, with just an interval.
+			seconds = max(seconds, existing)
+		} else {
+			method = first
+		}
+	}
+
+	if len(args) > 1 {
+		if existing, err := strconv.Atoi(strings.TrimSpace(args[1])); err == nil {
+			seconds = max(seconds, existing)
+		}
+	}
+
+	return fmt.Sprintf("%s%s, %d%s", chain[:argsStart], method, seconds, chain[argsEnd:])
+}
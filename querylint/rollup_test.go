@@ -0,0 +1,36 @@
+package querylint
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRollup(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"avg:foo{*}", "avg:foo{*}.rollup(avg, 300)"},
+		{"avg:foo{*} + sum:bar{*}.rollup(sum, 60)", "avg:foo{*}.rollup(avg, 300) + sum:bar{*}.rollup(sum, 300)"},
+		{"default_zero(avg:foo{env:prod} by {host}.fill(null))", "default_zero(avg:foo{env:prod} by {host}.fill(null).rollup(avg, 300))"},
+		{"sum:foo{*}.as_count().rollup(sum, 3600)", "sum:foo{*}.as_count().rollup(sum, 3600)"},
+		{"max:foo{*}.rollup(max)", "max:foo{*}.rollup(max, 300)"},
+		{"avg:foo{*}.rollup(30)", "avg:foo{*}.rollup(avg, 300)"},
+		{"sum:foo{*}.rollup(sum, 60),sum:bar{*}", "sum:foo{*}.rollup(sum, 300),sum:bar{*}.rollup(avg, 300)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if actual := Rollup(tt.query, 5*time.Minute); actual != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, actual)
+			}
+		})
+	}
+
+	t.Run("no interval leaves the query as it is", func(t *testing.T) {
+		query := "avg:foo{*}.rollup(sum, 60)"
+		if actual := Rollup(query, 0); actual != query {
+			t.Errorf("Expected %q, got %q", query, actual)
+		}
+	})
+}
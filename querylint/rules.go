@@ -0,0 +1,475 @@
+package querylint
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Severity controls how the findings from a lint rule affect the run.
+type Severity int
+
+const (
+	SeverityOff   Severity = iota // The rule doesn't run
+	SeverityWarn                  // Findings are logged, but don't fail the run
+	SeverityError                 // Findings fail the run
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityOff:
+		return "off"
+	case SeverityWarn:
+		return "warn"
+	case SeverityError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseSeverity converts `off`, `warn` or `error` into a Severity.
+func ParseSeverity(s string) (Severity, error) {
+	switch strings.ToLower(s) {
+	case "off":
+		return SeverityOff, nil
+	case "warn":
+		return SeverityWarn, nil
+	case "error":
+		return SeverityError, nil
+	default:
+		return SeverityOff, fmt.Errorf("invalid severity %q, expected one of: off, warn, error", s)
+	}
+}
+
+// The ids of the static lint rules.
+const (
+	RuleRequireFill          = "require-fill"           // Every metric must set an explicit .fill() or .rollup()
+	RuleRedundantDefaultZero = "redundant-default-zero" // A metric must not be wrapped in default_zero() more than once
+	RuleNoMetricsExtracted   = "no-metrics-extracted"   // A query must have at least one metric the parser recognizes
+	RuleSuspiciousTagFilter  = "suspicious-tag-filter"  // Tag filters must not be empty, or use placeholder tags
+	RuleMixedAggregation     = "mixed-aggregation"      // Metrics combined in a query must be in compatible spaces
+	RuleRequiredTags         = "required-tags"          // Every metric must filter by each of the required tag keys
+)
+
+// The ids of the rules that check the API's response, rather than only the parsed query.
+const (
+	RuleSeriesCount            = "series-count"            // A query must match at least one series, and no more than the maximum
+	RuleDeprecatedMetric       = "deprecated-metric"       // A metric must not be marked as deprecated in its metadata
+	RulePercentileDistribution = "percentile-distribution" // A metric queried at a percentile must be a distribution
+	RuleMaskedInComplexQuery   = "masked-in-complex-query" // A masked metric in a query that validates must validate too
+)
+
+// DefaultMaxSeries is how many series a query can match before the series-count rule fires, unless it's changed.
+const DefaultMaxSeries = 1000
+
+// Rules maps a rule id to the severity it runs at. Rules that aren't in the map are off.
+type Rules map[string]Severity
+
+// Finding is a problem reported by one of the static lint rules.
+type Finding struct {
+	Rule     string     // The id of the rule that reported the problem
+	Severity Severity   // The severity the rule is running at
+	Metric   MetricInfo // The metric the problem was found in, or the zero value for a problem with the whole query
+	Message  string     // A human readable description of the problem
+}
+
+// fillOrRollupPattern matches an explicit .fill() or .rollup() call on a metric.
+//
+//nolint:gochecknoglobals
+var fillOrRollupPattern = regexp.MustCompile(`\.(?:fill|rollup)\(`)
+
+// metricFiltersPattern matches a metric's tag filter, and its group by if it has one.
+//
+//nolint:gochecknoglobals
+var metricFiltersPattern = regexp.MustCompile(`\{([^}]*)\}(\s*by\s*\{([^}]*)\})?`)
+
+// placeholderPattern matches the key or value of a tag that was clearly meant to be filled in, like `env:<env>`,
+// `service:TODO`, or a dashboard template variable like `$env`, which doesn't mean anything outside of a dashboard.
+//
+//nolint:gochecknoglobals
+var placeholderPattern = regexp.MustCompile(`(?i)<[^>]*>|^\$\w+$|^(?:todo|fixme|xxx|changeme|placeholder)$`)
+
+// tagInPattern matches a tag filter on any of a list of values, e.g. `env IN (prod, staging)`, capturing the key.
+//
+//nolint:gochecknoglobals
+var tagInPattern = regexp.MustCompile(`^(\S+)\s+(?i:in)\s*\(`)
+
+// countModifierPattern matches the .as_count() and .as_rate() modifiers on a metric.
+//
+//nolint:gochecknoglobals
+var countModifierPattern = regexp.MustCompile(`\.as_(count|rate)\(\)`)
+
+// Lint runs the enabled static rules over a parsed query: the built-in ones, and any added with RegisterRule. No API
+// calls are made, so this is cheap and works without any credentials. Comma separated queries are separate series, so
+// like the Validator, the rules check each of them on its own.
+func Lint(analysis QueryAnalysis, rules Rules) []Finding {
+	var findings []Finding
+
+	queries := analysis.subAnalyses()
+
+	for _, registered := range registry {
+		severity := rules[registered.id]
+		if severity == SeverityOff {
+			continue
+		}
+
+		for i := range queries {
+			for _, finding := range registered.rule.Check(&queries[i]) {
+				finding.Rule = registered.id
+				finding.Severity = severity
+				findings = append(findings, finding)
+			}
+		}
+	}
+
+	return findings
+}
+
+// The require-fill rule.
+func requireFill(analysis *QueryAnalysis) []Finding {
+	var findings []Finding
+
+	for _, metric := range analysis.Metrics {
+		if fillOrRollupPattern.MatchString(metric.CleanMetric) {
+			continue
+		}
+
+		findings = append(findings, Finding{
+			Metric:  metric,
+			Message: "Metric doesn't specify an explicit .fill() or .rollup()",
+		})
+	}
+
+	return findings
+}
+
+// The redundant-default-zero rule.
+func redundantDefaultZero(analysis *QueryAnalysis) []Finding {
+	var findings []Finding
+
+	for _, metric := range analysis.Metrics {
+		if metric.DefaultZeroNesting <= 1 {
+			continue
+		}
+
+		findings = append(findings, Finding{
+			Metric: metric,
+			Message: fmt.Sprintf("Metric is wrapped in default_zero() %d times, once is enough: %s",
+				metric.DefaultZeroNesting, withSingleDefaultZero(metric)),
+		})
+	}
+
+	return findings
+}
+
+// The suspicious-tag-filter rule.
+func suspiciousTagFilter(analysis *QueryAnalysis) []Finding {
+	var findings []Finding
+
+	for _, metric := range analysis.Metrics {
+		for _, message := range suspiciousTagFilters(metric) {
+			findings = append(findings, Finding{Metric: metric, Message: message})
+		}
+	}
+
+	return findings
+}
+
+// The mixed-aggregation rule.
+func mixedAggregationRule(analysis *QueryAnalysis) []Finding {
+	var findings []Finding
+
+	for i, metric := range analysis.Metrics {
+		message := mixedAggregation(analysis.Metrics[:i], metric)
+		if message == "" {
+			continue
+		}
+
+		findings = append(findings, Finding{Metric: metric, Message: message})
+	}
+
+	return findings
+}
+
+// The no-metrics-extracted rule. Without any metrics, the query is either broken, or uses syntax the parser doesn't
+// understand; either way, the metrics in it can't be validated on their own.
+func noMetricsExtracted(analysis *QueryAnalysis) []Finding {
+	if len(analysis.Metrics) > 0 || strings.TrimSpace(analysis.Query) == "" {
+		return nil
+	}
+
+	return []Finding{{Message: "No metrics could be found in the query, it might be malformed"}}
+}
+
+// LintRequiredTags runs the required-tags rule, which needs the list of tag keys every metric has to filter by, e.g.
+// `env` and `service`. A metric filtered by `{*}` doesn't filter by any of them. A negated tag, like `!env:prod`,
+// doesn't count, since it still matches every other env.
+func LintRequiredTags(analysis QueryAnalysis, rules Rules, keys []string) []Finding {
+	severity := rules[RuleRequiredTags]
+	if severity == SeverityOff || len(keys) == 0 {
+		return nil
+	}
+
+	var findings []Finding
+
+	for _, metric := range analysis.Metrics {
+		filtered := filteredTagKeys(metric)
+
+		var missing []string
+
+		for _, key := range keys {
+			if _, ok := filtered[key]; !ok {
+				missing = append(missing, key)
+			}
+		}
+
+		if len(missing) == 0 {
+			continue
+		}
+
+		findings = append(findings, Finding{
+			Rule:     RuleRequiredTags,
+			Severity: severity,
+			Metric:   metric,
+			Message:  fmt.Sprintf("Metric doesn't filter by the required tag(s): %s", strings.Join(missing, ", ")),
+		})
+	}
+
+	return findings
+}
+
+// LintResult runs the enabled rules that need the API's response for the query. A result with an error from the API
+// has nothing to check, so it has no findings.
+func LintResult(result Result, rules Rules, maxSeries int) []Finding {
+	var findings []Finding
+
+	if severity := rules[RuleSeriesCount]; severity != SeverityOff {
+		var message string
+
+		switch {
+		case result.Series == 0:
+			message = "Query didn't match any series, check its metric name and tag filter"
+		case result.Series > maxSeries:
+			message = fmt.Sprintf("Query matched %d series, more than the maximum of %d; check its tag filter and "+
+				"group by", result.Series, maxSeries)
+		}
+
+		if message != "" {
+			findings = append(findings, Finding{Rule: RuleSeriesCount, Severity: severity, Message: message})
+		}
+	}
+
+	if severity := rules[RuleMaskedInComplexQuery]; severity != SeverityOff {
+		for _, metric := range result.Metrics {
+			if !MaskedInComplexQuery(result, metric) {
+				continue
+			}
+
+			message := fmt.Sprintf("Metric %s returned no data, but %s hides that, and the rest of the query validates",
+				metric.Metric.CleanMetric, strings.Join(metric.Metric.MaskingFunctions, ", "))
+			if metric.Err != nil {
+				message = fmt.Sprintf("Metric %s failed, but %s hides that, and the rest of the query validates: %v",
+					metric.Metric.CleanMetric, strings.Join(metric.Metric.MaskingFunctions, ", "), metric.Err)
+			}
+
+			findings = append(findings, Finding{
+				Rule:     RuleMaskedInComplexQuery,
+				Severity: severity,
+				Metric:   metric.Metric,
+				Message:  message,
+			})
+		}
+	}
+
+	if severity := rules[RuleDeprecatedMetric]; severity != SeverityOff {
+		for _, metric := range result.Metrics {
+			if metric.Deprecated == "" {
+				continue
+			}
+
+			findings = append(findings, Finding{
+				Rule:     RuleDeprecatedMetric,
+				Severity: severity,
+				Metric:   metric.Metric,
+				Message:  fmt.Sprintf("Metric %s is marked as deprecated: %s", MetricName(metric.Metric), metric.Deprecated),
+			})
+		}
+	}
+
+	return findings
+}
+
+// MaskedInComplexQuery reports whether the metric is the case the masked-in-complex-query rule is about: a metric that's
+// only part of the query, wrapped in a masking function like default_zero(), that returned no data or failed, while the
+// query as a whole validated. A masked metric that makes up the whole query isn't, and neither is one whose API call was
+// cut short, or failed with a -benign-error, since those say nothing about the metric.
+func MaskedInComplexQuery(result Result, metric MetricResult) bool {
+	if !metric.Metric.IsMasked() || len(result.Analysis.Metrics) < 2 || result.Analysis.MakesUpQuery(metric.Metric) {
+		return false
+	}
+
+	switch metric.Status {
+	case StatusMasked:
+		return true
+	case StatusError:
+		var mqe *MetricQueryError
+
+		return !errors.As(metric.Err, &mqe) || (!mqe.Kind.Interrupted() && mqe.Kind != KindBenign)
+	default:
+		return false
+	}
+}
+
+// Rebuild the metric with only its outermost default_zero() call, keeping any other wrapper functions where they were.
+func withSingleDefaultZero(metric MetricInfo) string {
+	expr, calls := unwrapFunctions(metric.Metric)
+
+	outermost := -1
+
+	for i, call := range calls {
+		if call.name == defaultZero {
+			outermost = i
+
+			break
+		}
+	}
+
+	for i := len(calls) - 1; i >= 0; i-- {
+		if calls[i].name == defaultZero && i != outermost {
+			continue
+		}
+
+		args := []string{expr}
+		for _, arg := range calls[i].args {
+			args = append(args, strings.TrimSpace(arg))
+		}
+
+		expr = fmt.Sprintf("%s(%s)", calls[i].name, strings.Join(args, ", "))
+	}
+
+	return expr
+}
+
+// Check a metric's tag filter and group by for mistakes the API happily accepts.
+func suspiciousTagFilters(metric MetricInfo) []string {
+	match := metricFiltersPattern.FindStringSubmatch(metric.CleanMetric)
+	if match == nil {
+		return nil
+	}
+
+	var messages []string
+
+	filter := strings.TrimSpace(match[1])
+
+	if filter == "" {
+		messages = append(messages, "Tag filter is empty, so it matches everything; use {*} if that's intended")
+	}
+
+	for _, tag := range strings.Split(filter, ",") {
+		if isPlaceholder(tag) {
+			messages = append(messages, fmt.Sprintf("Tag %q looks like a placeholder that was never filled in",
+				strings.TrimSpace(tag)))
+		}
+	}
+
+	if match[2] != "" && strings.TrimSpace(match[3]) == "" {
+		messages = append(messages, "Group by is empty, so it doesn't group by anything; remove it, or add the tags")
+	}
+
+	return messages
+}
+
+// The keys of the tags the metric's tag filter scopes it to, e.g. `env` and `service` for `{env:prod,service:web}`, or
+// `env` for `{env IN (prod, staging)}`. Negated tags, and bare tags without a key, aren't included.
+func filteredTagKeys(metric MetricInfo) map[string]struct{} {
+	keys := map[string]struct{}{}
+
+	match := metricFiltersPattern.FindStringSubmatch(metric.CleanMetric)
+	if match == nil {
+		return keys
+	}
+
+	for _, tag := range splitArgs(match[1]) {
+		tag = strings.TrimSpace(tag)
+
+		if strings.HasPrefix(tag, "!") || strings.HasPrefix(tag, "-") {
+			continue
+		}
+
+		if key, _, found := strings.Cut(tag, ":"); found {
+			keys[strings.TrimSpace(key)] = struct{}{}
+
+			continue
+		}
+
+		if in := tagInPattern.FindStringSubmatch(tag); in != nil {
+			keys[in[1]] = struct{}{}
+		}
+	}
+
+	return keys
+}
+
+func isPlaceholder(tag string) bool {
+	key, value, _ := strings.Cut(strings.TrimLeft(strings.TrimSpace(tag), "!-"), ":")
+
+	return placeholderPattern.MatchString(strings.TrimSpace(key)) || placeholderPattern.MatchString(strings.TrimSpace(value))
+}
+
+// Check whether a metric is in a different aggregation space from any of the metrics before it in the query: a count
+// of events combined with an average of gauge values, or a metric modified with .as_count() combined with one modified
+// with .as_rate(). This is a heuristic, it assumes metrics in the same query are combined by arithmetic. Only the first
+// clash is described.
+func mixedAggregation(previous []MetricInfo, metric MetricInfo) string {
+	aggregator := metricAggregator(metric)
+	modifier := countModifier(metric)
+
+	for _, other := range previous {
+		if otherAggregator := metricAggregator(other); aggregatorsClash(aggregator, otherAggregator) {
+			return fmt.Sprintf("Metric uses the %s: aggregator, but is combined with %s, which uses %s:; "+
+				"the result probably doesn't mean what it looks like", aggregator, other.CleanMetric, otherAggregator)
+		}
+
+		if otherModifier := countModifier(other); modifier != "" && otherModifier != "" && modifier != otherModifier {
+			return fmt.Sprintf("Metric is modified with .as_%s(), but is combined with %s, which is modified with "+
+				".as_%s(); convert both to the same one", modifier, other.CleanMetric, otherModifier)
+		}
+	}
+
+	return ""
+}
+
+// The aggregator at the start of the metric, e.g. `avg`.
+func metricAggregator(metric MetricInfo) string {
+	aggregator, _, _ := strings.Cut(metric.CleanMetric, ":")
+
+	return aggregator
+}
+
+// The metric's count modifier, `count` for .as_count() or `rate` for .as_rate(), or an empty string if it has neither.
+func countModifier(metric MetricInfo) string {
+	match := countModifierPattern.FindStringSubmatch(metric.CleanMetric)
+	if match == nil {
+		return ""
+	}
+
+	return match[1]
+}
+
+// Whether the metric counts events, with the count aggregator, or .as_count() or .as_rate(), so an interval without any
+// is a real 0.
+func countsEvents(metric MetricInfo) bool {
+	return metricAggregator(metric) == "count" || countModifier(metric) != ""
+}
+
+// A count of events only combines sensibly with other totals, i.e. sums; not with an average, min or max of values.
+func aggregatorsClash(a, b string) bool {
+	if a == b || (a != "count" && b != "count") {
+		return false
+	}
+
+	return a != "sum" && b != "sum"
+}
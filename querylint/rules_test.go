@@ -0,0 +1,323 @@
+package querylint
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseSeverity(t *testing.T) {
+	t.Run("valid severities parse", func(t *testing.T) {
+		for input, expected := range map[string]Severity{"off": SeverityOff, "WARN": SeverityWarn, "error": SeverityError} {
+			severity, err := ParseSeverity(input)
+			if err != nil {
+				t.Fatalf("Expected no error for %q, got %v", input, err)
+			}
+
+			if severity != expected {
+				t.Errorf("Expected %q to parse as %s, got %s", input, expected, severity)
+			}
+		}
+	})
+
+	t.Run("invalid severities error", func(t *testing.T) {
+		if _, err := ParseSeverity("fatal"); err == nil {
+			t.Fatalf("Expected an error but didn't receive one.")
+		}
+	})
+}
+
+func TestRequireFillRule(t *testing.T) {
+	query := "default_zero(avg:foo{*}.fill(null)) + sum:bar{*}.rollup(sum, 60) - max:baz{*}"
+
+	t.Run("flags metrics without fill or rollup", func(t *testing.T) {
+		findings := Lint(ParseQuery(query), Rules{RuleRequireFill: SeverityWarn})
+
+		if len(findings) != 1 {
+			t.Fatalf("Expected 1 finding, got %d", len(findings))
+		}
+
+		if findings[0].Metric.CleanMetric != "max:baz{*}" {
+			t.Errorf("Expected the finding to be for %q, got %q", "max:baz{*}", findings[0].Metric.CleanMetric)
+		}
+
+		if findings[0].Rule != RuleRequireFill || findings[0].Severity != SeverityWarn {
+			t.Errorf("Expected a %s warning, got %s %s", RuleRequireFill, findings[0].Rule, findings[0].Severity)
+		}
+	})
+
+	t.Run("does nothing when off", func(t *testing.T) {
+		if findings := Lint(ParseQuery(query), Rules{}); len(findings) != 0 {
+			t.Errorf("Expected no findings, got %d", len(findings))
+		}
+	})
+}
+
+func TestRedundantDefaultZeroRule(t *testing.T) {
+	tests := []struct {
+		query      string
+		suggestion string
+	}{
+		{"default_zero(default_zero(avg:foo{*}))", "default_zero(avg:foo{*})"},
+		{"default_zero( default_zero(default_zero(avg:foo{*})) )", "default_zero(avg:foo{*})"},
+		{"default_zero(clamp_min(default_zero(avg:foo{*}), 0))", "default_zero(clamp_min(avg:foo{*}, 0))"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			findings := Lint(ParseQuery(test.query), Rules{RuleRedundantDefaultZero: SeverityWarn})
+
+			if len(findings) != 1 {
+				t.Fatalf("Expected 1 finding, got %d", len(findings))
+			}
+
+			if !strings.HasSuffix(findings[0].Message, ": "+test.suggestion) {
+				t.Errorf("Expected the message to suggest %q, got %q", test.suggestion, findings[0].Message)
+			}
+		})
+	}
+
+	t.Run("a single default_zero is fine", func(t *testing.T) {
+		query := "default_zero(avg:foo{*}) + clamp_min(default_zero(avg:bar{*}), 0)"
+
+		if findings := Lint(ParseQuery(query), Rules{RuleRedundantDefaultZero: SeverityError}); len(findings) != 0 {
+			t.Errorf("Expected no findings, got %v", findings)
+		}
+	})
+}
+
+func TestNoMetricsExtractedRule(t *testing.T) {
+	rules := Rules{RuleNoMetricsExtracted: SeverityError}
+
+	t.Run("flags queries without any metrics", func(t *testing.T) {
+		findings := Lint(ParseQuery("avg:foo.bar + 1"), rules)
+
+		if len(findings) != 1 || findings[0].Rule != RuleNoMetricsExtracted {
+			t.Fatalf("Expected a %s finding, got %v", RuleNoMetricsExtracted, findings)
+		}
+
+		if findings[0].Metric.CleanMetric != "" {
+			t.Errorf("Expected the finding to be for the whole query, got %q", findings[0].Metric.CleanMetric)
+		}
+	})
+
+	t.Run("ignores queries with metrics", func(t *testing.T) {
+		if findings := Lint(ParseQuery("avg:foo.bar{*} + 1"), rules); len(findings) != 0 {
+			t.Errorf("Expected no findings, got %v", findings)
+		}
+	})
+}
+
+func TestSuspiciousTagFilterRule(t *testing.T) {
+	rules := Rules{RuleSuspiciousTagFilter: SeverityWarn}
+
+	tests := []struct {
+		query   string
+		message string
+	}{
+		{"avg:foo{}", "Tag filter is empty"},
+		{"avg:foo{*} by {}", "Group by is empty"},
+		{"avg:foo{env:<env>,service:web}", `Tag "env:<env>" looks like a placeholder`},
+		{"avg:foo{env:prod,service:TODO}", `Tag "service:TODO" looks like a placeholder`},
+		{"avg:foo{env:$env}", `Tag "env:$env" looks like a placeholder`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			findings := Lint(ParseQuery(test.query), rules)
+
+			if len(findings) != 1 || !strings.HasPrefix(findings[0].Message, test.message) {
+				t.Errorf("Expected a finding starting with %q, got %v", test.message, findings)
+			}
+		})
+	}
+
+	t.Run("ordinary filters are fine", func(t *testing.T) {
+		query := "avg:foo{*} by {host} + sum:bar{env:prod,!service:todo-list} by {env,service}"
+
+		if findings := Lint(ParseQuery(query), rules); len(findings) != 0 {
+			t.Errorf("Expected no findings, got %v", findings)
+		}
+	})
+}
+
+func TestMixedAggregationRule(t *testing.T) {
+	rules := Rules{RuleMixedAggregation: SeverityWarn}
+
+	tests := []struct {
+		query   string
+		message string
+	}{
+		{"count:foo.errors{*} / avg:foo.requests{*}", "Metric uses the avg: aggregator, but is combined with count:foo.errors{*}"},
+		{"max:foo{*} - count:bar{*}", "Metric uses the count: aggregator, but is combined with max:foo{*}"},
+		{"sum:foo{*}.as_count() / sum:bar{*}.as_rate()", "Metric is modified with .as_rate(), but is combined with sum:foo{*}.as_count()"},
+		{"avg:foo{*}, count:foo.errors{*} / avg:foo.requests{*}", "Metric uses the avg: aggregator, but is combined with count:foo.errors{*}"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			findings := Lint(ParseQuery(test.query), rules)
+
+			if len(findings) != 1 || !strings.HasPrefix(findings[0].Message, test.message) {
+				t.Errorf("Expected a finding starting with %q, got %v", test.message, findings)
+			}
+		})
+	}
+
+	compatible := []string{
+		"sum:foo.errors{*}.as_count() / sum:foo.requests{*}.as_count()",
+		"count:foo{*} / sum:bar{*}",
+		"avg:foo{*} + max:bar{*}",
+		"sum:foo{*}.as_count() + avg:bar{*}",
+		"count:foo{*}",
+		"count:a.x{*}, avg:b.gauge{*}",
+		"sum:a{*}.as_count(), sum:b{*}.as_rate()",
+	}
+
+	for _, query := range compatible {
+		t.Run(query, func(t *testing.T) {
+			if findings := Lint(ParseQuery(query), rules); len(findings) != 0 {
+				t.Errorf("Expected no findings, got %v", findings)
+			}
+		})
+	}
+}
+
+func TestSeriesCountRule(t *testing.T) {
+	rules := Rules{RuleSeriesCount: SeverityWarn}
+
+	tests := []struct {
+		series  int
+		message string
+	}{
+		{0, "Query didn't match any series"},
+		{1, ""},
+		{10, ""},
+		{11, "Query matched 11 series, more than the maximum of 10"},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d series", test.series), func(t *testing.T) {
+			findings := LintResult(Result{Series: test.series}, rules, 10)
+
+			switch {
+			case test.message == "" && len(findings) != 0:
+				t.Errorf("Expected no findings, got %v", findings)
+			case test.message != "" && (len(findings) != 1 || !strings.HasPrefix(findings[0].Message, test.message)):
+				t.Errorf("Expected a finding starting with %q, got %v", test.message, findings)
+			}
+		})
+	}
+
+	if findings := LintResult(Result{}, Rules{}, 10); len(findings) != 0 {
+		t.Errorf("Expected no findings with the rule off, got %v", findings)
+	}
+}
+
+func TestDeprecatedMetricRule(t *testing.T) {
+	rules := Rules{RuleDeprecatedMetric: SeverityWarn}
+
+	result := Result{Series: 1, Metrics: []MetricResult{
+		{Metric: ParseQuery("avg:old.metric{*}").Metrics[0], Deprecated: "Deprecated, use new.metric"},
+		{Metric: ParseQuery("avg:new.metric{*}").Metrics[0]},
+	}}
+
+	findings := LintResult(result, rules, DefaultMaxSeries)
+	if len(findings) != 1 {
+		t.Fatalf("Expected 1 finding, got %v", findings)
+	}
+
+	expected := "Metric old.metric is marked as deprecated: Deprecated, use new.metric"
+	if findings[0].Message != expected || findings[0].Metric.CleanMetric != "avg:old.metric{*}" {
+		t.Errorf("Expected %q for avg:old.metric{*}, got %+v", expected, findings[0])
+	}
+
+	if findings := LintResult(result, Rules{}, DefaultMaxSeries); len(findings) != 0 {
+		t.Errorf("Expected no findings with the rule off, got %v", findings)
+	}
+}
+
+func TestMaskedInComplexQueryRule(t *testing.T) {
+	rules := Rules{RuleMaskedInComplexQuery: SeverityWarn}
+	value := 1.0
+
+	analysis := ParseQuery("avg:foo{*} + default_zero(avg:bar{*}) + default_zero(avg:baz{*}) + default_zero(avg:qux{*})")
+	result := Result{Query: analysis.Query, Analysis: analysis, Series: 1, Value: &value, Metrics: []MetricResult{
+		{Metric: analysis.Metrics[0], Status: StatusNoData},
+		{Metric: analysis.Metrics[1], Status: StatusMasked},
+		{Metric: analysis.Metrics[2], Status: StatusError, Err: &MetricQueryError{Kind: KindBadQuery}},
+		{Metric: analysis.Metrics[3], Status: StatusError, Err: &MetricQueryError{Kind: KindTimeout}},
+	}}
+
+	findings := LintResult(result, rules, DefaultMaxSeries)
+	if len(findings) != 2 {
+		t.Fatalf("Expected 2 findings, got %v", findings)
+	}
+
+	for i, expected := range []string{"avg:bar{*}", "avg:baz{*}"} {
+		if findings[i].Rule != RuleMaskedInComplexQuery || findings[i].Metric.CleanMetric != expected {
+			t.Errorf("Expected a finding for %s, got %+v", expected, findings[i])
+		}
+	}
+
+	t.Run("a masked metric that makes up the whole query isn't flagged", func(t *testing.T) {
+		single := ParseQuery("default_zero(avg:foo{*})")
+		result := Result{Query: single.Query, Analysis: single, Series: 1, Value: &value, Metrics: []MetricResult{
+			{Metric: single.Metrics[0], Status: StatusMasked},
+		}}
+
+		if findings := LintResult(result, rules, DefaultMaxSeries); len(findings) != 0 {
+			t.Errorf("Expected no findings, got %v", findings)
+		}
+	})
+
+	if findings := LintResult(result, Rules{}, DefaultMaxSeries); len(findings) != 0 {
+		t.Errorf("Expected no findings with the rule off, got %v", findings)
+	}
+}
+
+func TestRequiredTagsRule(t *testing.T) {
+	rules := Rules{RuleRequiredTags: SeverityError}
+	keys := []string{"env", "service"}
+
+	tests := []struct {
+		query   string
+		message string
+	}{
+		{"avg:foo{env:prod,service:web}", ""},
+		{"avg:foo{service:web, env IN (prod, staging)} by {host}", ""},
+		{"avg:foo{*}", "Metric doesn't filter by the required tag(s): env, service"},
+		{"avg:foo{env:prod}", "Metric doesn't filter by the required tag(s): service"},
+		{"avg:foo{!env:prod,service:web}", "Metric doesn't filter by the required tag(s): env"},
+		{"avg:foo{env NOT IN (prod),service:web}", "Metric doesn't filter by the required tag(s): env"},
+		{"avg:foo{service:web} by {env}", "Metric doesn't filter by the required tag(s): env"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			findings := LintRequiredTags(ParseQuery(test.query), rules, keys)
+
+			switch {
+			case test.message == "" && len(findings) != 0:
+				t.Errorf("Expected no findings, got %v", findings)
+			case test.message != "" && (len(findings) != 1 || findings[0].Message != test.message):
+				t.Errorf("Expected a finding of %q, got %v", test.message, findings)
+			}
+		})
+	}
+
+	t.Run("each metric is checked", func(t *testing.T) {
+		findings := LintRequiredTags(ParseQuery("avg:foo{env:prod,service:web} / default_zero(avg:bar{*})"), rules, keys)
+		if len(findings) != 1 || findings[0].Metric.CleanMetric != "avg:bar{*}" {
+			t.Errorf("Expected a finding for avg:bar{*}, got %v", findings)
+		}
+	})
+
+	if findings := LintRequiredTags(ParseQuery("avg:foo{*}"), rules, nil); len(findings) != 0 {
+		t.Errorf("Expected no findings without any required tags, got %v", findings)
+	}
+
+	if findings := LintRequiredTags(ParseQuery("avg:foo{*}"), Rules{}, keys); len(findings) != 0 {
+		t.Errorf("Expected no findings with the rule off, got %v", findings)
+	}
+}
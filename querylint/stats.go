@@ -0,0 +1,67 @@
+package querylint
+
+import (
+	"math"
+	"sort"
+)
+
+// SeriesStats summarizes every datapoint in a series over the window, not just the latest. A metric that's null for
+// most of the window with a single spike looks the same as a healthy one from its latest value alone.
+type SeriesStats struct {
+	Points  int     // How many datapoints the series has in the window, including nulls
+	NonNull int     // How many of those aren't null; the rest of the fields only cover these
+	Min     float64 // The smallest value
+	Max     float64 // The largest value
+	Mean    float64 // The average value
+	P50     float64 // The median value
+	P95     float64 // The 95th percentile value
+	Latest  float64 // The value of the latest non-null datapoint
+}
+
+// Summarize a series' datapoints, each a [timestamp, value] pair. Returns nil if every value is null, since there's
+// nothing to summarize.
+func seriesStats(pointlist [][]*float64) *SeriesStats {
+	values := make([]float64, 0, len(pointlist))
+
+	for _, point := range pointlist {
+		if len(point) < 2 || point[1] == nil {
+			continue
+		}
+
+		values = append(values, *point[1])
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	stats := &SeriesStats{
+		Points:  len(pointlist),
+		NonNull: len(values),
+		Latest:  values[len(values)-1],
+	}
+
+	sum := 0.0
+
+	for _, value := range values {
+		sum += value
+	}
+
+	stats.Mean = sum / float64(len(values))
+
+	sort.Float64s(values)
+
+	stats.Min = values[0]
+	stats.Max = values[len(values)-1]
+	stats.P50 = percentile(values, 50)
+	stats.P95 = percentile(values, 95)
+
+	return stats
+}
+
+// The nearest-rank percentile of the sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted)))) //nolint:mnd
+
+	return sorted[max(rank, 1)-1]
+}
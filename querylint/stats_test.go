@@ -0,0 +1,42 @@
+package querylint
+
+import (
+	"testing"
+)
+
+func TestSeriesStats(t *testing.T) {
+	point := func(value float64) []*float64 {
+		timestamp := 1700000000000.0
+
+		return []*float64{&timestamp, &value}
+	}
+
+	t.Run("summarizes the non-null values", func(t *testing.T) {
+		pointlist := [][]*float64{point(4), {nil, nil}, point(1), point(3), point(2), {nil, nil}}
+
+		stats := seriesStats(pointlist)
+		if stats == nil {
+			t.Fatalf("Expected stats, got nil")
+		}
+
+		expected := SeriesStats{Points: 6, NonNull: 4, Min: 1, Max: 4, Mean: 2.5, P50: 2, P95: 4, Latest: 2}
+		if *stats != expected {
+			t.Errorf("Expected %+v, got %+v", expected, *stats)
+		}
+	})
+
+	t.Run("a mostly null series with a spike", func(t *testing.T) {
+		pointlist := [][]*float64{{nil, nil}, {nil, nil}, {nil, nil}, point(100)}
+
+		stats := seriesStats(pointlist)
+		if stats == nil || stats.NonNull != 1 || stats.Points != 4 || stats.P50 != 100 {
+			t.Errorf("Expected 1 of 4 points with a median of 100, got %+v", stats)
+		}
+	})
+
+	t.Run("all null is nil", func(t *testing.T) {
+		if stats := seriesStats([][]*float64{{nil, nil}}); stats != nil {
+			t.Errorf("Expected nil, got %+v", stats)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package querylint
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseError is a problem found while statically parsing a query, before it's ever sent to the API.
+type ParseError struct {
+	Pos     int    // Byte offset in the query where the problem is
+	Message string // A human readable description of the problem
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Pos, e.Message)
+}
+
+// Check the syntax of every `{...}` tag filter (and `by {...}` group) in the query: braces must be balanced, and each
+// comma separated tag must be a `key:value` pair or a bare tag.
+func validateTagFilters(query string) []ParseError {
+	var problems []ParseError
+
+	for pos := 0; pos < len(query); pos++ {
+		switch query[pos] {
+		case '}':
+			problems = append(problems, ParseError{Pos: pos, Message: "unbalanced '}' in tag filter"})
+		case '{':
+			end := strings.IndexAny(query[pos+1:], "{}")
+			if end == -1 || query[pos+1+end] == '{' {
+				problems = append(problems, ParseError{Pos: pos, Message: "unbalanced '{' in tag filter"})
+
+				continue
+			}
+
+			end += pos + 1
+			problems = append(problems, validateTags(query[pos+1:end], pos+1)...)
+			pos = end
+		}
+	}
+
+	return problems
+}
+
+// Check the comma separated tags inside a single tag filter. The offset is the position of the filter's contents in the
+// query, so the problems point at the right place.
+func validateTags(filter string, offset int) []ParseError {
+	if strings.TrimSpace(filter) == "" {
+		return nil
+	}
+
+	var problems []ParseError
+
+	depth := 0
+	start := 0
+
+	for i := range len(filter) {
+		switch filter[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				problems = appendTagProblem(problems, filter[start:i], offset+start)
+				start = i + 1
+			}
+		}
+	}
+
+	return appendTagProblem(problems, filter[start:], offset+start)
+}
+
+func appendTagProblem(problems []ParseError, tag string, pos int) []ParseError {
+	if problem := validateTag(tag, pos); problem != nil {
+		return append(problems, *problem)
+	}
+
+	return problems
+}
+
+func validateTag(tag string, pos int) *ParseError {
+	trimmed := strings.TrimSpace(tag)
+
+	switch {
+	case trimmed == "":
+		return &ParseError{Pos: pos, Message: "empty tag in tag filter, check for a stray comma"}
+	case strings.HasSuffix(trimmed, ":"):
+		return &ParseError{Pos: pos, Message: fmt.Sprintf("tag %q has no value", trimmed)}
+	case strings.HasPrefix(trimmed, ":"):
+		return &ParseError{Pos: pos, Message: fmt.Sprintf("tag %q has no key", trimmed)}
+	case strings.Contains(trimmed, "=") && !strings.Contains(trimmed, ":"):
+		return &ParseError{
+			Pos:     pos,
+			Message: fmt.Sprintf("tag %q uses '=' rather than ':' to separate the key and value", trimmed),
+		}
+	default:
+		return nil
+	}
+}
+
+// OverrideTags rewrites the value of every tag in the query's tag filters whose key is in overrides, e.g. with
+// `env: staging`, `avg:foo{env:production,service:web}` becomes `avg:foo{env:staging,service:web}`. Negated tags stay
+// negated. Tags that aren't in overrides, and the rest of the query, are left as they are.
+func OverrideTags(query string, overrides map[string]string) string {
+	if len(overrides) == 0 {
+		return query
+	}
+
+	var b strings.Builder
+
+	for {
+		start := strings.IndexByte(query, '{')
+		if start == -1 {
+			break
+		}
+
+		end := strings.IndexByte(query[start:], '}')
+		if end == -1 {
+			break
+		}
+
+		end += start
+
+		b.WriteString(query[:start+1])
+		b.WriteString(overrideFilterTags(query[start+1:end], overrides))
+
+		query = query[end:]
+	}
+
+	b.WriteString(query)
+
+	return b.String()
+}
+
+// Rewrite the comma separated tags inside a single tag filter, for OverrideTags.
+func overrideFilterTags(filter string, overrides map[string]string) string {
+	tags := strings.Split(filter, ",")
+
+	for i, tag := range tags {
+		trimmed := strings.TrimSpace(tag)
+		negation := ""
+
+		if strings.HasPrefix(trimmed, "!") || strings.HasPrefix(trimmed, "-") {
+			negation, trimmed = trimmed[:1], trimmed[1:]
+		}
+
+		key, _, found := strings.Cut(trimmed, ":")
+		if !found {
+			continue
+		}
+
+		value, ok := overrides[key]
+		if !ok {
+			continue
+		}
+
+		// Keep the whitespace around the tag, so only the value changes.
+		leading := tag[:len(tag)-len(strings.TrimLeft(tag, " \t\n"))]
+		trailing := tag[len(strings.TrimRight(tag, " \t\n")):]
+		tags[i] = fmt.Sprintf("%s%s%s:%s%s", leading, negation, key, value, trailing)
+	}
+
+	return strings.Join(tags, ",")
+}
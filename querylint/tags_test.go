@@ -0,0 +1,81 @@
+package querylint
+
+import (
+	"testing"
+)
+
+func TestTagFilterValidation(t *testing.T) {
+	t.Run("valid filters have no problems", func(t *testing.T) {
+		queries := []string{
+			"avg:foo{*}",
+			"avg:foo{}",
+			"avg:foo{env:production,service:web} by {host,region}",
+			"avg:foo{production,!service:web,-region:us-east-1}",
+			"sum:foo{env IN (prod, staging)}.as_count() / sum:bar{env:prod}.as_count()",
+			"avg:foo{url:https://example.com/a=b}",
+		}
+
+		for _, query := range queries {
+			if problems := ParseQuery(query).Problems; len(problems) != 0 {
+				t.Errorf("Expected no problems for %q, got %v", query, problems)
+			}
+		}
+	})
+
+	tests := []struct {
+		name  string
+		query string
+		pos   int
+	}{
+		{"unclosed brace", "avg:foo{env:prod", 7},
+		{"unopened brace", "avg:foo env:prod}", 16},
+		{"nested brace", "avg:foo{env:{prod}", 7},
+		{"trailing comma", "avg:foo{env:prod,}", 17},
+		{"double comma", "avg:foo{env:prod,,service:web}", 17},
+		{"key without a value", "avg:foo{env:prod,service:}", 17},
+		{"value without a key", "avg:foo{:prod}", 8},
+		{"equals rather than colon", "avg:foo{env=prod}", 8},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problems := ParseQuery(test.query).Problems
+
+			if len(problems) != 1 {
+				t.Fatalf("Expected 1 problem for %q, got %v", test.query, problems)
+			}
+
+			if problems[0].Pos != test.pos {
+				t.Errorf("Expected the problem at position %d, got %d (%s)", test.pos, problems[0].Pos, problems[0].Message)
+			}
+		})
+	}
+}
+
+func TestOverrideTags(t *testing.T) {
+	overrides := map[string]string{"env": "staging", "region": "eu-west-1"}
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"avg:foo{env:production}", "avg:foo{env:staging}"},
+		{"avg:foo{service:web, env:production} by {host}", "avg:foo{service:web, env:staging} by {host}"},
+		{"avg:foo{!env:production,-region:us-east-1}", "avg:foo{!env:staging,-region:eu-west-1}"},
+		{"avg:foo{env:production} / avg:bar{env:prod,envoy:x}", "avg:foo{env:staging} / avg:bar{env:staging,envoy:x}"},
+		{"avg:foo{environment:production,env}", "avg:foo{environment:production,env}"},
+		{"avg:foo{env IN (prod, staging)}", "avg:foo{env IN (prod, staging)}"},
+		{"avg:foo{*}", "avg:foo{*}"},
+		{"avg:foo{env:production", "avg:foo{env:production"},
+	}
+
+	for _, test := range tests {
+		if actual := OverrideTags(test.query, overrides); actual != test.expected {
+			t.Errorf("Expected %q for %q, got %q", test.expected, test.query, actual)
+		}
+	}
+
+	if actual := OverrideTags("avg:foo{env:production}", nil); actual != "avg:foo{env:production}" {
+		t.Errorf("Expected the query to be unchanged without overrides, got %q", actual)
+	}
+}
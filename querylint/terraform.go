@@ -0,0 +1,204 @@
+package querylint
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TerraformQuery is a metric query found in a Datadog Terraform resource.
+type TerraformQuery struct {
+	Resource string // Where the query is, e.g. `datadog_monitor.cpu`, or `datadog_service_level_objective.api.numerator`
+	Line     int    // The line the query's attribute is on, starting from 1
+	Query    string // The query, unescaped
+}
+
+// terraformQueryAttributes are the attributes holding a metric query in each of the Datadog provider's resources. An
+// SLO's numerator and denominator are inside a nested `query` block, but the attribute names are unique enough that the
+// block doesn't need tracking.
+//
+//nolint:gochecknoglobals
+var terraformQueryAttributes = map[string][]string{
+	"datadog_monitor":                 {"query"},
+	"datadog_service_level_objective": {"numerator", "denominator"},
+}
+
+//nolint:gochecknoglobals
+var (
+	terraformResourcePattern  = regexp.MustCompile(`^\s*resource\s+"([^"]+)"\s+"([^"]+)"\s*\{`)
+	terraformAttributePattern = regexp.MustCompile(`^\s*([a-z_]+)\s*=\s*(.*)$`)
+)
+
+// ExtractTerraformQueries finds the metric queries in the Datadog resources of a Terraform file, like a monitor's
+// `query`. Both quoted strings and heredocs are supported; queries built with interpolation are returned as is, `${...}`
+// and all. The filePath is only used in error messages.
+func ExtractTerraformQueries(data []byte, filePath string) ([]TerraformQuery, error) {
+	if isBinary(data) {
+		return nil, errors.Wrap(ErrBinaryFile, fmt.Sprintf("Failed to parse terraform: %s", filePath))
+	}
+
+	var queries []TerraformQuery
+
+	lines := strings.Split(string(data), "\n")
+	depth := 0
+	resource := ""
+	attributes := []string(nil)
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		// Every top level block resets which attributes hold queries, so a `data` or `locals` block doesn't pick up
+		// the previous resource's.
+		if depth == 0 {
+			resource, attributes = "", nil
+
+			if match := terraformResourcePattern.FindStringSubmatch(line); match != nil {
+				resource = match[1] + "." + match[2]
+				attributes = terraformQueryAttributes[match[1]]
+			}
+		}
+
+		if match := terraformAttributePattern.FindStringSubmatch(line); depth > 0 && match != nil &&
+			slices.Contains(attributes, match[1]) {
+			query, end, err := terraformString(lines, i, match[2])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", filePath, i+1, err)
+			}
+
+			name := resource
+			if match[1] != "query" {
+				name += "." + match[1]
+			}
+
+			queries = append(queries, TerraformQuery{Resource: name, Line: i + 1, Query: query})
+
+			// A heredoc spans several lines, none of which can open or close a block.
+			if end != i {
+				i = end
+
+				continue
+			}
+		}
+
+		depth += braceDepth(line)
+	}
+
+	return queries, nil
+}
+
+// Read the string value that starts an attribute, at lines[i]. A quoted string is on a single line, while a heredoc
+// carries on until its closing marker; the index of the last line of the value is returned too.
+func terraformString(lines []string, i int, value string) (string, int, error) {
+	value = strings.TrimSpace(value)
+
+	switch {
+	case strings.HasPrefix(value, `"`):
+		query, err := unquoteTerraform(value)
+
+		return query, i, err
+	case strings.HasPrefix(value, "<<"):
+		marker := strings.TrimPrefix(strings.TrimPrefix(value, "<<"), "-")
+		indented := strings.HasPrefix(value, "<<-")
+
+		for end := i + 1; end < len(lines); end++ {
+			if strings.TrimSpace(lines[end]) == marker {
+				body := lines[i+1 : end]
+				if indented {
+					body = dedent(body)
+				}
+
+				return strings.Join(body, "\n"), end, nil
+			}
+		}
+
+		return "", i, fmt.Errorf("heredoc %s is never closed", marker)
+	default:
+		return "", i, fmt.Errorf("query isn't a string literal: %s", value)
+	}
+}
+
+// Unquote a quoted HCL string, ignoring anything after the closing quote (like a comment).
+func unquoteTerraform(value string) (string, error) {
+	var b strings.Builder
+
+	for i := 1; i < len(value); i++ {
+		switch {
+		case value[i] == '"':
+			return b.String(), nil
+		case value[i] == '\\' && i+1 < len(value):
+			i++
+
+			switch value[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(value[i])
+			}
+		case strings.HasPrefix(value[i:], "$${"), strings.HasPrefix(value[i:], "%%{"):
+			// Escaped interpolation and template sequences.
+			b.WriteByte(value[i])
+			i++
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+
+	return "", fmt.Errorf("string is never closed: %s", value)
+}
+
+// Strip the indentation that every non-blank line shares, like a `<<-` heredoc does.
+func dedent(lines []string) []string {
+	indent := -1
+
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if width := len(line) - len(strings.TrimLeft(line, " \t")); indent == -1 || width < indent {
+			indent = width
+		}
+	}
+
+	dedented := make([]string, len(lines))
+
+	for i, line := range lines {
+		if len(line) >= indent && indent > 0 {
+			dedented[i] = line[indent:]
+		} else {
+			dedented[i] = strings.TrimLeft(line, " \t")
+		}
+	}
+
+	return dedented
+}
+
+// How much a line changes the block depth by, ignoring braces inside strings (tag filters, mostly) and comments.
+func braceDepth(line string) int {
+	change := 0
+	inString := false
+
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+			// Braces in a string, like a tag filter, don't count.
+		case c == '#' || strings.HasPrefix(line[i:], "//"):
+			return change
+		case c == '{':
+			change++
+		case c == '}':
+			change--
+		}
+	}
+
+	return change
+}
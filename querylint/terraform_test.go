@@ -0,0 +1,96 @@
+package querylint
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExtractTerraformQueries(t *testing.T) {
+	t.Run("monitors and SLOs", func(t *testing.T) {
+		data := []byte(`
+locals {
+  query = "avg:ignored{*}"
+}
+
+resource "datadog_monitor" "cpu" {
+  name  = "CPU is high {{host.name}}"
+  query = "avg(last_5m):avg:system.cpu.user{env:prod} by {host} > 90" # alert on sustained load
+
+  monitor_thresholds {
+    critical = 90
+  }
+}
+
+resource "datadog_service_level_objective" "api" {
+  name = "API availability"
+  type = "metric"
+
+  query {
+    numerator   = <<-EOT
+      sum:api.requests{status:ok}.as_count()
+    EOT
+    denominator = "sum:api.requests{*}.as_count()"
+  }
+}
+
+resource "datadog_dashboard" "overview" {
+  query = "avg:ignored{*}"
+}
+`)
+
+		queries, err := ExtractTerraformQueries(data, "main.tf")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		expected := []TerraformQuery{
+			{"datadog_monitor.cpu", 8, "avg(last_5m):avg:system.cpu.user{env:prod} by {host} > 90"},
+			{"datadog_service_level_objective.api.numerator", 20, "sum:api.requests{status:ok}.as_count()"},
+			{"datadog_service_level_objective.api.denominator", 23, "sum:api.requests{*}.as_count()"},
+		}
+
+		if len(queries) != len(expected) {
+			t.Fatalf("Expected %d queries, got %d: %v", len(expected), len(queries), queries)
+		}
+
+		for i := range expected {
+			if queries[i] != expected[i] {
+				t.Errorf("Expected %+v, got %+v", expected[i], queries[i])
+			}
+		}
+	})
+
+	t.Run("escapes are unquoted", func(t *testing.T) {
+		data := []byte(`resource "datadog_monitor" "escaped" {
+  query = "avg(last_5m):avg:foo{service:\"web\"} + $${literal} > 1"
+}`)
+
+		queries, err := ExtractTerraformQueries(data, "main.tf")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		expected := `avg(last_5m):avg:foo{service:"web"} + ${literal} > 1`
+		if len(queries) != 1 || queries[0].Query != expected {
+			t.Errorf("Expected %q, got %v", expected, queries)
+		}
+	})
+
+	t.Run("unclosed heredocs are an error", func(t *testing.T) {
+		data := []byte(`resource "datadog_monitor" "broken" {
+  query = <<EOT
+avg:foo{*}
+}`)
+
+		if _, err := ExtractTerraformQueries(data, "main.tf"); err == nil {
+			t.Errorf("Expected an error but didn't receive one.")
+		}
+	})
+
+	t.Run("binary files are rejected", func(t *testing.T) {
+		_, err := ExtractTerraformQueries([]byte{0x00, 0x01}, "main.tf")
+		if !errors.Is(err, ErrBinaryFile) {
+			t.Errorf("Expected ErrBinaryFile, got %v", err)
+		}
+	})
+}
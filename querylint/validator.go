@@ -0,0 +1,766 @@
+package querylint
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
+	"github.com/pkg/errors"
+)
+
+// ErrorKind is the broad category of a MetricQueryError, for reporting and deciding whether a retry might help.
+type ErrorKind int
+
+const (
+	KindUnknown     ErrorKind = iota // Nothing more is known about the error
+	KindAuth                         // The API or app key is missing, invalid, or lacks permission
+	KindBadQuery                     // The query is malformed, or the API rejected it for some other reason
+	KindRateLimited                  // Too many requests; retrying later should work
+	KindServer                       // The API had an internal error; retrying later might work
+	KindNetwork                      // The API couldn't be reached at all
+	KindTimeout                      // The call ran out of time, e.g. a deadline on the context; an infra problem
+	KindCanceled                     // The call was cancelled before it finished, e.g. the run was interrupted
+	KindBenign                       // The API rejected the query with an error in Validator.BenignErrors
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindAuth:
+		return "auth"
+	case KindBadQuery:
+		return "bad_query"
+	case KindRateLimited:
+		return "rate_limited"
+	case KindServer:
+		return "server"
+	case KindNetwork:
+		return "network"
+	case KindTimeout:
+		return "timeout"
+	case KindCanceled:
+		return "canceled"
+	case KindBenign:
+		return "benign"
+	default:
+		return "unknown"
+	}
+}
+
+// Interrupted returns true if the call was cut short, by a timeout or cancellation, rather than failing. That says
+// nothing about the query, so it shouldn't count as the query failing to validate.
+func (k ErrorKind) Interrupted() bool {
+	return k == KindTimeout || k == KindCanceled
+}
+
+// MetricQueryError is returned when the Datadog API rejects a query, or can't be reached at all.
+type MetricQueryError struct {
+	HTTPResponse *http.Response // The HTTP resonse from the DD api
+	NestedError  error          // The error we're returning
+	Kind         ErrorKind      // The category of the error, from the HTTP status and the response
+	RequestID    string         // The ID Datadog assigned the request, for support tickets, or empty if there wasn't one
+}
+
+func (e *MetricQueryError) Error() string {
+	return fmt.Sprintf("Error: %s", e.NestedError)
+}
+
+// The response headers Datadog's request ID might be in, in order of preference.
+//
+//nolint:gochecknoglobals
+var requestIDHeaders = []string{"X-Request-Id", "X-Datadog-Request-Id", "Dd-Request-Id"}
+
+// The ID Datadog assigned the request, which their support asks for to find it in their logs. It's empty if the API
+// couldn't be reached, or didn't send one.
+func requestID(resp *http.Response) string {
+	if resp == nil {
+		return ""
+	}
+
+	for _, header := range requestIDHeaders {
+		if id := resp.Header.Get(header); id != "" {
+			return id
+		}
+	}
+
+	return ""
+}
+
+// errBatchUnsupported is returned when the series in a batched response can't be matched back up with the metrics in
+// the batch.
+var errBatchUnsupported = errors.New("batched response has series without a query_index")
+
+// errMalformedPoint is returned when a series in the response has a datapoint that isn't a [timestamp, value] pair.
+var errMalformedPoint = errors.New("malformed datapoint in response, expected [timestamp, value]")
+
+const (
+	// How far back to look for datapoints.
+	defaultWindow = time.Minute
+
+	// With MaxRetries, how long to wait before the first retry of a failed API call. Each retry after that waits twice as
+	// long as the one before.
+	defaultRetryDelay = time.Second
+
+	// With RetryEmpty, how long to wait before asking again, and how much wider the window is the second time around.
+	defaultRetryEmptyDelay = 2 * time.Second
+	retryEmptyWindowFactor = 5
+)
+
+// Validator validates datadog queries against the Datadog API. The exported fields can be set to tune its behaviour
+// before the first call to Validate.
+type Validator struct {
+	api *datadogV1.MetricsApi
+
+	// RetryEmpty re-queries once, after a short delay and with a wider window, when a query succeeds but returns no
+	// data. The API occasionally returns an empty series under load, so this cuts down on false "no data" warnings.
+	RetryEmpty bool
+
+	// MetricConcurrency is how many of the metrics inside a single query are validated at once. Queries with a lot of
+	// metrics (dashboard formulas, for example) validate much faster with this raised. Defaults to 1.
+	MetricConcurrency int
+
+	// BatchSize is how many metrics are sent to the API together, as a single comma separated query, with each series
+	// mapped back to its metric by query_index. This cuts down on requests for queries with a lot of metrics. A batch the
+	// API rejects is retried one metric at a time, so a single bad metric is still reported on its own. Defaults to 1,
+	// which doesn't batch at all.
+	BatchSize int
+
+	// Windows are how far back to look for datapoints, tried in order until one of them has data. A metric that only
+	// reports during business hours, or when a job runs, needs a window long enough to cover its last report; trying a
+	// short window first keeps the common case cheap. Defaults to a single one minute window.
+	Windows []time.Duration
+
+	// CompareMasked also queries each masked metric with its masking functions, e.g. `default_zero(avg:foo{*})`, and
+	// reports that value alongside the bare metric's lack of one, in MetricResult.OuterValue. This shows what the
+	// masking function is making up, rather than leaving it to be inferred. It costs an API call per masked metric.
+	CompareMasked bool
+
+	// TagOverrides rewrites the value of these tags in every query sent to the API, e.g. `env: staging` to validate
+	// queries written for production against a staging account. See OverrideTags. The results still have the original
+	// query and metrics, only what's validated changes.
+	TagOverrides map[string]string
+
+	// Rollup sets the rollup interval of every metric sent to the API, to coarsen the granularity so metrics that report
+	// less often than the default rollup reliably return a point. See Rollup. A window narrower than the interval is
+	// widened to it, since it couldn't hold a whole rollup bucket. Defaults to 0, which leaves the rollups as they are.
+	Rollup time.Duration
+
+	// CheckDeprecated looks up the metadata of each metric, and records in MetricResult.Deprecated whether it's been
+	// marked as deprecated, by its description or short name matching DeprecatedPattern. It costs an API call per metric
+	// name, but each name is only looked up once.
+	CheckDeprecated bool
+
+	// DeprecatedPattern is what a deprecated metric's description or short name matches, for CheckDeprecated. Defaults
+	// to DefaultDeprecatedPattern.
+	DeprecatedPattern *regexp.Regexp
+
+	// BenignErrors are patterns for the messages of errors the API rejects a query with, that aren't a problem with the
+	// query's structure, like `exceeded the maximum number of points` on a wide window. An error whose message matches
+	// one of them is KindBenign rather than KindBadQuery, so it can be reported as a warning rather than a failure.
+	BenignErrors []*regexp.Regexp
+
+	// MaxRetries is how many times an API call that failed in a way that might not happen again, by being rate limited,
+	// a server error or a network error, is retried, with an exponential backoff. Defaults to 0, which doesn't retry.
+	MaxRetries int
+
+	// RetryBudget caps the retries, for MaxRetries and RetryEmpty, across every call to Validate, so an API that's
+	// flaky across the board can't blow the time budget of a run one retry at a time. Once it's spent, calls aren't
+	// retried, and fail or come back empty the same as they would have without retries. Defaults to 0, which is no limit.
+	RetryBudget int64
+
+	retryEmptyDelay time.Duration
+	retryDelay      time.Duration
+	retries         atomic.Int64
+	skippedRetries  atomic.Int64
+	metadata        sync.Map // The metadata of each metric name looked up, for CheckDeprecated and LintPercentiles
+}
+
+// NewValidator creates a Validator that uses the given API. The API keys are read from the context passed to Validate,
+// using `datadog.ContextAPIKeys`, the same as any other datadog-api-client call.
+func NewValidator(api *datadogV1.MetricsApi) *Validator {
+	return &Validator{
+		api:             api,
+		retryEmptyDelay: defaultRetryEmptyDelay,
+		retryDelay:      defaultRetryDelay,
+	}
+}
+
+// Retries is how many times an API call has been retried, for MaxRetries or RetryEmpty, across every call to Validate
+// so far.
+func (v *Validator) Retries() int64 {
+	return v.retries.Load()
+}
+
+// SkippedRetries is how many times an API call would have been retried, but wasn't because the RetryBudget was spent.
+func (v *Validator) SkippedRetries() int64 {
+	return v.skippedRetries.Load()
+}
+
+// Take a retry from the RetryBudget, returning false if there's none left.
+func (v *Validator) spendRetry() bool {
+	if v.retries.Add(1) > v.RetryBudget && v.RetryBudget > 0 {
+		v.retries.Add(-1)
+		v.skippedRetries.Add(1)
+
+		return false
+	}
+
+	return true
+}
+
+// Validate runs the query against the Datadog API, followed by each metric found in the query on its own, so that
+// metrics hidden by default_zero() or arithmetic are checked too. A *MetricQueryError is returned if the full query is
+// malformed or the API call fails; a query that is valid but returns no data has a nil Result.Value. Problems with the
+// individual metrics are reported in Result.Metrics rather than as an error.
+func (v *Validator) Validate(ctx context.Context, query string) (Result, error) {
+	result := Result{
+		Query:    query,
+		Analysis: ParseQuery(query),
+	}
+
+	if len(result.Analysis.Queries) > 0 {
+		return v.validateQueries(ctx, result)
+	}
+
+	// Only the metric query can be sent to the API; a monitor's evaluation prefix and threshold would be rejected.
+	metricQuery := result.Analysis.MetricQuery()
+
+	full, err := v.fetch(ctx, metricQuery)
+
+	result.APILatency = full.latency
+	result.Window = full.window
+
+	if err != nil {
+		return result, err
+	}
+
+	// A bare count makes up the whole query, so it can have counted nothing too.
+	if metrics := result.Analysis.Metrics; len(metrics) == 1 && metrics[0].CleanMetric == strings.TrimSpace(metricQuery) {
+		full = full.countedNothing(metrics[0])
+	}
+
+	result.Value = full.value
+	result.Interval = full.interval
+	result.Stats = full.stats
+	result.Series = full.series
+	result.Metrics = v.validateMetrics(ctx, metricQuery, result.Analysis.Metrics, full)
+
+	return result, nil
+}
+
+// Validate each of the comma separated queries on its own. The API returns a series for each of them, but only the
+// first series would be looked at, so the others could have no data without anyone noticing. The result only has a
+// Value if every query does; otherwise its Value, Window, Interval and Stats are from the first one that doesn't. Its
+// Metrics are every query's, with their positions in the full query.
+func (v *Validator) validateQueries(ctx context.Context, result Result) (Result, error) {
+	for _, query := range result.Analysis.Queries {
+		queryResult, err := v.Validate(ctx, query.Query)
+
+		result.APILatency += queryResult.APILatency
+
+		if err != nil {
+			result.Window = queryResult.Window
+
+			return result, err
+		}
+
+		for _, metric := range queryResult.Metrics {
+			metric.Metric.StartPos += query.StartPos
+			metric.Metric.EndPos += query.StartPos
+			result.Metrics = append(result.Metrics, metric)
+		}
+
+		result.Series += queryResult.Series
+		result.Queries = append(result.Queries, queryResult)
+	}
+
+	summary := result.Queries[0]
+
+	for _, queryResult := range result.Queries {
+		if queryResult.Value == nil {
+			summary = queryResult
+
+			break
+		}
+	}
+
+	result.Value = summary.Value
+	result.Window = summary.Window
+	result.Interval = summary.Interval
+	result.Stats = summary.Stats
+
+	return result, nil
+}
+
+// Validate each metric on its own, or in batches of BatchSize, up to MetricConcurrency requests at a time. The results
+// are in the same order as the metrics. The sample for the full query is reused for a bare metric that makes up the
+// whole query. Metrics are only ever matched on their whole CleanMetric, never on their name alone: `avg:foo{env:prod}`
+// and `avg:foo{env:staging}` can be missing data independently, so both are validated.
+func (v *Validator) validateMetrics(
+	ctx context.Context,
+	query string,
+	metrics []MetricInfo,
+	full sample,
+) []MetricResult {
+	results := make([]MetricResult, len(metrics))
+
+	var pending []int
+
+	for i, metric := range metrics {
+		// A bare metric that makes up the whole query was already validated, there's no need to ask the API twice.
+		if metric.CleanMetric == strings.TrimSpace(query) {
+			results[i] = full.metricResult(metric, nil)
+			continue
+		}
+
+		pending = append(pending, i)
+	}
+
+	batchSize := max(v.BatchSize, 1)
+	concurrency := max(v.MetricConcurrency, 1)
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+
+	for len(pending) > 0 {
+		batch := pending[:min(batchSize, len(pending))]
+		pending = pending[len(batch):]
+
+		wg.Add(1)
+
+		sem <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if len(batch) == 1 {
+				metric := metrics[batch[0]]
+				metricSample, metricErr := v.fetch(ctx, metric.CleanMetric)
+				results[batch[0]] = metricSample.metricResult(metric, metricErr)
+
+				return
+			}
+
+			v.validateBatch(ctx, metrics, batch, results)
+		}()
+	}
+
+	wg.Wait()
+
+	if v.CompareMasked {
+		v.compareMasked(ctx, results)
+	}
+
+	if v.CheckDeprecated {
+		v.checkDeprecated(ctx, results)
+	}
+
+	return results
+}
+
+// Query each masked metric again with its masking functions, for CompareMasked. The masked metrics are rare enough that
+// they're done one at a time.
+func (v *Validator) compareMasked(ctx context.Context, results []MetricResult) {
+	for i, result := range results {
+		if result.Status != StatusMasked {
+			continue
+		}
+
+		outer, err := v.fetch(ctx, result.Metric.Metric)
+
+		results[i].OuterValue = outer.value
+		results[i].OuterErr = err
+		results[i].APILatency += outer.latency
+	}
+}
+
+// Validate the metrics at the given indexes with a single API call, storing their results at the same indexes. If the
+// batch can't be validated as a whole, each metric is validated on its own instead.
+func (v *Validator) validateBatch(ctx context.Context, metrics []MetricInfo, batch []int, results []MetricResult) {
+	queries := make([]string, len(batch))
+
+	for i, index := range batch {
+		queries[i] = metrics[index].CleanMetric
+	}
+
+	windows := v.windows()
+	samples, latency, err := v.fetchMetrics(ctx, v.rewrite(strings.Join(queries, ",")), len(batch), windows[0])
+
+	for i, index := range batch {
+		metric := metrics[index]
+
+		// One bad metric fails the whole batch, and an empty one might just need a wider window or RetryEmpty, so ask
+		// about it on its own.
+		if err != nil || (samples[i].value == nil && (v.RetryEmpty || len(windows) > 1)) {
+			metricSample, metricErr := v.fetch(ctx, metric.CleanMetric)
+			metricSample.latency += latency
+			results[index] = metricSample.metricResult(metric, metricErr)
+
+			continue
+		}
+
+		results[index] = samples[i].metricResult(metric, nil)
+	}
+}
+
+// What the API returned for a single query.
+type sample struct {
+	value    *float64      // The latest datapoint, or nil if there wasn't one
+	interval time.Duration // The interval of a series that came back with no datapoints, or 0 if there was no series
+	window   time.Duration // How far back datapoints were looked for
+	latency  time.Duration // How long the API call(s) took
+	stats    *SeriesStats  // A summary of every datapoint in the window, or nil if there weren't any
+	series   int           // How many series the query matched
+}
+
+// Build the result for a metric from its sample, and the error from fetching it.
+func (s sample) metricResult(metric MetricInfo, err error) MetricResult {
+	s = s.countedNothing(metric)
+	result := newMetricResult(metric, s.value, s.latency, err)
+	result.Window = s.window
+	result.Interval = s.interval
+	result.Stats = s.stats
+	result.Series = s.series
+
+	if err == nil && s.value == nil && s.interval > 0 {
+		result.Status = StatusSparse
+	}
+
+	return result
+}
+
+// A count with a series, but no datapoints in the window, counted no events rather than being missing: the API leaves out
+// the intervals without any instead of returning 0 for them. Those samples get a value of 0, so the metric isn't
+// reported as having no data, or as masked by a default_zero() that's only filling in real zeros.
+func (s sample) countedNothing(metric MetricInfo) sample {
+	if s.value != nil || s.series == 0 || !countsEvents(metric) {
+		return s
+	}
+
+	zero := 0.0
+	s.value = &zero
+
+	return s
+}
+
+// EffectiveWindows returns the windows that are actually queried, in order: the Windows, or the default one if there
+// aren't any, each widened to the Rollup interval.
+func (v *Validator) EffectiveWindows() []time.Duration {
+	return v.windows()
+}
+
+// The windows to look for datapoints in, in order, each at least as wide as the Rollup interval.
+func (v *Validator) windows() []time.Duration {
+	if len(v.Windows) == 0 {
+		return []time.Duration{max(defaultWindow, v.Rollup)}
+	}
+
+	if v.Rollup == 0 {
+		return v.Windows
+	}
+
+	windows := make([]time.Duration, len(v.Windows))
+
+	for i, window := range v.Windows {
+		windows[i] = max(window, v.Rollup)
+	}
+
+	return windows
+}
+
+// The query that's actually sent to the API, with the TagOverrides and Rollup applied.
+func (v *Validator) rewrite(query string) string {
+	return Rollup(OverrideTags(query, v.TagOverrides), v.Rollup)
+}
+
+// Fetch the value for the query from each of the windows in turn, stopping at the first that has data, and retrying the
+// last once with a wider window if none did and RetryEmpty is set. The sample's window is the one the value came from,
+// or the last one tried if there was no data, and its latency covers every API call that was made.
+func (v *Validator) fetch(ctx context.Context, query string) (sample, error) {
+	query = v.rewrite(query)
+
+	var total time.Duration
+
+	var last sample
+
+	for _, window := range v.windows() {
+		current, err := v.fetchMetric(ctx, query, window)
+
+		total += current.latency
+		current.latency = total
+
+		if err != nil || current.value != nil {
+			return current, err
+		}
+
+		last = current
+	}
+
+	if !v.RetryEmpty {
+		return last, nil
+	}
+
+	// Without any RetryBudget left, there's no point waiting.
+	if !v.spendRetry() {
+		return last, nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return last, nil
+	case <-time.After(v.retryEmptyDelay):
+	}
+
+	retry, err := v.fetchMetric(ctx, query, retryEmptyWindowFactor*last.window)
+	retry.latency += total
+
+	return retry, err
+}
+
+// Fetch the metric value for the specified query from the Datadog API, if possible, looking back over the given window.
+// The time taken by the API call is in the sample, whether or not it succeeded.
+func (v *Validator) fetchMetric(ctx context.Context, query string, window time.Duration) (sample, error) {
+	samples, latency, err := v.fetchMetrics(ctx, query, 1, window)
+	if err != nil {
+		return sample{window: window, latency: latency}, err
+	}
+
+	return samples[0], nil
+}
+
+// Call fetchMetrics, retrying up to MaxRetries times, while there's RetryBudget left, if the call fails in a way that
+// might not happen again. The latency covers every attempt.
+func (v *Validator) fetchMetrics(
+	ctx context.Context,
+	query string,
+	count int,
+	window time.Duration,
+) ([]sample, time.Duration, error) {
+	var total time.Duration
+
+	for attempt := 0; ; attempt++ {
+		samples, latency, err := fetchMetrics(ctx, v.api, query, count, window)
+		v.markBenign(err)
+
+		total += latency
+
+		for i := range samples {
+			samples[i].latency = total
+		}
+
+		if err == nil || attempt >= v.MaxRetries || !isRetryable(err) || !v.spendRetry() {
+			return samples, total, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return samples, total, err
+		case <-time.After(v.retryDelay << attempt):
+		}
+	}
+}
+
+// Recategorize a bad query error as KindBenign if its message matches one of BenignErrors.
+func (v *Validator) markBenign(err error) {
+	var mqe *MetricQueryError
+	if !errors.As(err, &mqe) || mqe.Kind != KindBadQuery {
+		return
+	}
+
+	for _, pattern := range v.BenignErrors {
+		if pattern.MatchString(mqe.NestedError.Error()) {
+			mqe.Kind = KindBenign
+
+			return
+		}
+	}
+}
+
+// Whether a failed API call is worth retrying: being rate limited, a server error, or not reaching the API at all
+// might not happen next time, but a bad query or bad keys will.
+func isRetryable(err error) bool {
+	var mqe *MetricQueryError
+	if !errors.As(err, &mqe) {
+		return false
+	}
+
+	return mqe.Kind == KindRateLimited || mqe.Kind == KindServer || mqe.Kind == KindNetwork
+}
+
+// Fetch the samples for a comma separated list of count queries in a single API call. The samples are in the same order
+// as the queries. When there's only one query, every series belongs to it; otherwise each series is matched up with its
+// query by query_index, and errBatchUnsupported is returned if it's missing. The time taken by the API call is returned
+// too, whether or not it succeeded.
+func fetchMetrics(
+	ctx context.Context,
+	api *datadogV1.MetricsApi,
+	query string,
+	count int,
+	window time.Duration,
+) ([]sample, time.Duration, error) {
+	from := time.Now().Add(-window).Unix()
+
+	start := time.Now()
+	metricResp, httpResp, err := api.QueryMetrics(ctx, from, time.Now().Unix(), query)
+	latency := time.Since(start)
+
+	switch {
+	case err != nil:
+		// HTTP error or some other lower level issue.
+		mqe := &MetricQueryError{
+			HTTPResponse: httpResp,
+			NestedError:  err,
+			Kind:         callErrorKind(err, httpResp),
+			RequestID:    requestID(httpResp),
+		}
+
+		return nil, latency, mqe
+
+	case metricResp.Status != nil && *metricResp.Status == "error":
+		// Error occurred in the API, so it's a bad query, bad auth, or something similar.
+		mqe := &MetricQueryError{
+			HTTPResponse: httpResp,
+			NestedError:  fmt.Errorf("MetricResponseError: %v", *metricResp.Error),
+			Kind:         responseErrorKind(*metricResp.Error),
+			RequestID:    requestID(httpResp),
+		}
+
+		return nil, latency, mqe
+	}
+
+	// The API call technically succeeded in that the query wasn't malformed.
+	// Note that this doesn't mean the metric is necessarily a real metric, just that the query succeeded. A query with
+	// no time series is probably a metric without data, or one that doesn't exist.
+	samples := make([]sample, count)
+	seen := make([]bool, count)
+
+	for i := range samples {
+		samples[i] = sample{window: window, latency: latency}
+	}
+
+	for _, series := range metricResp.Series {
+		index := 0
+
+		if count > 1 {
+			if series.QueryIndex == nil || *series.QueryIndex < 0 || int(*series.QueryIndex) >= count {
+				return nil, latency, errBatchUnsupported
+			}
+
+			index = int(*series.QueryIndex)
+		}
+
+		samples[index].series++
+
+		// Only the first series for each query counts, the same as an unbatched query.
+		if seen[index] {
+			continue
+		}
+
+		seen[index] = true
+
+		if series.End == nil || len(series.Pointlist) == 0 {
+			// The series exists, it just has no datapoints in the window. With a known interval, that means the metric
+			// is real, but reports less often than the window.
+			if series.Interval != nil {
+				samples[index].interval = time.Duration(*series.Interval) * time.Second
+			}
+
+			continue
+		}
+
+		// Return the value of the latest datapoint in the time series. A response that isn't shaped the way it should be
+		// is the API's problem, not the query's, but there's no value to report either way.
+		value, found, err := latestValue(series.Pointlist)
+		if err != nil {
+			mqe := &MetricQueryError{
+				HTTPResponse: httpResp,
+				NestedError:  err,
+				Kind:         KindServer,
+				RequestID:    requestID(httpResp),
+			}
+
+			return nil, latency, mqe
+		}
+
+		if found {
+			samples[index].value = &value
+		}
+
+		samples[index].stats = seriesStats(series.Pointlist)
+	}
+
+	return samples, latency, nil
+}
+
+// The value of the latest datapoint in the series that isn't null, and false if they're all null. An error is returned
+// if any datapoint it looks at isn't a [timestamp, value] pair.
+func latestValue(pointlist [][]*float64) (float64, bool, error) {
+	for i := len(pointlist) - 1; i >= 0; i-- {
+		point := pointlist[i]
+
+		if len(point) < 2 {
+			return 0, false, errors.Wrap(errMalformedPoint, fmt.Sprintf("datapoint %d has %d element(s)", i, len(point)))
+		}
+
+		if point[1] != nil {
+			return *point[1], true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// Categorize a failed API call: a call cut short by its context, or that timed out, is an infra problem rather than an
+// API error, so it gets a kind of its own. Otherwise it's categorized by the HTTP status.
+func callErrorKind(err error, resp *http.Response) ErrorKind {
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return KindCanceled
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return KindTimeout
+	default:
+		return httpErrorKind(resp)
+	}
+}
+
+// Categorize a failed API call by its HTTP status. There's no response at all when the API couldn't be reached.
+func httpErrorKind(resp *http.Response) ErrorKind {
+	switch {
+	case resp == nil:
+		return KindNetwork
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return KindAuth
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return KindRateLimited
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return KindServer
+	case resp.StatusCode >= http.StatusBadRequest:
+		return KindBadQuery
+	default:
+		return KindUnknown
+	}
+}
+
+// Categorize an error the API returned in an otherwise successful response. These are almost always a problem with the
+// query, but the message is all there is to go on.
+func responseErrorKind(message string) ErrorKind {
+	lower := strings.ToLower(message)
+
+	switch {
+	case strings.Contains(lower, "rate limit"):
+		return KindRateLimited
+	case strings.Contains(lower, "forbidden") || strings.Contains(lower, "unauthorized"):
+		return KindAuth
+	default:
+		return KindBadQuery
+	}
+}
@@ -0,0 +1,928 @@
+package querylint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
+)
+
+// Create a Validator that talks to a fake Datadog API, rather than the real one, so the tests don't need API keys.
+func newTestValidator(t *testing.T, handler http.HandlerFunc) *Validator {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	cfg := datadog.NewConfiguration()
+	cfg.Servers = datadog.ServerConfigurations{{URL: server.URL}}
+
+	validator := NewValidator(datadogV1.NewMetricsApi(datadog.NewAPIClient(cfg)))
+	validator.retryEmptyDelay = 0
+	validator.retryDelay = 0
+
+	return validator
+}
+
+// Respond to a QueryMetrics call with a single series ending in the given value.
+func seriesResponse(w http.ResponseWriter, value float64) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status":"ok","series":[{"end":1700000060000,"pointlist":[[1700000000000,0],[1700000060000,%v]]}]}`, value)
+}
+
+// Respond to a QueryMetrics call with no series at all.
+func emptyResponse(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `{"status":"ok","series":[]}`)
+}
+
+func TestMetricFetching(t *testing.T) {
+	t.Run("returns the latest datapoint", func(t *testing.T) {
+		validator := newTestValidator(t, func(w http.ResponseWriter, _ *http.Request) {
+			seriesResponse(w, 42)
+		})
+
+		result, err := validator.Validate(context.Background(), "avg:foo{*}")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if result.Value == nil || *result.Value != 42 {
+			t.Fatalf("Expected a value of 42, got %v", result.Value)
+		}
+
+		if result.Stats == nil || result.Stats.Points != 2 || result.Stats.Min != 0 || result.Stats.Max != 42 {
+			t.Errorf("Expected stats over both datapoints, got %+v", result.Stats)
+		}
+
+		if result.Series != 1 {
+			t.Errorf("Expected 1 series, got %d", result.Series)
+		}
+	})
+
+	t.Run("a trailing null datapoint is skipped", func(t *testing.T) {
+		validator := newTestValidator(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"status":"ok","series":[{"end":1700000060000,"pointlist":[[1700000000000,7],[1700000060000,null]]}]}`)
+		})
+
+		result, err := validator.Validate(context.Background(), "avg:foo{*}")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if result.Value == nil || *result.Value != 7 {
+			t.Errorf("Expected a value of 7, got %v", result.Value)
+		}
+	})
+
+	t.Run("malformed datapoints are an error, not a panic", func(t *testing.T) {
+		responses := []string{
+			`{"status":"ok","series":[{"end":1700000060000,"pointlist":[[1700000060000]]}]}`,
+			`{"status":"ok","series":[{"end":1700000060000,"pointlist":[[]]}]}`,
+			`{"status":"ok","series":[{"end":1700000060000,"pointlist":[[1700000000000,1],[1700000060000,null],[]]}]}`,
+		}
+
+		for _, response := range responses {
+			validator := newTestValidator(t, func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, response)
+			})
+
+			_, err := validator.Validate(context.Background(), "avg:foo{*}")
+
+			var mqe *MetricQueryError
+			if !errors.As(err, &mqe) || !errors.Is(mqe.NestedError, errMalformedPoint) {
+				t.Errorf("Expected a malformed datapoint error for %s, got %v", response, err)
+			}
+		}
+	})
+
+	t.Run("no series means no data", func(t *testing.T) {
+		validator := newTestValidator(t, func(w http.ResponseWriter, _ *http.Request) {
+			emptyResponse(w)
+		})
+
+		result, err := validator.Validate(context.Background(), "avg:foo{*}")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if result.Value != nil {
+			t.Fatalf("Expected no value, got %v", *result.Value)
+		}
+	})
+
+	t.Run("a series without datapoints is sparse, not missing", func(t *testing.T) {
+		validator := newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+
+			if r.URL.Query().Get("query") == "avg:foo{*}" {
+				fmt.Fprint(w, `{"status":"ok","series":[{"interval":3600,"length":0,"pointlist":[]}]}`)
+			} else {
+				fmt.Fprint(w, `{"status":"ok","series":[{"end":1700000060000,"pointlist":[[1700000060000,0]]}]}`)
+			}
+		})
+
+		result, err := validator.Validate(context.Background(), "default_zero(avg:foo{*})")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		metric := result.Metrics[0]
+		if metric.Status != StatusSparse || metric.Interval != time.Hour {
+			t.Errorf("Expected status %s with an hour interval, got %s with %v", StatusSparse, metric.Status, metric.Interval)
+		}
+	})
+
+	t.Run("error responses are a MetricQueryError", func(t *testing.T) {
+		validator := newTestValidator(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"status":"error","error":"Error parsing query"}`)
+		})
+
+		_, err := validator.Validate(context.Background(), "avg:foo{*}))")
+
+		mqe, ok := err.(*MetricQueryError) //nolint:errorlint
+		if !ok {
+			t.Fatalf("Expected a MetricQueryError, got %v", err)
+		}
+
+		expectedErr := "Error: MetricResponseError: Error parsing query"
+		if mqe.Error() != expectedErr {
+			t.Errorf("Expected error string `%s` but got `%v`.", expectedErr, mqe)
+		}
+	})
+
+	t.Run("masked metrics are validated bare", func(t *testing.T) {
+		validator := newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("query") == "avg:foo{*}" {
+				emptyResponse(w)
+			} else {
+				seriesResponse(w, 0)
+			}
+		})
+
+		result, err := validator.Validate(context.Background(), "default_zero(avg:foo{*})")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if len(result.Metrics) != 1 {
+			t.Fatalf("Expected 1 metric result, got %d", len(result.Metrics))
+		}
+
+		if result.Metrics[0].Status != StatusMasked {
+			t.Errorf("Expected status %s, got %s", StatusMasked, result.Metrics[0].Status)
+		}
+
+		if result.Metrics[0].OuterValue != nil {
+			t.Errorf("Expected no outer value without CompareMasked, got %v", *result.Metrics[0].OuterValue)
+		}
+	})
+
+	t.Run("masked metrics are compared with their masking functions", func(t *testing.T) {
+		validator := newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Query().Get("query") {
+			case "avg:foo{*}":
+				emptyResponse(w)
+			case "default_zero(avg:foo{*})":
+				seriesResponse(w, 0)
+			default:
+				seriesResponse(w, 1)
+			}
+		})
+		validator.CompareMasked = true
+
+		result, err := validator.Validate(context.Background(), "default_zero(avg:foo{*}) + avg:bar{*}")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		masked := result.Metrics[0]
+		if masked.Status != StatusMasked || masked.OuterValue == nil || *masked.OuterValue != 0 {
+			t.Errorf("Expected a masked metric with an outer value of 0, got %+v", masked)
+		}
+
+		if result.Metrics[1].OuterValue != nil {
+			t.Errorf("Expected only masked metrics to be compared, got %v", *result.Metrics[1].OuterValue)
+		}
+	})
+
+	t.Run("counts with a series but no datapoints counted nothing", func(t *testing.T) {
+		validator := newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Query().Get("query"), "default_zero(") {
+				seriesResponse(w, 0)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"status":"ok","series":[{"end":1700000060000,"pointlist":[[1700000000000,null],[1700000060000,null]]}]}`)
+		})
+
+		tests := []struct {
+			query  string
+			status Status
+		}{
+			{"default_zero(count:foo{*})", StatusOK},
+			{"default_zero(sum:foo{*}.as_count())", StatusOK},
+			{"default_zero(avg:foo{*})", StatusMasked},
+		}
+
+		for _, test := range tests {
+			result, err := validator.Validate(context.Background(), test.query)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			metric := result.Metrics[0]
+			if metric.Status != test.status {
+				t.Errorf("Expected %s to be %s, got %s", test.query, test.status, metric.Status)
+			}
+
+			if test.status == StatusOK && (metric.Value == nil || *metric.Value != 0) {
+				t.Errorf("Expected %s to have a value of 0, got %v", test.query, metric.Value)
+			}
+		}
+
+		result, err := validator.Validate(context.Background(), "count:foo{*}")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if result.Value == nil || *result.Value != 0 {
+			t.Errorf("Expected a bare count to have a value of 0, got %v", result.Value)
+		}
+	})
+}
+
+func TestTagOverrides(t *testing.T) {
+	var mu sync.Mutex
+
+	var queries []string
+
+	validator := newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries = append(queries, r.URL.Query().Get("query"))
+		mu.Unlock()
+
+		seriesResponse(w, 1)
+	})
+	validator.TagOverrides = map[string]string{"env": "staging"}
+
+	query := "avg:foo{env:production} + avg:bar{env:production,service:web}"
+
+	result, err := validator.Validate(context.Background(), query)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	sort.Strings(queries)
+
+	expected := []string{
+		"avg:bar{env:staging,service:web}",
+		"avg:foo{env:staging}",
+		"avg:foo{env:staging} + avg:bar{env:staging,service:web}",
+	}
+	if strings.Join(queries, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected the API to be sent %q, got %q", expected, queries)
+	}
+
+	if result.Query != query || result.Metrics[0].Metric.CleanMetric != "avg:foo{env:production}" {
+		t.Errorf("Expected the original query and metrics in the result, got %q and %q",
+			result.Query, result.Metrics[0].Metric.CleanMetric)
+	}
+}
+
+func TestRollupOverride(t *testing.T) {
+	var mu sync.Mutex
+
+	var queries []string
+
+	validator := newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries = append(queries, r.URL.Query().Get("query"))
+		mu.Unlock()
+
+		seriesResponse(w, 1)
+	})
+	validator.Rollup = 5 * time.Minute
+
+	result, err := validator.Validate(context.Background(), "avg:foo{*} + sum:bar{*}.rollup(sum, 60)")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	sort.Strings(queries)
+
+	expected := []string{
+		"avg:foo{*}.rollup(avg, 300)",
+		"avg:foo{*}.rollup(avg, 300) + sum:bar{*}.rollup(sum, 300)",
+		"sum:bar{*}.rollup(sum, 300)",
+	}
+	if strings.Join(queries, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected the API to be sent %q, got %q", expected, queries)
+	}
+
+	if result.Window != 5*time.Minute {
+		t.Errorf("Expected the window to be widened to the rollup interval, got %v", result.Window)
+	}
+}
+
+func TestMaxRetries(t *testing.T) {
+	// Fail with the given status the first failures times, and return data after that.
+	newFailingValidator := func(t *testing.T, status int, failures int32, calls *atomic.Int32) *Validator {
+		t.Helper()
+
+		return newTestValidator(t, func(w http.ResponseWriter, _ *http.Request) {
+			if calls.Add(1) <= failures {
+				http.Error(w, `{"errors":["Something went wrong"]}`, status)
+
+				return
+			}
+
+			seriesResponse(w, 1)
+		})
+	}
+
+	t.Run("server errors are retried", func(t *testing.T) {
+		var calls atomic.Int32
+
+		validator := newFailingValidator(t, http.StatusBadGateway, 2, &calls)
+		validator.MaxRetries = 2
+
+		result, err := validator.Validate(context.Background(), "avg:foo{*}")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if result.Value == nil || calls.Load() != 3 || validator.Retries() != 2 {
+			t.Errorf("Expected a value after 2 retries, got %v after %d call(s)", result.Value, calls.Load())
+		}
+	})
+
+	t.Run("retries run out", func(t *testing.T) {
+		var calls atomic.Int32
+
+		validator := newFailingValidator(t, http.StatusTooManyRequests, 5, &calls)
+		validator.MaxRetries = 2
+
+		_, err := validator.Validate(context.Background(), "avg:foo{*}")
+
+		var mqe *MetricQueryError
+		if !errors.As(err, &mqe) || mqe.Kind != KindRateLimited {
+			t.Fatalf("Expected a rate limited error, got %v", err)
+		}
+
+		if calls.Load() != 3 {
+			t.Errorf("Expected 3 calls, got %d", calls.Load())
+		}
+	})
+
+	t.Run("bad queries aren't retried", func(t *testing.T) {
+		var calls atomic.Int32
+
+		validator := newFailingValidator(t, http.StatusBadRequest, 1, &calls)
+		validator.MaxRetries = 2
+
+		_, err := validator.Validate(context.Background(), "avg:foo{*}")
+		if err == nil || calls.Load() != 1 || validator.Retries() != 0 {
+			t.Errorf("Expected an error without any retries, got %v after %d call(s)", err, calls.Load())
+		}
+	})
+
+	t.Run("the budget caps retries across queries", func(t *testing.T) {
+		var calls atomic.Int32
+
+		validator := newFailingValidator(t, http.StatusBadGateway, 100, &calls)
+		validator.MaxRetries = 2
+		validator.RetryBudget = 3
+
+		for range 3 {
+			_, err := validator.Validate(context.Background(), "avg:foo{*}")
+			if err == nil {
+				t.Fatalf("Expected an error but didn't receive one.")
+			}
+		}
+
+		// 2 retries for the first query, 1 for the second, and none for the third.
+		if calls.Load() != 6 || validator.Retries() != 3 || validator.SkippedRetries() != 2 {
+			t.Errorf("Expected 6 calls, 3 retries and 2 skipped, got %d, %d and %d",
+				calls.Load(), validator.Retries(), validator.SkippedRetries())
+		}
+	})
+}
+
+func TestRetryEmpty(t *testing.T) {
+	// Respond with no data the first time, and data after that, recording the window of each call.
+	newFlakyValidator := func(t *testing.T, calls *atomic.Int32, windows *[]int64) *Validator {
+		t.Helper()
+
+		return newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+			from, _ := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+			to, _ := strconv.ParseInt(r.URL.Query().Get("to"), 10, 64)
+			*windows = append(*windows, to-from)
+
+			if calls.Add(1) == 1 {
+				emptyResponse(w)
+			} else {
+				seriesResponse(w, 1)
+			}
+		})
+	}
+
+	t.Run("an empty response is retried with a wider window", func(t *testing.T) {
+		var calls atomic.Int32
+
+		var windows []int64
+
+		validator := newFlakyValidator(t, &calls, &windows)
+		validator.RetryEmpty = true
+
+		result, err := validator.Validate(context.Background(), "avg:foo{*}")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if result.Value == nil {
+			t.Fatalf("Expected the retry to return data")
+		}
+
+		if len(windows) != 2 || windows[1] <= windows[0] {
+			t.Errorf("Expected a second, wider window, got %v", windows)
+		}
+
+		if validator.Retries() != 1 {
+			t.Errorf("Expected 1 retry, got %d", validator.Retries())
+		}
+	})
+
+	t.Run("an empty response isn't waited on once the budget is spent", func(t *testing.T) {
+		var calls atomic.Int32
+
+		var windows []int64
+
+		validator := newFlakyValidator(t, &calls, &windows)
+		validator.RetryEmpty = true
+		validator.RetryBudget = 1
+		validator.retryEmptyDelay = time.Hour
+
+		validator.retries.Store(1)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		result, err := validator.Validate(ctx, "avg:foo{*}")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if ctx.Err() != nil {
+			t.Fatalf("Expected no wait for the retry, but it was waited on until the timeout")
+		}
+
+		if result.Value != nil || calls.Load() != 1 || validator.SkippedRetries() != 1 {
+			t.Errorf("Expected a single call with no data, and 1 skipped retry, got %d calls and %d skipped",
+				calls.Load(), validator.SkippedRetries())
+		}
+	})
+
+	t.Run("an empty response isn't retried by default", func(t *testing.T) {
+		var calls atomic.Int32
+
+		var windows []int64
+
+		validator := newFlakyValidator(t, &calls, &windows)
+
+		result, err := validator.Validate(context.Background(), "avg:foo{*}")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if result.Value != nil || calls.Load() != 1 {
+			t.Errorf("Expected a single call with no data, got %d calls", calls.Load())
+		}
+	})
+}
+
+func TestParallelMetrics(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+
+	validator := newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		for {
+			seen := maxInFlight.Load()
+			if current <= seen || maxInFlight.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+
+		if r.URL.Query().Get("query") == "avg:c{*}" {
+			emptyResponse(w)
+		} else {
+			seriesResponse(w, 1)
+		}
+	})
+	validator.MetricConcurrency = 2
+
+	result, err := validator.Validate(context.Background(), "avg:a{*} + avg:b{*} + avg:c{*} + avg:d{*}")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if maxInFlight.Load() != 2 {
+		t.Errorf("Expected 2 metrics to be validated at once, got %d", maxInFlight.Load())
+	}
+
+	expected := []Status{StatusOK, StatusOK, StatusNoData, StatusOK}
+	for i, metric := range result.Metrics {
+		if metric.Status != expected[i] {
+			t.Errorf("Expected metric %q to be %s, got %s", metric.Metric.CleanMetric, expected[i], metric.Status)
+		}
+	}
+}
+
+func TestValidateSameMetricDifferentFilters(t *testing.T) {
+	query := "avg:foo{env:prod} - default_zero(avg:foo{env:staging})"
+
+	for _, batchSize := range []int{1, 2} {
+		t.Run(fmt.Sprintf("batch size %d", batchSize), func(t *testing.T) {
+			validator := newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Query().Get("query") {
+				case query, "avg:foo{env:prod}":
+					seriesResponse(w, 1)
+				case "avg:foo{env:prod},avg:foo{env:staging}":
+					w.Header().Set("Content-Type", "application/json")
+					fmt.Fprint(w, `{"status":"ok","series":[{"query_index":0,"end":1700000060000,"pointlist":[[1700000060000,1]]}]}`)
+				default:
+					emptyResponse(w)
+				}
+			})
+			validator.BatchSize = batchSize
+
+			result, err := validator.Validate(context.Background(), query)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			expected := []Status{StatusOK, StatusMasked}
+
+			if len(result.Metrics) != len(expected) {
+				t.Fatalf("Expected %d metrics, got %d", len(expected), len(result.Metrics))
+			}
+
+			for i, metric := range result.Metrics {
+				if metric.Status != expected[i] {
+					t.Errorf("Expected %s to be %s, got %s", metric.Metric.CleanMetric, expected[i], metric.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchMetrics(t *testing.T) {
+	query := "avg:a{*} + default_zero(avg:b{*}) + avg:c{*}"
+
+	t.Run("series are mapped back by query_index", func(t *testing.T) {
+		var calls atomic.Int32
+
+		validator := newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+
+			w.Header().Set("Content-Type", "application/json")
+
+			if r.URL.Query().Get("query") == query {
+				fmt.Fprint(w, `{"status":"ok","series":[{"end":1700000060000,"pointlist":[[1700000060000,1]]}]}`)
+				return
+			}
+
+			// Leave avg:b{*} without a series, so it's masked.
+			fmt.Fprint(w, `{"status":"ok","series":[`+
+				`{"query_index":2,"end":1700000060000,"pointlist":[[1700000060000,3]]},`+
+				`{"query_index":0,"end":1700000060000,"pointlist":[[1700000060000,1]]}]}`)
+		})
+		validator.BatchSize = 3
+
+		result, err := validator.Validate(context.Background(), query)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if calls.Load() != 2 {
+			t.Errorf("Expected 2 calls, got %d", calls.Load())
+		}
+
+		expected := []Status{StatusOK, StatusMasked, StatusOK}
+		for i, metric := range result.Metrics {
+			if metric.Status != expected[i] {
+				t.Errorf("Expected %s to be %s, got %s", metric.Metric.CleanMetric, expected[i], metric.Status)
+			}
+		}
+
+		if *result.Metrics[2].Value != 3 {
+			t.Errorf("Expected avg:c{*} to be 3, got %v", *result.Metrics[2].Value)
+		}
+	})
+
+	t.Run("a rejected batch falls back to one metric at a time", func(t *testing.T) {
+		validator := newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Query().Get("query") {
+			case query, "avg:a{*}", "avg:c{*}":
+				seriesResponse(w, 1)
+			default:
+				// Both the batch, and avg:b{*} on its own.
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"status":"error","error":"Error parsing query"}`)
+			}
+		})
+		validator.BatchSize = 3
+
+		result, err := validator.Validate(context.Background(), query)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		expected := []Status{StatusOK, StatusError, StatusOK}
+		for i, metric := range result.Metrics {
+			if metric.Status != expected[i] {
+				t.Errorf("Expected %s to be %s, got %s", metric.Metric.CleanMetric, expected[i], metric.Status)
+			}
+		}
+	})
+
+	t.Run("series without a query_index fall back to one metric at a time", func(t *testing.T) {
+		var calls atomic.Int32
+
+		validator := newTestValidator(t, func(w http.ResponseWriter, _ *http.Request) {
+			calls.Add(1)
+			seriesResponse(w, 1)
+		})
+		validator.BatchSize = 3
+
+		result, err := validator.Validate(context.Background(), query)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if calls.Load() != 5 {
+			t.Errorf("Expected 5 calls, got %d", calls.Load())
+		}
+
+		for _, metric := range result.Metrics {
+			if metric.Status != StatusOK {
+				t.Errorf("Expected %s to be %s, got %s", metric.Metric.CleanMetric, StatusOK, metric.Status)
+			}
+		}
+	})
+}
+
+func TestWindows(t *testing.T) {
+	// Only return data when looking back at least a day.
+	newDailyValidator := func(t *testing.T, windows *[]int64) *Validator {
+		t.Helper()
+
+		return newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+			from, _ := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+			to, _ := strconv.ParseInt(r.URL.Query().Get("to"), 10, 64)
+			*windows = append(*windows, to-from)
+
+			if time.Duration(to-from)*time.Second >= 24*time.Hour {
+				seriesResponse(w, 1)
+			} else {
+				emptyResponse(w)
+			}
+		})
+	}
+
+	t.Run("stops at the first window with data", func(t *testing.T) {
+		var windows []int64
+
+		validator := newDailyValidator(t, &windows)
+		validator.Windows = []time.Duration{time.Hour, 24 * time.Hour, 7 * 24 * time.Hour}
+
+		result, err := validator.Validate(context.Background(), "avg:foo{*}")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if result.Value == nil {
+			t.Fatalf("Expected the day window to return data")
+		}
+
+		if result.Window != 24*time.Hour {
+			t.Errorf("Expected the data to come from the day window, got %v", result.Window)
+		}
+
+		if len(windows) != 2 {
+			t.Errorf("Expected 2 calls, got %v", windows)
+		}
+	})
+
+	t.Run("no data only if every window is empty", func(t *testing.T) {
+		var windows []int64
+
+		validator := newDailyValidator(t, &windows)
+		validator.Windows = []time.Duration{time.Minute, time.Hour}
+
+		result, err := validator.Validate(context.Background(), "avg:foo{*}")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if result.Value != nil || len(windows) != 2 {
+			t.Errorf("Expected no data after 2 calls, got %v after %v", result.Value, windows)
+		}
+	})
+}
+
+func TestErrorKinds(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		kind    ErrorKind
+	}{
+		{"forbidden is auth", func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, `{"errors":["Forbidden"]}`, http.StatusForbidden)
+		}, KindAuth},
+		{"too many requests is rate limited", func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, `{"errors":["Rate limit exceeded"]}`, http.StatusTooManyRequests)
+		}, KindRateLimited},
+		{"internal errors are server errors", func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, `{"errors":["Internal error"]}`, http.StatusBadGateway)
+		}, KindServer},
+		{"bad requests are bad queries", func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, `{"errors":["Error parsing query"]}`, http.StatusBadRequest)
+		}, KindBadQuery},
+		{"error responses are bad queries", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"status":"error","error":"Error parsing query"}`)
+		}, KindBadQuery},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validator := newTestValidator(t, test.handler)
+
+			_, err := validator.Validate(context.Background(), "avg:foo{*}")
+
+			mqe, ok := err.(*MetricQueryError) //nolint:errorlint
+			if !ok {
+				t.Fatalf("Expected a MetricQueryError, got %v", err)
+			}
+
+			if mqe.Kind != test.kind {
+				t.Errorf("Expected kind %s, got %s", test.kind, mqe.Kind)
+			}
+		})
+	}
+
+	t.Run("an unreachable API is a network error", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		cfg := datadog.NewConfiguration()
+		cfg.Servers = datadog.ServerConfigurations{{URL: server.URL}}
+
+		validator := NewValidator(datadogV1.NewMetricsApi(datadog.NewAPIClient(cfg)))
+
+		_, err := validator.Validate(context.Background(), "avg:foo{*}")
+
+		mqe, ok := err.(*MetricQueryError) //nolint:errorlint
+		if !ok || mqe.Kind != KindNetwork {
+			t.Errorf("Expected a network MetricQueryError, got %v", err)
+		}
+
+		if mqe != nil && mqe.RequestID != "" {
+			t.Errorf("Expected no request ID without a response, got %s", mqe.RequestID)
+		}
+	})
+
+	t.Run("a cancelled call is canceled, not an API error", func(t *testing.T) {
+		validator := newTestValidator(t, func(w http.ResponseWriter, _ *http.Request) {
+			seriesResponse(w, 1)
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := validator.Validate(ctx, "avg:foo{*}")
+
+		mqe, ok := err.(*MetricQueryError) //nolint:errorlint
+		if !ok || mqe.Kind != KindCanceled || !mqe.Kind.Interrupted() {
+			t.Errorf("Expected a canceled MetricQueryError, got %v", err)
+		}
+	})
+
+	t.Run("a call past its deadline is a timeout", func(t *testing.T) {
+		validator := newTestValidator(t, func(w http.ResponseWriter, _ *http.Request) {
+			time.Sleep(50 * time.Millisecond)
+			seriesResponse(w, 1)
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := validator.Validate(ctx, "avg:foo{*}")
+
+		mqe, ok := err.(*MetricQueryError) //nolint:errorlint
+		if !ok || mqe.Kind != KindTimeout || !mqe.Kind.Interrupted() {
+			t.Errorf("Expected a timeout MetricQueryError, got %v", err)
+		}
+	})
+
+	t.Run("an error response matching a benign pattern is benign", func(t *testing.T) {
+		var calls atomic.Int32
+
+		validator := newTestValidator(t, func(w http.ResponseWriter, _ *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"status":"error","error":"Query too expensive to evaluate"}`)
+		})
+		validator.BenignErrors = []*regexp.Regexp{regexp.MustCompile(`too expensive`)}
+
+		_, err := validator.Validate(context.Background(), "avg:foo{*}")
+
+		mqe, ok := err.(*MetricQueryError) //nolint:errorlint
+		if !ok || mqe.Kind != KindBenign {
+			t.Errorf("Expected a benign MetricQueryError, got %v", err)
+		}
+
+		if calls.Load() != 1 {
+			t.Errorf("Expected 1 call, got %d", calls.Load())
+		}
+	})
+
+	t.Run("the request ID is captured", func(t *testing.T) {
+		validator := newTestValidator(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("X-Request-Id", "abc123")
+			http.Error(w, `{"errors":["Error parsing query"]}`, http.StatusBadRequest)
+		})
+
+		_, err := validator.Validate(context.Background(), "avg:foo{*}")
+
+		mqe, ok := err.(*MetricQueryError) //nolint:errorlint
+		if !ok || mqe.RequestID != "abc123" {
+			t.Errorf("Expected a MetricQueryError with request ID abc123, got %v", err)
+		}
+	})
+}
+
+func TestCommaSeparatedQueriesValidation(t *testing.T) {
+	var queries []string
+
+	validator := newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		queries = append(queries, query)
+
+		if query == "avg:b{*}" {
+			emptyResponse(w)
+		} else {
+			seriesResponse(w, 1)
+		}
+	})
+
+	result, err := validator.Validate(context.Background(), "avg:a{*}, default_zero(avg:b{*})")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expectedQueries := []string{"avg:a{*}", "default_zero(avg:b{*})", "avg:b{*}"}
+	if !slices.Equal(queries, expectedQueries) {
+		t.Errorf("Expected each query to be validated on its own, with %v, got %v", expectedQueries, queries)
+	}
+
+	if len(result.Queries) != 2 || result.Queries[0].Value == nil || result.Queries[1].Value == nil {
+		t.Fatalf("Expected a result with data for each query, got %+v", result.Queries)
+	}
+
+	if len(result.Metrics) != 2 {
+		t.Fatalf("Expected 2 metric results, got %d", len(result.Metrics))
+	}
+
+	for i, metric := range result.Metrics {
+		if expected := result.Analysis.Metrics[i]; metric.Metric.StartPos != expected.StartPos || metric.Metric.EndPos != expected.EndPos {
+			t.Errorf("Expected the metric to be at its position in the full query, got %+v", metric.Metric)
+		}
+	}
+
+	if result.Metrics[1].Status != StatusMasked {
+		t.Errorf("Expected status %s, got %s", StatusMasked, result.Metrics[1].Status)
+	}
+}
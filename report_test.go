@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestTeeHandler(t *testing.T) {
+	var debug, warn bytes.Buffer
+
+	logger := slog.New(teeHandler{
+		slog.NewTextHandler(&debug, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		slog.NewTextHandler(&warn, &slog.HandlerOptions{Level: slog.LevelWarn}),
+	}).With(slog.String("file", "foo.yaml"))
+
+	logger.Debug("Metric result")
+	logger.Warn("Query returned no data")
+
+	if strings.Count(debug.String(), "file=foo.yaml") != 2 {
+		t.Errorf("Expected both records in the debug handler, got:\n%s", debug.String())
+	}
+
+	if strings.Contains(warn.String(), "Metric result") || !strings.Contains(warn.String(), "file=foo.yaml") {
+		t.Errorf("Expected only the warning in the warn handler, got:\n%s", warn.String())
+	}
+}
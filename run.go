@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/persona-id/datadog-query-linter/querylint"
+	"github.com/pkg/errors"
+)
+
+// A runner lints each target in turn, and collects what the reports at the end of the run need. The options are
+// resolved from the flags by main.
+type runner struct {
+	kind             string
+	queryPath        string
+	commentPrefix    string
+	lookupEnv        func(string) (string, bool)
+	detectDuplicates bool
+	printCanonical   bool
+	printMetrics     bool
+	dumpAST          bool
+	explain          bool
+	maxQueryLength   int
+	rules            querylint.Rules
+	requiredTags     []string
+	onlyChanged      bool
+	baseRef          string
+	catalog          *querylint.Catalog
+	validator        *querylint.Validator
+	account          *compareAccount
+	fresh            *newMetrics
+	seriesStats      bool
+	requireData      bool
+	reportAt         *threshold
+	maxSeries        int
+	maxFailures      int
+	maxDuration      time.Duration
+	known            baseline
+	writeBaseline    bool
+	metrics          *runMetrics
+	bar              *progress
+
+	counts tally
+
+	// Every file each canonical query was found in, for -detect-duplicates.
+	seen map[string][]string
+
+	// The name of every metric in every query, for -print-metrics.
+	usedMetrics map[string]struct{}
+
+	// The failing queries that aren't in the baseline, for -write-baseline and -slack-webhook.
+	failing []baselineEntry
+
+	// The failing queries that aren't in the baseline, with why they failed, for -github-review.
+	reviewFailures []githubFailure
+
+	// Every query that was parsed, for -output-template.
+	results []templateResult
+
+	// The tally when the current target started, so settle can tell whether it failed.
+	failuresBefore int
+	problemsBefore int
+}
+
+// Why a run stopped before every target was linted.
+type stopCause int
+
+const (
+	notStopped        stopCause = iota
+	stoppedBySignal             // Ctrl-C, or CI cancelling the job
+	stoppedByDuration           // -max-duration ran out
+	stoppedByFailures           // -max-failures (or -fail-fast) was reached
+)
+
+// Return a context that's cancelled by SIGINT or SIGTERM, and the func to stop catching them. Only the first signal is
+// caught, so a second one kills a run that's stuck.
+func catchSignals(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-ctx.Done()
+		stopSignals()
+	}()
+
+	return ctx, stopSignals
+}
+
+// Lint each of the targets, until they're all done or the run has to stop early, and return why it stopped.
+func (r *runner) lintTargets(ctx context.Context, signaled context.Context, targets []target) stopCause {
+	if r.seen == nil {
+		r.seen = map[string][]string{}
+	}
+
+	if r.usedMetrics == nil {
+		r.usedMetrics = map[string]struct{}{}
+	}
+
+	r.failuresBefore = r.counts.failures
+	r.problemsBefore = len(r.counts.problems)
+
+	defer r.bar.finish()
+
+	for i := range targets {
+		r.metrics.setTally(r.counts)
+		r.bar.next(targets[i].String())
+
+		if cause := r.stopCause(ctx, signaled, i, len(targets)-i); cause != notStopped {
+			return cause
+		}
+
+		r.lintTarget(ctx, targets[i])
+		r.settle(targets[i])
+	}
+
+	return notStopped
+}
+
+// Check whether the run has to stop before the next target, and log why if it does. The signal is checked first, since
+// cancelling ctx cancels the -max-duration one derived from it too.
+func (r *runner) stopCause(ctx context.Context, signaled context.Context, linted int, remaining int) stopCause {
+	switch {
+	case signaled.Err() != nil:
+		slog.Error("Run was interrupted, skipping the remaining queries",
+			slog.Int("linted", linted),
+			slog.Int("remaining", remaining),
+			slog.Int("failures", r.counts.failures),
+			slog.Int("warnings", r.counts.warnings),
+		)
+
+		return stoppedBySignal
+	case ctx.Err() != nil:
+		slog.Error("Run exceeded -max-duration, skipping the remaining queries",
+			slog.Duration("max_duration", r.maxDuration),
+			slog.Int("remaining", remaining),
+		)
+
+		return stoppedByDuration
+	case r.maxFailures > 0 && r.counts.failures >= r.maxFailures:
+		// Something systemic, like the wrong API key or site, fails every query, so there's no point using up the API
+		// quota on the rest of them.
+		slog.Error("Run reached -max-failures (or -fail-fast), skipping the remaining queries",
+			slog.Int("max_failures", r.maxFailures),
+			slog.Int("remaining", remaining),
+		)
+
+		return stoppedByFailures
+	default:
+		return notStopped
+	}
+}
+
+// Settle a target once it's done: if it failed, it's either in the baseline, or recorded as a new failure.
+func (r *runner) settle(t target) {
+	if r.counts.failures > r.failuresBefore {
+		entry := baselineEntry{File: t.String(), Query: t.query}
+
+		switch {
+		case r.writeBaseline || !r.known[entry]:
+			r.failing = append(r.failing, entry)
+			r.reviewFailures = append(r.reviewFailures, githubFailure{target: t, reasons: r.counts.failureReasons(r.problemsBefore)})
+		default:
+			slog.Info("Query is in the -baseline, so its failures don't count",
+				slog.String("file", entry.File),
+				slog.Int("failures", r.counts.failures-r.failuresBefore),
+			)
+
+			r.counts.forgetFailures(r.problemsBefore)
+		}
+	}
+
+	r.failuresBefore = r.counts.failures
+	r.problemsBefore = len(r.counts.problems)
+}
+
+// Lint a single target: statically, then against the API (or the -catalog), recording what's found in the tally.
+func (r *runner) lintTarget(ctx context.Context, t target) {
+	file := t.String()
+	query := t.query
+
+	var err error
+
+	if r.lookupEnv != nil {
+		query, err = expandEnv(query, r.lookupEnv)
+		if err != nil {
+			slog.Error("Failed to expand the query", slog.String("file", file), slog.Any("err", err))
+
+			r.counts.fail(reasonEnvExpansion, file)
+
+			return
+		}
+	}
+
+	query = querylint.StripComments(query, r.commentPrefix)
+
+	analysis := querylint.ParseQuery(query)
+
+	if r.detectDuplicates {
+		canonical := analysis.Canonical()
+		r.seen[canonical] = append(r.seen[canonical], file)
+	}
+
+	if r.printCanonical {
+		fmt.Fprintf(os.Stdout, "%s\t%s\n", file, analysis.Canonical())
+		return
+	}
+
+	if r.printMetrics {
+		for _, metric := range analysis.Metrics {
+			if name := querylint.MetricName(metric); name != "" {
+				r.usedMetrics[name] = struct{}{}
+			}
+		}
+
+		return
+	}
+
+	if r.dumpAST {
+		err = dumpAST(os.Stdout, file, analysis)
+		if err != nil {
+			slog.Error("Failed to dump the parsed query", slog.String("file", file), slog.Any("err", err))
+			r.counts.fail(reasonDumpAST, file)
+		}
+
+		return
+	}
+
+	if length := utf8.RuneCountInString(query); r.maxQueryLength > 0 && length > r.maxQueryLength {
+		slog.Warn("Query is longer than -max-query-length; it might be generated, or could be simplified",
+			slog.String("file", file),
+			lineAttr(t.line),
+			queryAttr(query),
+			slog.Int("length", length),
+			slog.Int("max_query_length", r.maxQueryLength),
+		)
+
+		r.counts.warn(reasonQueryLength, file)
+	}
+
+	// Syntax problems are much cheaper to catch here than with a round trip to the API, and the API would only
+	// reject the query anyway.
+	if len(analysis.Problems) > 0 {
+		for _, problem := range analysis.Problems {
+			slog.Error("Syntax error in query",
+				slog.String("file", file),
+				lineAttr(t.line),
+				queryAttr(query),
+				slog.Int("pos", problem.Pos),
+				slog.String("err", problem.Message),
+			)
+		}
+
+		if r.explain {
+			printExplanation(file, querylint.Result{Query: query, Analysis: analysis}, nil)
+		}
+
+		r.results = append(r.results, templateResult{File: file, Line: t.line, Result: querylint.Result{Query: query, Analysis: analysis}})
+		r.counts.fail(reasonSyntax, file)
+
+		return
+	}
+
+	// The static rules don't need the API, so they run first.
+	reportFindings(file, querylint.Lint(analysis, r.rules), &r.counts)
+	reportFindings(file, querylint.LintRequiredTags(analysis, r.rules, r.requiredTags), &r.counts)
+
+	if r.onlyChanged {
+		// A file that isn't at the base revision is new (or renamed), so it needs validating.
+		baseQuery, err := queryAtRevision(r.baseRef, t, r.kind, r.queryPath)
+		if err != nil && !errors.Is(err, errNotAtRevision) {
+			slog.Warn("Failed to read the query at the base revision, so it's validated as if it changed",
+				slog.String("file", file),
+				slog.String("base_ref", r.baseRef),
+				slog.Any("err", err),
+			)
+		}
+
+		if err == nil && baseQuery == t.query {
+			slog.Info("Query is unchanged from the base revision, skipping it",
+				slog.String("file", file),
+				slog.String("base_ref", r.baseRef),
+			)
+
+			r.results = append(r.results, templateResult{
+				File:    file,
+				Line:    t.line,
+				Result:  querylint.Result{Query: query, Analysis: analysis},
+				Skipped: true,
+			})
+			r.counts.skipped++
+
+			return
+		}
+	}
+
+	// The catalog stands in for the API entirely, so there's no data to check, only whether the metrics exist.
+	if r.catalog != nil {
+		reportCatalog(file, query, analysis, r.catalog, &r.counts)
+
+		r.results = append(r.results, templateResult{File: file, Line: t.line, Result: querylint.Result{Query: query, Analysis: analysis}})
+
+		return
+	}
+
+	// The API's error for a percentile of a metric that isn't a distribution doesn't say why, so this is checked first.
+	reportFindings(file, r.validator.LintPercentiles(ctx, analysis, r.rules), &r.counts)
+
+	result, err := r.validator.Validate(ctx, query)
+
+	if r.explain {
+		printExplanation(file, result, err)
+	}
+
+	// A call cut short by -max-duration or a signal says nothing about the query, so it's not a failure; the check at
+	// the top of the loop reports why the run stopped.
+	if err != nil && ctx.Err() != nil {
+		r.counts.interrupted++
+
+		return
+	}
+
+	r.metrics.observe(result)
+
+	r.results = append(r.results, templateResult{File: file, Line: t.line, Result: result, Err: err})
+
+	var mqe *querylint.MetricQueryError
+
+	switch {
+	case isInterrupted(err):
+		slog.Warn("API call was cut short, so the query wasn't validated",
+			slog.String("file", file),
+			lineAttr(t.line),
+			queryAttr(query),
+			slog.Any("err", err),
+		)
+
+		r.counts.interrupted++
+	case isBenign(err):
+		slog.Warn("API rejected the query with a -benign-error, so it wasn't validated",
+			slog.String("file", file),
+			lineAttr(t.line),
+			queryAttr(query),
+			slog.Any("err", err),
+		)
+
+		r.counts.warn(apiErrorReason(err), file)
+	case err != nil:
+		if errors.As(err, &mqe) {
+			slog.Error("Error calling `MetricsApi.Querymetrics`",
+				slog.String("file", file),
+				lineAttr(t.line),
+				queryAttr(query),
+				slog.Any("err", mqe.NestedError),
+				slog.String("kind", mqe.Kind.String()),
+				slog.String("request_id", mqe.RequestID),
+				slog.Duration("api_latency", result.APILatency),
+			)
+		}
+
+		r.counts.fail(apiErrorReason(err), file)
+		r.counts.countAPIError(err)
+	default:
+		switch {
+		case result.Value == nil && result.Interval > 0:
+			// The series exists, so the metric is real; it just reports less often than the window.
+			slog.Info("Query has a series, but no datapoints in the window",
+				slog.String("file", file),
+				lineAttr(t.line),
+				queryAttr(query),
+				slog.Duration("interval", result.Interval),
+				slog.Duration("window", result.Window),
+				slog.Duration("api_latency", result.APILatency),
+			)
+		case result.Value == nil && r.fresh.explainNoData(result):
+			slog.Info("Query returned no data, but its metrics without data are new, within -new-metric-grace",
+				slog.String("file", file),
+				lineAttr(t.line),
+				queryAttr(query),
+				slog.Duration("api_latency", result.APILatency),
+			)
+		case result.Value == nil:
+			r.counts.noData(reasonNoData, file, r.requireData)
+
+			slog.Log(ctx, noDataLevel(r.requireData),
+				"Query returned no data; the metric might not be real or there may not be any datapoints",
+				slog.String("file", file),
+				lineAttr(t.line),
+				queryAttr(query),
+				emptyQueriesAttr(result),
+				slog.Duration("api_latency", result.APILatency),
+			)
+		default:
+			attrs := []any{
+				slog.String("file", file),
+				lineAttr(t.line),
+				queryAttr(query),
+				slog.Float64("value", *result.Value),
+				slog.Duration("window", result.Window),
+				slog.Duration("api_latency", result.APILatency),
+			}
+
+			if r.seriesStats {
+				attrs = append(attrs, statsAttr(result.Stats))
+			}
+
+			// The value is the metric query's, which is what the monitor evaluates; the monitor itself isn't run.
+			if monitor := result.Analysis.Monitor; monitor != nil {
+				attrs = append(attrs, slog.Group("monitor",
+					slog.String("aggregator", monitor.Aggregator),
+					slog.String("window", monitor.Window),
+					slog.String("threshold", strings.TrimSpace(monitor.Comparator+" "+monitor.Threshold)),
+				))
+			}
+
+			slog.Info("Query result", attrs...)
+
+			if r.reportAt != nil && r.reportAt.crossedBy(*result.Value) {
+				slog.Warn("Query's latest value crosses -report-threshold",
+					slog.String("file", file),
+					lineAttr(t.line),
+					queryAttr(query),
+					slog.Float64("value", *result.Value),
+					slog.String("report_threshold", r.reportAt.String()),
+				)
+
+				r.counts.warn(reasonThreshold, file)
+			}
+		}
+
+		reportFindings(file, querylint.LintResult(result, r.rules, r.maxSeries), &r.counts)
+		reportMetrics(file, result, r.rules, r.seriesStats, r.requireData, r.fresh, &r.counts)
+	}
+
+	if r.account != nil && !isInterrupted(err) {
+		reportComparison(ctx, r.account, file, t.line, query, result, err, &r.counts)
+	}
+}
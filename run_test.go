@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
+	"github.com/persona-id/datadog-query-linter/querylint"
+)
+
+// A runner that checks the metrics against a catalog, so nothing calls the API, and three targets: one with a metric
+// that's in the catalog, two without.
+func newTestRunner(t *testing.T) (*runner, []target) {
+	t.Helper()
+
+	r := &runner{catalog: querylint.NewCatalog([]string{"foo"})}
+	targets := []target{
+		{file: "a.yaml", query: "avg:bar{*}"},
+		{file: "b.yaml", query: "avg:baz{*}"},
+		{file: "c.yaml", query: "avg:foo{*}"},
+	}
+
+	return r, targets
+}
+
+// A runner that validates the queries against an API that never responds, so each call lasts until its context is
+// done.
+func newHangingRunner(t *testing.T) *runner {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := datadog.NewConfiguration()
+	cfg.Servers = datadog.ServerConfigurations{{URL: server.URL}}
+
+	return &runner{validator: querylint.NewValidator(datadogV1.NewMetricsApi(datadog.NewAPIClient(cfg)))}
+}
+
+func TestLintTargets(t *testing.T) {
+	t.Run("every target is linted without a reason to stop", func(t *testing.T) {
+		r, targets := newTestRunner(t)
+
+		stopped := r.lintTargets(context.Background(), context.Background(), targets)
+		if stopped != notStopped {
+			t.Errorf("Expected the run not to stop, got %v", stopped)
+		}
+
+		if len(r.results) != len(targets) {
+			t.Errorf("Expected %d results, got %d", len(targets), len(r.results))
+		}
+
+		if code := exitCode(r.counts, false, false); code != failureExitCode {
+			t.Errorf("Expected exit code %d, got %d", failureExitCode, code)
+		}
+	})
+
+	t.Run("-max-failures skips the rest of the targets", func(t *testing.T) {
+		r, targets := newTestRunner(t)
+		r.maxFailures = 1
+
+		stopped := r.lintTargets(context.Background(), context.Background(), targets)
+		if stopped != stoppedByFailures {
+			t.Errorf("Expected the run to stop at -max-failures, got %v", stopped)
+		}
+
+		if len(r.results) != 1 {
+			t.Errorf("Expected only the first target to be linted, got %d results", len(r.results))
+		}
+
+		if code := exitCode(r.counts, false, false); code != failureExitCode {
+			t.Errorf("Expected exit code %d, got %d", failureExitCode, code)
+		}
+	})
+
+	t.Run("-max-failures counts the failures, not the targets", func(t *testing.T) {
+		r, targets := newTestRunner(t)
+		r.maxFailures = 2
+
+		stopped := r.lintTargets(context.Background(), context.Background(), targets)
+		if stopped != stoppedByFailures {
+			t.Errorf("Expected the run to stop at -max-failures, got %v", stopped)
+		}
+
+		if len(r.results) != 2 {
+			t.Errorf("Expected the first two targets to be linted, got %d results", len(r.results))
+		}
+	})
+
+	t.Run("-fail-fast stops at the first failure", func(t *testing.T) {
+		r, targets := newTestRunner(t)
+		r.maxFailures = failureLimit(5, true)
+
+		stopped := r.lintTargets(context.Background(), context.Background(), targets)
+		if stopped != stoppedByFailures {
+			t.Errorf("Expected the run to stop at the first failure, got %v", stopped)
+		}
+
+		if len(r.results) != 1 {
+			t.Errorf("Expected only the first target to be linted, got %d results", len(r.results))
+		}
+
+		if code := exitCode(r.counts, false, false); code != failureExitCode {
+			t.Errorf("Expected exit code %d, got %d", failureExitCode, code)
+		}
+	})
+
+	t.Run("-max-duration cuts the API call short, and skips the rest of the targets", func(t *testing.T) {
+		r := newHangingRunner(t)
+		targets := []target{{file: "a.yaml", query: "avg:foo{*}"}, {file: "b.yaml", query: "avg:bar{*}"}}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		stopped := r.lintTargets(ctx, context.Background(), targets)
+		if stopped != stoppedByDuration {
+			t.Errorf("Expected the run to stop at -max-duration, got %v", stopped)
+		}
+
+		if r.counts.interrupted != 1 || r.counts.failures != 0 {
+			t.Errorf("Expected the cut short call to be interrupted, not failed, got %d interrupted and %d failures",
+				r.counts.interrupted, r.counts.failures)
+		}
+
+		if code := exitCode(r.counts, false, stopped == stoppedByDuration); code != timedOutExitCode {
+			t.Errorf("Expected exit code %d, got %d", timedOutExitCode, code)
+		}
+	})
+
+	t.Run("a signal cuts the API call short, and skips the rest of the targets", func(t *testing.T) {
+		r := newHangingRunner(t)
+		targets := []target{{file: "a.yaml", query: "avg:foo{*}"}, {file: "b.yaml", query: "avg:bar{*}"}}
+
+		ctx, stopSignals := catchSignals(context.Background())
+		defer stopSignals()
+
+		time.AfterFunc(100*time.Millisecond, func() {
+			interrupt(t)
+		})
+
+		stopped := r.lintTargets(ctx, ctx, targets)
+		if stopped != stoppedBySignal {
+			t.Errorf("Expected the run to stop at the signal, got %v", stopped)
+		}
+
+		if r.counts.interrupted != 1 || r.counts.failures != 0 {
+			t.Errorf("Expected the cut short call to be interrupted, not failed, got %d interrupted and %d failures",
+				r.counts.interrupted, r.counts.failures)
+		}
+
+		if code := exitCode(r.counts, ctx.Err() != nil, false); code != interruptedExitCode {
+			t.Errorf("Expected exit code %d, got %d", interruptedExitCode, code)
+		}
+	})
+
+	t.Run("a signal wins over -max-duration, since it cancels that too", func(t *testing.T) {
+		r, targets := newTestRunner(t)
+
+		signaled, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		ctx, cancelDuration := context.WithTimeout(signaled, time.Hour)
+		defer cancelDuration()
+
+		stopped := r.lintTargets(ctx, signaled, targets)
+		if stopped != stoppedBySignal {
+			t.Errorf("Expected the run to stop at the signal, got %v", stopped)
+		}
+
+		if len(r.results) != 0 {
+			t.Errorf("Expected no targets to be linted, got %d results", len(r.results))
+		}
+	})
+
+	t.Run("failures in the baseline don't count towards -max-failures", func(t *testing.T) {
+		r, targets := newTestRunner(t)
+		r.maxFailures = 1
+		r.known = baseline{{File: "a.yaml", Query: "avg:bar{*}"}: true}
+
+		r.lintTargets(context.Background(), context.Background(), targets)
+
+		if len(r.results) != 2 {
+			t.Errorf("Expected the run to stop after the second target, got %d results", len(r.results))
+		}
+	})
+}
+
+func TestFailureLimit(t *testing.T) {
+	tests := []struct {
+		maxFailures int
+		failFast    bool
+		expected    int
+	}{
+		{0, false, 0},
+		{3, false, 3},
+		{0, true, 1},
+		{3, true, 1},
+	}
+
+	for _, test := range tests {
+		if limit := failureLimit(test.maxFailures, test.failFast); limit != test.expected {
+			t.Errorf("Expected %d for -max-failures %d and -fail-fast %t, got %d",
+				test.expected, test.maxFailures, test.failFast, limit)
+		}
+	}
+}
+
+// Send SIGINT to the test binary itself, as Ctrl-C would.
+func interrupt(t *testing.T) {
+	t.Helper()
+
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Errorf("Failed to find the test process: %v", err)
+
+		return
+	}
+
+	err = process.Signal(os.Interrupt)
+	if err != nil {
+		t.Errorf("Failed to interrupt the test process: %v", err)
+	}
+}
+
+func TestCatchSignals(t *testing.T) {
+	ctx, stopSignals := catchSignals(context.Background())
+	defer stopSignals()
+
+	interrupt(t)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected SIGINT to cancel the context")
+	}
+}
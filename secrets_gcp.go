@@ -0,0 +1,74 @@
+//go:build gcp
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	secretManagerScope = "https://www.googleapis.com/auth/cloud-platform"
+	secretManagerURL   = "https://secretmanager.googleapis.com"
+)
+
+// Fetch the latest (or a specific) version of a GCP Secret Manager secret, using application default credentials. The
+// name is the secret's resource name, e.g. `projects/my-project/secrets/datadog-api-key`, optionally followed by
+// `/versions/<version>`. This talks to the REST API directly, rather than pulling in the whole GCP client library.
+func fetchGCPSecret(ctx context.Context, name string) (string, error) {
+	client, err := google.DefaultClient(ctx, secretManagerScope)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to load GCP application default credentials")
+	}
+
+	return accessSecret(ctx, client, secretManagerURL, name)
+}
+
+// Access the secret with the Secret Manager API at baseURL, using a client that already adds the credentials.
+func accessSecret(ctx context.Context, client *http.Client, baseURL string, name string) (string, error) {
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+
+	url := fmt.Sprintf("%s/v1/%s:access", baseURL, name)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("Failed to build request for secret: %s", name))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("Failed to access secret: %s", name))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to access secret %s: %s", name, resp.Status)
+	}
+
+	var body struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("Failed to decode secret: %s", name))
+	}
+
+	data, err := base64.StdEncoding.DecodeString(body.Payload.Data)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("Failed to decode secret: %s", name))
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
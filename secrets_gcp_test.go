@@ -0,0 +1,89 @@
+//go:build gcp
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccessSecret(t *testing.T) {
+	var paths []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+
+		switch {
+		case strings.Contains(r.URL.Path, "/secrets/api-key/"):
+			fmt.Fprintf(w, `{"payload":{"data":%q}}`, base64.StdEncoding.EncodeToString([]byte("secret-key\n")))
+		case strings.Contains(r.URL.Path, "/secrets/not-base64/"):
+			fmt.Fprint(w, `{"payload":{"data":"not base64!"}}`)
+		case strings.Contains(r.URL.Path, "/secrets/not-json/"):
+			fmt.Fprint(w, `<html>`)
+		default:
+			http.Error(w, "Permission denied", http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	t.Run("the latest version is accessed by default", func(t *testing.T) {
+		paths = nil
+
+		secret, err := accessSecret(context.Background(), server.Client(), server.URL, "projects/p/secrets/api-key")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if secret != "secret-key" {
+			t.Errorf("Expected the secret without the trailing newline, got %q", secret)
+		}
+
+		if len(paths) != 1 || paths[0] != "/v1/projects/p/secrets/api-key/versions/latest:access" {
+			t.Errorf("Expected the latest version to be accessed, got %v", paths)
+		}
+	})
+
+	t.Run("a specific version is kept", func(t *testing.T) {
+		paths = nil
+
+		_, err := accessSecret(context.Background(), server.Client(), server.URL, "projects/p/secrets/api-key/versions/3")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if len(paths) != 1 || paths[0] != "/v1/projects/p/secrets/api-key/versions/3:access" {
+			t.Errorf("Expected version 3 to be accessed, got %v", paths)
+		}
+	})
+
+	errorTests := []struct {
+		name     string
+		secret   string
+		expected string
+	}{
+		{"a secret that can't be accessed", "projects/p/secrets/forbidden", "403 Forbidden"},
+		{"a payload that isn't base64", "projects/p/secrets/not-base64", "Failed to decode secret"},
+		{"a response that isn't json", "projects/p/secrets/not-json", "Failed to decode secret"},
+	}
+
+	for _, test := range errorTests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := accessSecret(context.Background(), server.Client(), server.URL, test.secret)
+			if err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("Expected an error with %q, got %v", test.expected, err)
+			}
+		})
+	}
+
+	t.Run("an unreachable API is an error", func(t *testing.T) {
+		_, err := accessSecret(context.Background(), server.Client(), "http://127.0.0.1:1", "projects/p/secrets/api-key")
+		if err == nil || !strings.Contains(err.Error(), "Failed to access secret") {
+			t.Errorf("Expected an error accessing the secret, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,15 @@
+//go:build !gcp
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// errNoGCPSupport is returned when a GCP secret is asked for, but the binary was built without the `gcp` tag.
+var errNoGCPSupport = errors.New("built without GCP Secret Manager support, rebuild with `-tags gcp`")
+
+func fetchGCPSecret(_ context.Context, _ string) (string, error) {
+	return "", errNoGCPSupport
+}
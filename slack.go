@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// How long to wait for Slack, which mustn't hold up the end of the run for long.
+	slackTimeout = 10 * time.Second
+
+	// How many of the failing queries are listed in the message; Slack truncates long messages anyway.
+	slackMaxFailures = 10
+)
+
+// Build the -slack-webhook message for a run with failures: the totals, followed by the first few failing queries.
+func slackSummary(files int, counts tally, failing []baselineEntry) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, ":x: datadog-query-linter found %d failure(s) and %d warning(s) in %d file(s)",
+		counts.failures, counts.warnings, files)
+
+	for i, entry := range failing {
+		if i == slackMaxFailures {
+			fmt.Fprintf(&b, "\n…and %d more", len(failing)-slackMaxFailures)
+
+			break
+		}
+
+		fmt.Fprintf(&b, "\n• `%s`: `%s`", entry.File, entry.Query)
+	}
+
+	return b.String()
+}
+
+// Post the message to a Slack incoming webhook.
+func postSlack(ctx context.Context, webhook string, message string) error {
+	ctx, cancel := context.WithTimeout(ctx, slackTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(map[string]string{"text": message})
+	if err != nil {
+		return errors.Wrap(err, "Failed to marshal Slack message")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "Failed to build Slack request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "Failed to post to Slack")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSlackSummary(t *testing.T) {
+	var failing []baselineEntry
+
+	for i := range slackMaxFailures + 2 {
+		failing = append(failing, baselineEntry{File: fmt.Sprintf("%d.yaml", i), Query: "avg:foo{*}"})
+	}
+
+	message := slackSummary(20, tally{failures: 12, warnings: 3}, failing)
+
+	if !strings.HasPrefix(message, ":x: datadog-query-linter found 12 failure(s) and 3 warning(s) in 20 file(s)\n") {
+		t.Errorf("Expected the totals first, got %q", message)
+	}
+
+	if !strings.Contains(message, "\n• `0.yaml`: `avg:foo{*}`") || strings.Contains(message, "`10.yaml`") {
+		t.Errorf("Expected only the first %d failures to be listed, got %q", slackMaxFailures, message)
+	}
+
+	if !strings.HasSuffix(message, "\n…and 2 more") {
+		t.Errorf("Expected the rest to be counted, got %q", message)
+	}
+}
+
+func TestPostSlack(t *testing.T) {
+	t.Run("the message is posted as text", func(t *testing.T) {
+		var received map[string]string
+
+		server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			_ = json.NewDecoder(r.Body).Decode(&received)
+		}))
+		defer server.Close()
+
+		if err := postSlack(context.Background(), server.URL, "hello"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if received["text"] != "hello" {
+			t.Errorf("Expected the text to be hello, got %v", received)
+		}
+	})
+
+	t.Run("a rejected message is an error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, "invalid_token", http.StatusForbidden)
+		}))
+		defer server.Close()
+
+		if err := postSlack(context.Background(), server.URL, "hello"); err == nil {
+			t.Errorf("Expected an error but didn't receive one.")
+		}
+	})
+}
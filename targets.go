@@ -0,0 +1,250 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/persona-id/datadog-query-linter/querylint"
+	"github.com/pkg/errors"
+)
+
+// The kinds of file that queries can be extracted from, set with -kind.
+const (
+	kindDatadogMetric = "datadogmetric" // DatadogMetric custom resources, or any yaml with the query at -query-path
+	kindTerraform     = "terraform"     // Terraform files with Datadog monitor and SLO resources
+	kindSLO           = "slo"           // SLO yaml, with a numerator and denominator query under -query-path
+	kindFormula       = "formula"       // Yaml with named queries combined by a formula, under spec by default
+)
+
+// A query to lint, and where it came from.
+type target struct {
+	file  string // The file the query was found in
+	name  string // Where the query is in the file, for kinds that can have more than one, e.g. datadog_monitor.cpu
+	query string
+	line  int // The line the query is on, starting from 1, or 0 if it isn't known
+}
+
+// How the target is identified in the logs: the file, followed by the name if there is one.
+func (t target) String() string {
+	if t.name == "" {
+		return t.file
+	}
+
+	return fmt.Sprintf("%s:%s", t.file, t.name)
+}
+
+// Extract the queries from the contents of a file of the given kind. The file is only used in error messages, and to
+// fill in the targets.
+func extractTargets(kind string, file string, data []byte, queryPath string) ([]target, error) {
+	if kind == kindSLO {
+		return extractSLOTargets(file, data, queryPath)
+	}
+
+	if kind == kindFormula {
+		return extractFormulaTargets(file, data, queryPath)
+	}
+
+	if kind == kindTerraform {
+		queries, err := querylint.ExtractTerraformQueries(data, file)
+		if err != nil {
+			return nil, err
+		}
+
+		targets := make([]target, 0, len(queries))
+
+		for _, query := range queries {
+			targets = append(targets, target{file: file, name: query.Resource, query: query.Query, line: query.Line})
+		}
+
+		return targets, nil
+	}
+
+	queries, err := querylint.ExtractQueriesFromBytes(data, file, queryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// A file with a single query is the usual case, and is named after the file alone. With several documents, each
+	// is named after its metadata.name, or its position in the file, and with several queries in a document, each is
+	// named after the field it's in too.
+	if len(queries) == 1 {
+		return []target{{file: file, query: queries[0].Query, line: queries[0].Line}}, nil
+	}
+
+	perDocument := map[int]int{}
+	for _, query := range queries {
+		perDocument[query.Document]++
+	}
+
+	targets := make([]target, 0, len(queries))
+
+	for _, query := range queries {
+		var name []string
+
+		if len(perDocument) > 1 {
+			name = append(name, cmp.Or(query.Name, fmt.Sprintf("document-%d", query.Document+1)))
+		}
+
+		if perDocument[query.Document] > 1 {
+			name = append(name, query.Field)
+		}
+
+		targets = append(targets, target{file: file, name: strings.Join(name, ":"), query: query.Query, line: query.Line})
+	}
+
+	return targets, nil
+}
+
+// Extract the numerator and denominator queries of an SLO, e.g. from `spec.query.numerator`, as separate targets, so
+// the logs show which of them is broken. An SLO with only one of them is an error, since it can't be evaluated.
+func extractSLOTargets(file string, data []byte, queryPath string) ([]target, error) {
+	parts := []string{"numerator", "denominator"}
+
+	var targets []target
+
+	for _, part := range parts {
+		query, err := querylint.ExtractQueryFromBytes(data, file, queryPath+"."+part)
+		if err != nil {
+			return nil, err
+		}
+
+		if query != "" {
+			targets = append(targets, target{file: file, name: part, query: query, line: querylint.QueryLine(data, queryPath+"."+part)})
+		}
+	}
+
+	if len(targets) == 1 {
+		missing := parts[0]
+		if targets[0].name == missing {
+			missing = parts[1]
+		}
+
+		return nil, fmt.Errorf("SLO has a %s, but no %s at %s.%s: %s", targets[0].name, missing, queryPath, missing, file)
+	}
+
+	return targets, nil
+}
+
+// Extract the named queries of a formula definition as separate targets, named after the queries. The formula itself is
+// checked statically; one that refers to a query that isn't defined, or doesn't use one that is, is an error. The
+// default -query-path is for a single query, so the definition is looked for at `spec` unless it's been changed.
+func extractFormulaTargets(file string, data []byte, queryPath string) ([]target, error) {
+	if queryPath == querylint.DefaultQueryPath {
+		queryPath = querylint.DefaultFormulaPath
+	}
+
+	definition, err := querylint.ExtractFormulaFromBytes(data, file, queryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if definition.Formula == "" && len(definition.Queries) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(definition.Queries))
+	targets := make([]target, 0, len(definition.Queries))
+
+	for i, query := range definition.Queries {
+		names = append(names, query.Name)
+		targets = append(targets, target{
+			file:  file,
+			name:  query.Name,
+			query: query.Query,
+			line:  querylint.QueryLine(data, fmt.Sprintf("%s.queries.%d.query", queryPath, i)),
+		})
+	}
+
+	problems := querylint.CheckFormula(definition.Formula, names)
+	if len(problems) > 0 {
+		messages := make([]string, 0, len(problems))
+		for _, problem := range problems {
+			messages = append(messages, problem.Message)
+		}
+
+		return nil, fmt.Errorf("invalid formula %q: %s: %s", definition.Formula, strings.Join(messages, "; "), file)
+	}
+
+	return targets, nil
+}
+
+// Read each of the files, and extract the queries to lint from them. A file that can't be read or parsed counts as a
+// failure, while one that isn't text at all is skipped with a warning. A file without any queries is skipped with a
+// warning too, unless emptyIsError is set, in which case it's a failure. With quietSkips, the files that are skipped
+// aren't logged at all.
+func collectTargets(
+	files []string,
+	kind string,
+	queryPath string,
+	emptyIsError bool,
+	quietSkips bool,
+	counts *tally,
+) []target {
+	var targets []target
+
+	for _, file := range files {
+		found, err := readTargets(kind, file, queryPath)
+		if errors.Is(err, querylint.ErrBinaryFile) {
+			// Not a manifest at all, so there's nothing to lint.
+			if !quietSkips {
+				slog.Warn("File isn't text, skipping it", slog.String("filename", file))
+			}
+
+			continue
+		}
+
+		if err != nil {
+			slog.Error("Error extracting query from file",
+				slog.String("filename", file),
+				slog.Any("err", err),
+			)
+
+			counts.fail(reasonExtract, file)
+
+			continue
+		}
+
+		// The file was valid, but didnt contain a query, so while it's technically invalid, this shouldn't count as a
+		// failure for the linting process. Just move on and dont increment `failures`, unless every file is meant to
+		// have a query.
+		if len(found) == 0 && emptyIsError {
+			slog.Error("File didn't contain a metric query", slog.String("filename", file))
+
+			counts.fail(reasonEmptyFile, file)
+
+			continue
+		}
+
+		if len(found) == 0 {
+			if !quietSkips {
+				slog.Warn("File didn't contain a metric query, skipping it", slog.String("filename", file))
+			}
+
+			continue
+		}
+
+		for _, t := range found {
+			// Terraform interpolation is only resolved at plan time, so the query can't be checked as it is.
+			if kind == kindTerraform && strings.Contains(t.query, "${") {
+				slog.Warn("Query uses Terraform interpolation, skipping it", slog.String("file", t.String()))
+				continue
+			}
+
+			targets = append(targets, t)
+		}
+	}
+
+	return targets
+}
+
+func readTargets(kind string, file string, queryPath string) ([]target, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Failed to read file: %s", file))
+	}
+
+	return extractTargets(kind, file, data, queryPath)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/persona-id/datadog-query-linter/querylint"
+)
+
+func TestExtractSLOTargets(t *testing.T) {
+	t.Run("the numerator and denominator are separate targets", func(t *testing.T) {
+		data := []byte(`
+spec:
+  query:
+    numerator: sum:requests.ok{service:web}.as_count()
+    denominator: sum:requests.total{service:web}.as_count()
+`)
+
+		targets, err := extractTargets(kindSLO, "slo.yaml", data, "spec.query")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		expected := []target{
+			{file: "slo.yaml", name: "numerator", query: "sum:requests.ok{service:web}.as_count()", line: 4},
+			{file: "slo.yaml", name: "denominator", query: "sum:requests.total{service:web}.as_count()", line: 5},
+		}
+
+		if len(targets) != len(expected) {
+			t.Fatalf("Expected %d targets, got %v", len(expected), targets)
+		}
+
+		for i, target := range targets {
+			if target != expected[i] {
+				t.Errorf("Expected %+v, got %+v", expected[i], target)
+			}
+		}
+
+		if targets[0].String() != "slo.yaml:numerator" {
+			t.Errorf("Expected slo.yaml:numerator, got %s", targets[0])
+		}
+	})
+
+	t.Run("only one of them is an error", func(t *testing.T) {
+		data := []byte("spec:\n  query:\n    numerator: sum:requests.ok{*}\n")
+
+		if _, err := extractTargets(kindSLO, "slo.yaml", data, "spec.query"); err == nil {
+			t.Fatalf("Expected an error but didn't receive one.")
+		}
+	})
+
+	t.Run("neither of them is nothing to lint", func(t *testing.T) {
+		targets, err := extractTargets(kindSLO, "slo.yaml", []byte("spec:\n  name: foo\n"), "spec.query")
+		if err != nil || len(targets) != 0 {
+			t.Errorf("Expected no targets and no error, got %v and %v", targets, err)
+		}
+	})
+}
+
+func TestExtractFormulaTargets(t *testing.T) {
+	t.Run("each named query is a target", func(t *testing.T) {
+		data := []byte(`
+spec:
+  formula: a / b * 100
+  queries:
+    - name: a
+      query: sum:requests.errors{*}.as_count()
+    - name: b
+      query: sum:requests.total{*}.as_count()
+`)
+
+		targets, err := extractTargets(kindFormula, "formula.yaml", data, "spec.query")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if len(targets) != 2 || targets[0].String() != "formula.yaml:a" || targets[1].query != "sum:requests.total{*}.as_count()" {
+			t.Errorf("Expected targets for a and b, got %v", targets)
+		}
+	})
+
+	t.Run("a formula referring to an undefined query is an error", func(t *testing.T) {
+		data := []byte("spec:\n  formula: a / c\n  queries:\n    - {name: a, query: \"avg:a{*}\"}\n    - {name: b, query: \"avg:b{*}\"}\n")
+
+		_, err := extractTargets(kindFormula, "formula.yaml", data, "spec.query")
+		if err == nil {
+			t.Fatalf("Expected an error but didn't receive one.")
+		}
+
+		expected := `invalid formula "a / c": formula refers to "c", which isn't one of the defined queries; ` +
+			`query "b" is defined, but the formula doesn't use it: formula.yaml`
+		if err.Error() != expected {
+			t.Errorf("Expected %q, got %q", expected, err)
+		}
+	})
+}
+
+func TestTargetLines(t *testing.T) {
+	t.Run("yaml", func(t *testing.T) {
+		data := []byte("apiVersion: datadoghq.com/v1alpha1\nkind: DatadogMetric\nspec:\n  query: avg:foo{*}\n")
+
+		targets, err := extractTargets(kindDatadogMetric, "metric.yaml", data, "spec.query")
+		if err != nil || len(targets) != 1 {
+			t.Fatalf("Expected 1 target and no error, got %v and %v", targets, err)
+		}
+
+		if targets[0].line != 4 {
+			t.Errorf("Expected line 4, got %d", targets[0].line)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		data := []byte("{\n  \"spec\": {\n    \"query\": \"avg:foo{*}\"\n  }\n}\n")
+
+		targets, err := extractTargets(kindDatadogMetric, "metric.json", data, "spec.query")
+		if err != nil || len(targets) != 1 {
+			t.Fatalf("Expected 1 target and no error, got %v and %v", targets, err)
+		}
+
+		if targets[0].line != 3 {
+			t.Errorf("Expected line 3, got %d", targets[0].line)
+		}
+	})
+}
+
+func TestExtractMultipleDocuments(t *testing.T) {
+	data := []byte("spec:\n  query: avg:foo{*}\nmetadata:\n  name: foo\n---\nspec:\n  query: avg:bar{*}\n")
+
+	targets, err := extractTargets(kindDatadogMetric, "rendered.yaml", data, "spec.query")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(targets) != 2 || targets[0].String() != "rendered.yaml:foo" || targets[1].String() != "rendered.yaml:document-2" {
+		t.Errorf("Expected a target per document, named after the document, got %v", targets)
+	}
+}
+
+func TestCollectTargetsSkipsOtherFiles(t *testing.T) {
+	counts := tally{}
+
+	files := []string{"tests/serviceaccount-web-workflows.yaml", "tests/invalid-yaml.yaml", "tests/datadogmetric-working.yaml"}
+
+	targets := collectTargets(files, kindDatadogMetric, querylint.DefaultQueryPath, false, true, &counts)
+	if len(targets) != 1 || targets[0].file != "tests/datadogmetric-working.yaml" {
+		t.Errorf("Expected only the DatadogMetric to be linted, got %+v", targets)
+	}
+
+	if counts.failures != 0 || counts.warnings != 0 {
+		t.Errorf("Expected the other files to be skipped without failures or warnings, got %+v", counts)
+	}
+}
+
+func TestExtractQueryFields(t *testing.T) {
+	data := []byte(`spec:
+  externalMetricName: web-latency
+  query: avg:foo{*}
+  queries:
+    - avg:bar{*}
+    - name: baz
+      query: avg:baz{*}
+`)
+
+	targets, err := extractTargets(kindDatadogMetric, "metric.yaml", data, "spec.query")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []string{"metric.yaml:spec.query", "metric.yaml:spec.queries.0", "metric.yaml:spec.queries.1.query"}
+	if len(targets) != len(expected) {
+		t.Fatalf("Expected %d targets, got %v", len(expected), targets)
+	}
+
+	for i, target := range targets {
+		if target.String() != expected[i] {
+			t.Errorf("Expected a target named after its field, %s, got %s", expected[i], target)
+		}
+	}
+
+	if targets[2].query != "avg:baz{*}" || targets[2].line != 7 {
+		t.Errorf("Expected avg:baz{*} on line 7, got %q on line %d", targets[2].query, targets[2].line)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/persona-id/datadog-query-linter/querylint"
+	"github.com/pkg/errors"
+)
+
+// The data an -output-template is rendered with, once the run is over.
+type templateData struct {
+	Results  []templateResult // Every query that was parsed, in the order they were linted
+	Files    int              // How many files were linted
+	Failures int              // The failures across the whole run, including ones that aren't tied to a result
+	Warnings int              // The warnings across the whole run
+	Skipped  int              // The queries that weren't validated, since they're unchanged from -base-ref
+	TimedOut bool             // Whether the run was cut short by -max-duration
+}
+
+// The outcome for a single query. The querylint.Result is embedded, so its fields, like .Value, .Metrics and
+// .Analysis.Problems, can be used directly.
+type templateResult struct {
+	querylint.Result
+
+	File    string // Where the query came from, as it's logged, e.g. `monitors.tf:datadog_monitor.cpu`
+	Line    int    // The line the query is on in the file, starting from 1, or 0 if it isn't known
+	Err     error  // The error from validating the query, if the API rejected it
+	Skipped bool   // Whether the query wasn't validated, since it's unchanged from -base-ref
+}
+
+// Parse an -output-template. Besides the text/template builtins, it can use `join` (strings.Join) and `deref`, to get
+// at a *float64 value like .Value, which is nil when there was no data.
+func loadOutputTemplate(path string) (*template.Template, error) {
+	funcs := template.FuncMap{
+		"join": strings.Join,
+		"deref": func(value *float64) float64 {
+			if value == nil {
+				return 0
+			}
+
+			return *value
+		},
+	}
+
+	tmpl, err := template.New(filepath.Base(path)).Funcs(funcs).ParseFiles(path)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Failed to parse output template: %s", path))
+	}
+
+	return tmpl, nil
+}
+
+// Render the template to w, which is stdout outside of the tests, bypassing the logger so the output is exactly what
+// the template says.
+func renderOutputTemplate(w io.Writer, tmpl *template.Template, data templateData) error {
+	err := tmpl.Execute(w, data)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Failed to render output template: %s", tmpl.Name()))
+	}
+
+	return nil
+}
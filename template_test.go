@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/persona-id/datadog-query-linter/querylint"
+)
+
+func TestOutputTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.tmpl")
+
+	contents := `{{ .Failures }} failure(s) in {{ .Files }} file(s)
+{{ range .Results }}{{ .File }}: {{ if .Err }}error: {{ .Err }}{{ else if .Value }}{{ deref .Value }}{{ else }}no data{{ end }}
+{{ end }}`
+
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	tmpl, err := loadOutputTemplate(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	value := 42.0
+	data := templateData{
+		Files:    3,
+		Failures: 1,
+		Results: []templateResult{
+			{File: "a.yaml", Result: querylint.Result{Value: &value}},
+			{File: "b.yaml"},
+			{File: "c.yaml", Err: errors.New("Error parsing query")},
+		},
+	}
+
+	var out bytes.Buffer
+
+	if err := renderOutputTemplate(&out, tmpl, data); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := "1 failure(s) in 3 file(s)\na.yaml: 42\nb.yaml: no data\nc.yaml: error: Error parsing query\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+
+	if err := os.WriteFile(path, []byte("{{ .Missing "), 0o600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := loadOutputTemplate(path); err == nil {
+		t.Errorf("Expected an error for an invalid template")
+	}
+}
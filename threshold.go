@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The comparators a -report-threshold can use. The two character ones come first, so `>=` isn't read as `>` and `=1`.
+//
+//nolint:gochecknoglobals
+var thresholdComparators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// A -report-threshold, e.g. `>0` for an error rate that should always be zero.
+type threshold struct {
+	comparator string
+	value      float64
+}
+
+func (t threshold) String() string {
+	return t.comparator + strconv.FormatFloat(t.value, 'g', -1, 64)
+}
+
+// Whether the value satisfies the threshold's condition, i.e. crosses it.
+func (t threshold) crossedBy(value float64) bool {
+	switch t.comparator {
+	case ">=":
+		return value >= t.value
+	case "<=":
+		return value <= t.value
+	case "==":
+		return value == t.value
+	case "!=":
+		return value != t.value
+	case ">":
+		return value > t.value
+	default:
+		return value < t.value
+	}
+}
+
+// Parse a threshold like `>0` or `<= 0.5`: one of >, <, >=, <=, == or !=, followed by a number.
+func parseThreshold(condition string) (threshold, error) {
+	condition = strings.TrimSpace(condition)
+
+	for _, comparator := range thresholdComparators {
+		number, ok := strings.CutPrefix(condition, comparator)
+		if !ok {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+		if err != nil {
+			return threshold{}, errors.Wrap(err, fmt.Sprintf("Failed to parse threshold: %s", condition))
+		}
+
+		return threshold{comparator: comparator, value: value}, nil
+	}
+
+	return threshold{}, fmt.Errorf("threshold must start with one of %s: %s", strings.Join(thresholdComparators, ", "), condition)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseThreshold(t *testing.T) {
+	t.Run("conditions", func(t *testing.T) {
+		tests := []struct {
+			condition string
+			value     float64
+			crossed   bool
+		}{
+			{">0", 0.1, true},
+			{">0", 0, false},
+			{">= 1", 1, true},
+			{"<1", 1, false},
+			{"<=-0.5", -1, true},
+			{"==2", 2, true},
+			{"!=2", 2, false},
+			{"<1e3", 999, true},
+		}
+
+		for _, test := range tests {
+			parsed, err := parseThreshold(test.condition)
+			if err != nil {
+				t.Fatalf("Expected no error for %q, got %v", test.condition, err)
+			}
+
+			if crossed := parsed.crossedBy(test.value); crossed != test.crossed {
+				t.Errorf("Expected %v crossing %q to be %v, got %v", test.value, test.condition, test.crossed, crossed)
+			}
+		}
+	})
+
+	t.Run("invalid thresholds", func(t *testing.T) {
+		for _, condition := range []string{"", "0", "=>1", ">", ">x"} {
+			if _, err := parseThreshold(condition); err == nil {
+				t.Errorf("Expected an error for %q", condition)
+			}
+		}
+	})
+}
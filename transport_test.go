@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHTTPClientProxy(t *testing.T) {
+	t.Run("an explicit proxy is used for every request", func(t *testing.T) {
+		client, err := newHTTPClient(httpOptions{proxyURL: "http://proxy.internal:3128"})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		transport, ok := client.Transport.(*http.Transport)
+		if !ok {
+			t.Fatalf("Expected an *http.Transport, got %T", client.Transport)
+		}
+
+		req, _ := http.NewRequest(http.MethodGet, "https://api.datadoghq.com/api/v1/query", nil)
+
+		proxy, err := transport.Proxy(req)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if proxy == nil || proxy.String() != "http://proxy.internal:3128" {
+			t.Errorf("Expected the request to go through http://proxy.internal:3128, got %v", proxy)
+		}
+	})
+
+	t.Run("proxy URLs need a scheme and host", func(t *testing.T) {
+		if _, err := newHTTPClient(httpOptions{proxyURL: "proxy.internal:3128"}); err == nil {
+			t.Fatalf("Expected an error but didn't receive one.")
+		}
+	})
+}
+
+func TestHTTPClientConnections(t *testing.T) {
+	t.Run("connection limits are applied to the transport", func(t *testing.T) {
+		client, err := newHTTPClient(httpOptions{maxIdleConnsPerHost: 200, maxConnsPerHost: 300})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		transport, ok := client.Transport.(*http.Transport)
+		if !ok {
+			t.Fatalf("Expected an *http.Transport, got %T", client.Transport)
+		}
+
+		if transport.MaxIdleConnsPerHost != 200 || transport.MaxConnsPerHost != 300 {
+			t.Errorf("Expected 200 idle and 300 max connections per host, got %d and %d",
+				transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost)
+		}
+
+		if transport.MaxIdleConns < 200 {
+			t.Errorf("Expected the overall idle connections to fit the per host ones, got %d", transport.MaxIdleConns)
+		}
+	})
+
+	t.Run("zero keeps the defaults", func(t *testing.T) {
+		client, err := newHTTPClient(httpOptions{})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		transport, ok := client.Transport.(*http.Transport)
+		if !ok {
+			t.Fatalf("Expected an *http.Transport, got %T", client.Transport)
+		}
+
+		if transport.MaxIdleConnsPerHost != 0 || transport.MaxConnsPerHost != 0 {
+			t.Errorf("Expected the default connection limits, got %d and %d",
+				transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost)
+		}
+	})
+}
+
+func TestHTTPClientTLS(t *testing.T) {
+	t.Run("extra CAs are trusted", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		caFile := filepath.Join(t.TempDir(), "ca.pem")
+		caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+		if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+			t.Fatalf("Failed to write CA bundle: %v", err)
+		}
+
+		client, err := newHTTPClient(httpOptions{caCertFile: caFile})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Expected the server's certificate to be trusted, got %v", err)
+		}
+
+		resp.Body.Close()
+	})
+
+	t.Run("bundles without certificates are an error", func(t *testing.T) {
+		caFile := filepath.Join(t.TempDir(), "ca.pem")
+
+		if err := os.WriteFile(caFile, []byte("not a certificate"), 0o600); err != nil {
+			t.Fatalf("Failed to write CA bundle: %v", err)
+		}
+
+		if _, err := newHTTPClient(httpOptions{caCertFile: caFile}); err == nil {
+			t.Fatalf("Expected an error but didn't receive one.")
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A repeatable flag holding glob patterns, like `**/datadogmetric-*.yaml`. `**` matches any number of directories, `*`
+// and `?` match within a single path segment.
+type globList []*regexp.Regexp
+
+func (g *globList) String() string {
+	patterns := make([]string, len(*g))
+
+	for i, pattern := range *g {
+		patterns[i] = pattern.String()
+	}
+
+	return strings.Join(patterns, ",")
+}
+
+func (g *globList) Set(glob string) error {
+	pattern, err := globToRegexp(glob)
+	if err != nil {
+		return err
+	}
+
+	*g = append(*g, pattern)
+
+	return nil
+}
+
+// Matches reports whether the slash separated path matches any of the patterns.
+func (g globList) Matches(path string) bool {
+	for _, pattern := range g {
+		if pattern.MatchString(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+
+	b.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+
+			i++
+		case glob[i] == '*':
+			b.WriteString("[^/]*")
+		case glob[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	b.WriteString("$")
+
+	pattern, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Failed to parse glob: %s", glob))
+	}
+
+	return pattern, nil
+}
+
+// Expand any directories in paths into the files inside them, recursively, keeping the files that match one of the
+// include patterns (or all of them, if there aren't any), and don't match any of the exclude patterns. Paths that are
+// files are kept as they are, since they were asked for explicitly.
+func expandPaths(paths []string, include globList, exclude globList) ([]string, error) {
+	var files []string
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.IsDir() {
+			// Let extraction report the missing file, along with any other problems.
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(walked string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			slashed := filepath.ToSlash(walked)
+
+			if entry.IsDir() {
+				// An excluded directory can be skipped entirely, e.g. `**/examples/**`.
+				if walked != path && exclude.Matches(slashed+"/") {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			if exclude.Matches(slashed) || (len(include) > 0 && !include.Matches(slashed)) {
+				return nil
+			}
+
+			files = append(files, walked)
+
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Failed to scan directory: %s", path))
+		}
+	}
+
+	return files, nil
+}
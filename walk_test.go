@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestGlobList(t *testing.T) {
+	tests := []struct {
+		glob    string
+		path    string
+		matches bool
+	}{
+		{"**/datadogmetric-*.yaml", "k8s/web/datadogmetric-cpu.yaml", true},
+		{"**/datadogmetric-*.yaml", "datadogmetric-cpu.yaml", true},
+		{"**/datadogmetric-*.yaml", "k8s/web/deployment.yaml", false},
+		{"**/examples/**", "k8s/examples/datadogmetric-cpu.yaml", true},
+		{"**/examples/**", "k8s/examples-old/datadogmetric-cpu.yaml", false},
+		{"k8s/*.yaml", "k8s/web/datadogmetric-cpu.yaml", false},
+		{"k8s/?eb/*.yaml", "k8s/web/datadogmetric-cpu.yaml", true},
+	}
+
+	for _, test := range tests {
+		var globs globList
+
+		if err := globs.Set(test.glob); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if globs.Matches(test.path) != test.matches {
+			t.Errorf("Expected %q matching %q to be %v", test.glob, test.path, test.matches)
+		}
+	}
+}
+
+func TestExpandPaths(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, file := range []string{
+		"web/datadogmetric-cpu.yaml",
+		"web/deployment.yaml",
+		"examples/datadogmetric-example.yaml",
+		"api/datadogmetric-latency.yaml",
+	} {
+		path := filepath.Join(dir, file)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	var include, exclude globList
+
+	_ = include.Set("**/datadogmetric-*.yaml")
+	_ = exclude.Set("**/examples/**")
+
+	files, err := expandPaths([]string{dir, "explicit.yaml"}, include, exclude)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []string{
+		filepath.Join(dir, "api/datadogmetric-latency.yaml"),
+		filepath.Join(dir, "web/datadogmetric-cpu.yaml"),
+		"explicit.yaml",
+	}
+
+	if !slices.Equal(files, expected) {
+		t.Errorf("Expected %v, got %v", expected, files)
+	}
+}
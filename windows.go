@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	day  = 24 * time.Hour
+	week = 7 * day
+)
+
+// Parse a comma separated list of relative windows, like `-1h,-24h,-7d`. The leading `-` is optional, since the windows
+// always look back from now. As well as the units time.ParseDuration understands, `d` and `w` can be used for days and
+// weeks.
+func parseWindows(list string) ([]time.Duration, error) {
+	var windows []time.Duration
+
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimPrefix(strings.TrimSpace(field), "-")
+		if field == "" {
+			continue
+		}
+
+		window, err := parseWindow(field)
+		if err != nil {
+			return nil, err
+		}
+
+		if window <= 0 {
+			return nil, fmt.Errorf("window must be longer than zero: %s", field)
+		}
+
+		windows = append(windows, window)
+	}
+
+	return windows, nil
+}
+
+func parseWindow(field string) (time.Duration, error) {
+	unit := time.Duration(0)
+
+	switch {
+	case strings.HasSuffix(field, "d"):
+		unit = day
+	case strings.HasSuffix(field, "w"):
+		unit = week
+	}
+
+	if unit == 0 {
+		window, err := time.ParseDuration(field)
+		if err != nil {
+			return 0, errors.Wrap(err, fmt.Sprintf("Failed to parse window: %s", field))
+		}
+
+		return window, nil
+	}
+
+	count, err := strconv.ParseFloat(field[:len(field)-1], 64)
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("Failed to parse window: %s", field))
+	}
+
+	return time.Duration(count * float64(unit)), nil
+}
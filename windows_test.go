@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindows(t *testing.T) {
+	t.Run("relative windows", func(t *testing.T) {
+		windows, err := parseWindows("-1h, -24h,-7d,2w,90s")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		expected := []time.Duration{time.Hour, 24 * time.Hour, 7 * 24 * time.Hour, 14 * 24 * time.Hour, 90 * time.Second}
+		if len(windows) != len(expected) {
+			t.Fatalf("Expected %v, got %v", expected, windows)
+		}
+
+		for i := range expected {
+			if windows[i] != expected[i] {
+				t.Errorf("Expected %v, got %v", expected[i], windows[i])
+			}
+		}
+	})
+
+	t.Run("invalid windows", func(t *testing.T) {
+		for _, list := range []string{"-1x", "-xd", "0s"} {
+			if _, err := parseWindows(list); err == nil {
+				t.Errorf("Expected an error for %q", list)
+			}
+		}
+	})
+}